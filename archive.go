@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// boltMagic is in the meta page, just after the page header
+const (
+	boltMagic       = 0xED0CDAED
+	boltMagicOffset = 16
+)
+
+// Extracted copies of databases, removed by removeTempFiles on the way out
+var tempFiles struct {
+	sync.Mutex
+	names   []string
+	watched bool
+}
+
+/*
+extractDatabase makes 'name' something bolt.Open can open. A gzip or zstd
+file, a tarball with a bolt file in it (compressed or not) or "-" (stdin)
+is extracted to a temp file, and that's returned with extracted set. Plain
+files are returned as they are. Changes to a temp file would be lost, so
+it should be opened read-only
+*/
+func extractDatabase(name string) (string, bool, error) {
+	in := os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			// bolt.Open makes a new database
+			return name, false, nil
+		} else if err != nil {
+			return "", false, err
+		}
+		defer f.Close()
+		in = f
+	}
+	r, layers, err := decompress(bufio.NewReader(in))
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %s", name, err)
+	}
+	if layers == 0 && name != "-" {
+		return name, false, nil
+	}
+	out, err := os.CreateTemp("", "bolt-*.db")
+	if err != nil {
+		return "", false, err
+	}
+	addTempFile(out.Name())
+	_, err = io.Copy(out, r)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %s", name, err)
+	}
+	return out.Name(), true, nil
+}
+
+/*
+decompress peels gzip, zstd and tar off 'r' until what's left isn't any
+of them, returning how many it took off. Out of a tarball it takes the
+first file that starts like a bolt database
+*/
+func decompress(r *bufio.Reader) (io.Reader, int, error) {
+	for layers := 0; ; layers++ {
+		head, err := r.Peek(512)
+		if err != nil && err != io.EOF {
+			return nil, layers, err
+		}
+		switch {
+		case bytes.HasPrefix(head, gzipMagic):
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, layers, err
+			}
+			r = bufio.NewReader(gz)
+		case bytes.HasPrefix(head, zstdMagic):
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, layers, err
+			}
+			r = bufio.NewReader(zr.IOReadCloser())
+		case len(head) >= 262 && string(head[257:262]) == "ustar":
+			db, err := boltFileInTar(tar.NewReader(r))
+			if err != nil {
+				return nil, layers, err
+			}
+			r = bufio.NewReader(db)
+		default:
+			return r, layers, nil
+		}
+	}
+}
+
+func boltFileInTar(tr *tar.Reader) (io.Reader, error) {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no bolt database in the tarball")
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		head := make([]byte, boltMagicOffset+4)
+		n, err := io.ReadFull(tr, head)
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// bolt writes its magic number in the machine's byte order
+		magic := head[boltMagicOffset:]
+		if binary.LittleEndian.Uint32(magic) == boltMagic || binary.BigEndian.Uint32(magic) == boltMagic {
+			return io.MultiReader(bytes.NewReader(head[:n]), tr), nil
+		}
+	}
+}
+
+// addTempFile remembers a temp file, and makes sure it goes even if we're killed
+func addTempFile(name string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	tempFiles.names = append(tempFiles.names, name)
+	if tempFiles.watched {
+		return
+	}
+	tempFiles.watched = true
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-c
+		exit(1)
+	}()
+}
+
+func removeTempFiles() {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	for _, name := range tempFiles.names {
+		os.Remove(name)
+	}
+	tempFiles.names = nil
+}
+
+// exit is os.Exit that doesn't leave any temp files behind
+func exit(code int) {
+	removeTempFiles()
+	os.Exit(code)
+}
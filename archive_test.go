@@ -0,0 +1,119 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestExtractDatabase(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.db")
+	bdb, err := bolt.Open(plain, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("b"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	})
+	bdb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzipped := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(b)
+		gz.Close()
+		return buf.Bytes()
+	}
+	zstded := func(b []byte) []byte {
+		enc, _ := zstd.NewWriter(nil)
+		defer enc.Close()
+		return enc.EncodeAll(b, nil)
+	}
+	tarred := func(b []byte) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		// Something that isn't a database first
+		for _, f := range []struct {
+			name string
+			data []byte
+		}{{"README", []byte("backup of plain.db")}, {"backup/plain.db", b}} {
+			tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0600, Size: int64(len(f.data)), Typeflag: tar.TypeReg})
+			tw.Write(f.data)
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+	for name, content := range map[string][]byte{
+		"db.gz":      gzipped(data),
+		"db.zst":     zstded(data),
+		"db.tar":     tarred(data),
+		"db.tar.gz":  gzipped(tarred(data)),
+		"db.tar.zst": zstded(tarred(data)),
+	} {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		out, extracted, err := extractDatabase(file)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		got, _ := os.ReadFile(out)
+		if !extracted || !bytes.Equal(got, data) {
+			t.Errorf("%s: extracted %v, %d bytes, want %d", name, extracted, len(got), len(data))
+		}
+	}
+
+	if out, extracted, err := extractDatabase(plain); err != nil || extracted || out != plain {
+		t.Errorf("plain file: got %s %v %v", out, extracted, err)
+	}
+	noDB := filepath.Join(dir, "nodb.tar")
+	os.WriteFile(noDB, tarred([]byte("too short to be a database")), 0600)
+	if _, _, err := extractDatabase(noDB); err == nil {
+		t.Error("no error for a tarball without a database")
+	}
+
+	tempFiles.Lock()
+	temps := append([]string(nil), tempFiles.names...)
+	tempFiles.Unlock()
+	removeTempFiles()
+	for _, f := range temps {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s is still there", f)
+		}
+	}
+}
+
+func TestTrimArchiveExt(t *testing.T) {
+	for name, want := range map[string]string{
+		"backup.db.gz":        "backup.db",
+		"dir/backup.tar.zst":  "dir/backup",
+		"backup.tgz":          "backup",
+		"backup.db.tar.gz.gz": "backup.db",
+		"plain.db":            "plain.db",
+		".gz":                 ".gz",
+	} {
+		if got := trimArchiveExt(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
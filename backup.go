@@ -0,0 +1,170 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// How often we retry the lock (and tell the user we're still waiting)
+const backupLockPollInterval = time.Second
+
+/*
+progressWriter passes writes through to w and reports
+how far along we are every time another percent is written
+*/
+type progressWriter struct {
+	w       io.Writer
+	total   int64
+	written int64
+	percent int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	if pw.total > 0 {
+		if pct := pw.written * 100 / pw.total; pct != pw.percent {
+			pw.percent = pct
+			fmt.Fprintf(os.Stderr, "\rBacking up: %3d%% (%d/%d bytes)", pct, pw.written, pw.total)
+		}
+	}
+	return n, err
+}
+
+func runBackup(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: " + ProgramName + " backup <filename> <output|->")
+	}
+	dbFile, outFile := args[0], args[1]
+
+	bdb, err := openForBackup(dbFile)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	var out io.Writer
+	var outF *os.File
+	if outFile == "-" {
+		out = os.Stdout
+	} else {
+		outF, err = os.OpenFile(outFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer outF.Close()
+		out = outF
+	}
+	if AppArgs.Gzip || strings.HasSuffix(outFile, ".gz") {
+		gz := gzip.NewWriter(out)
+		defer gz.Close()
+		out = gz
+	}
+
+	err = bdb.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(&progressWriter{w: out, total: tx.Size(), percent: -1})
+		return err
+	})
+	fmt.Fprintln(os.Stderr)
+	// The end of the gzip stream is only written on Close, so it can fail too
+	if gz, ok := out.(*gzip.Writer); ok && err == nil {
+		err = gz.Close()
+	}
+	if outF != nil && err == nil {
+		err = outF.Close()
+	}
+	if err != nil && outFile != "-" {
+		// Don't leave a partial backup lying around
+		os.Remove(outFile)
+	}
+	return err
+}
+
+/*
+openForBackup opens the file read-only, waiting up to AppArgs.DBOpenTimeout
+for whoever is holding the lock and reporting that we're waiting.
+Compressed files and "-" (stdin) are extracted first.
+*/
+func openForBackup(dbFile string) (*bolt.DB, error) {
+	dbFile, _, err := extractDatabase(dbFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	for {
+		wait := backupLockPollInterval
+		if AppArgs.DBOpenTimeout > 0 {
+			// A zero timeout means wait forever, just like bolt.Open
+			if remaining := AppArgs.DBOpenTimeout - time.Since(start); remaining < wait {
+				wait = remaining
+			}
+			if wait <= 0 {
+				fmt.Fprintln(os.Stderr)
+				return nil, fmt.Errorf("File %s is locked. Timed out after %s", dbFile, AppArgs.DBOpenTimeout)
+			}
+		}
+		bdb, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: wait, ReadOnly: true})
+		if err != bolt.ErrTimeout {
+			if time.Since(start) > backupLockPollInterval {
+				fmt.Fprintln(os.Stderr)
+			}
+			return bdb, err
+		}
+		fmt.Fprintf(os.Stderr, "\rWaiting for lock on %s (%s)", dbFile, time.Since(start).Round(time.Second))
+	}
+}
+
+/*
+openForWrite opens the file to change it. Unlike bolt.Open it won't make
+a new, empty database when the name is mistyped
+*/
+func openForWrite(dbFile string) (*bolt.DB, error) {
+	if _, err := os.Stat(dbFile); err != nil {
+		return nil, err
+	}
+	return bolt.Open(dbFile, 0600, &bolt.Options{Timeout: AppArgs.DBOpenTimeout})
+}
+
+/*
+snapshotDatabase copies the open database next to the original file
+with a timestamp in the name, returning the new filename
+*/
+func snapshotDatabase() (string, error) {
+	if currentFilename == "-" {
+		return "", errors.New("The database came from stdin, there's no file to put a snapshot next to")
+	}
+	base := currentFilename
+	if currentDBFile != currentFilename {
+		// The snapshot is a plain bolt file, not whatever it was extracted from
+		base = trimArchiveExt(base)
+	}
+	fName := fmt.Sprintf("%s.%s.bak", base, time.Now().Format("20060102-150405"))
+	err := viewDatabase(func(tx *bolt.Tx) error {
+		return tx.CopyFile(fName, 0600)
+	})
+	return fName, err
+}
+
+// trimArchiveExt takes the compression and tar extensions off 'name'
+func trimArchiveExt(name string) string {
+	for {
+		trimmed := name
+		for _, ext := range []string{".gz", ".zst", ".tgz", ".tar"} {
+			trimmed = strings.TrimSuffix(trimmed, ext)
+		}
+		if trimmed == name || trimmed == "" {
+			return name
+		}
+		name = trimmed
+	}
+}
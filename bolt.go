@@ -19,28 +19,69 @@ var memBolt *BoltDB
 
 var currentFilename string
 
+// currentDBFile is the file that's open, a temp file when currentFilename is compressed
+var currentDBFile string
+
 const DefaultDBOpenTimeout = time.Second
 
 var AppArgs struct {
 	DBOpenTimeout time.Duration
 	ReadOnly      bool
+	Gzip          bool
+	Theme         string
+	Mouse         bool
+	Addr          string
+	DryRun        bool
 }
 
 func init() {
 	AppArgs.DBOpenTimeout = DefaultDBOpenTimeout
 	AppArgs.ReadOnly = false
+	AppArgs.Gzip = false
+	AppArgs.Addr = DefaultServeAddr
 }
 
-func parseArgs() {
-	var err error
-	if len(os.Args) == 1 {
-		printUsage(nil)
+/*
+Subcommand is a non-interactive action run as `bolt <name> [args]`
+*/
+type Subcommand struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+var subcommands = []Subcommand{
+	{"backup", "backup <filename> <output|->", "Write a consistent copy of the DB (use -gzip to compress)", runBackup},
+	{"dump", "dump <filename> <output|->", "Write every bucket, pair and sequence as JSON lines (use -gzip to compress)", runDump},
+	{"restore", "restore <dump|-> <filename>", "Load a dump into a new DB file", runRestore},
+	{"export-sqlite", "export-sqlite <filename> <output.sqlite> [flat]", "Copy every pair into an SQLite table (or a table per bucket with flat)", runExportSQLite},
+	{"serve", "serve <filename> [-addr=localhost:8080]", "Serve the DB over a REST API (use -ro to turn off writes)", runServe},
+	{"exec", "exec <filename> <template> [bucket path]", "Print a Go text/template for every bucket and pair (.Path .Key .Value .Decoded .IsBucket, hex base64 json uint64)", runExec},
+	{"run", "run <filename> <script.star> [bucket path]", "Run a Starlark script in one transaction (use -dry-run to only show the changes)", runRun},
+	{"query", "query <filename> <expression>", "Print the path and value of each pair a query matches", runQuery},
+	{"export-csv", "export-csv <filename> <bucket path> <output|-> [key=enc] [value=enc] [recursive] [tsv]", "Write a bucket's pairs as CSV (encodings: utf8, hex, base64, uint64)", runExportCSV},
+	{"import-csv", "import-csv <filename> <bucket path> <input|-> [key=enc] [value=enc] [tsv]", "Put the rows of a CSV file into a bucket", runImportCSV},
+}
+
+func getSubcommand(name string) *Subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
 	}
-	parms := os.Args[1:]
-	for i := range parms {
-		// All 'option' arguments start with "-"
-		if !strings.HasPrefix(parms[i], "-") {
-			databaseFiles = append(databaseFiles, parms[i])
+	return nil
+}
+
+// parseArgs sets AppArgs from the options in parms
+// and returns the remaining positional arguments
+func parseArgs(parms []string) []string {
+	var err error
+	var args []string
+	for i := 0; i < len(parms); i++ {
+		// All 'option' arguments start with "-", a lone "-" means stdin/stdout
+		if !strings.HasPrefix(parms[i], "-") || parms[i] == "-" {
+			args = append(args, parms[i])
 			continue
 		}
 		if strings.Contains(parms[i], "=") {
@@ -62,6 +103,22 @@ func parseArgs() {
 				if val == "true" {
 					AppArgs.ReadOnly = true
 				}
+			case "-gzip":
+				if val == "true" {
+					AppArgs.Gzip = true
+				}
+			case "-theme":
+				AppArgs.Theme = val
+			case "-addr":
+				AppArgs.Addr = val
+			case "-mouse":
+				if val == "true" {
+					AppArgs.Mouse = true
+				}
+			case "-dry-run":
+				if val == "true" {
+					AppArgs.DryRun = true
+				}
 			case "-help":
 				printUsage(nil)
 			default:
@@ -72,6 +129,20 @@ func parseArgs() {
 			switch parms[i] {
 			case "-readonly", "-ro":
 				AppArgs.ReadOnly = true
+			case "-gzip":
+				AppArgs.Gzip = true
+			case "-mouse":
+				AppArgs.Mouse = true
+			case "-dry-run":
+				AppArgs.DryRun = true
+			case "-addr":
+				// Also takes its value as the next argument
+				if i+1 < len(parms) {
+					i++
+					AppArgs.Addr = parms[i]
+				} else {
+					printUsage(errors.New("-addr needs an address"))
+				}
 			case "-help":
 				printUsage(nil)
 			default:
@@ -79,45 +150,104 @@ func parseArgs() {
 			}
 		}
 	}
+	return args
 }
 
 func printUsage(err error) {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, err.Error())
 	}
-	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] <filename(s)>\nOptions:\n", ProgramName)
+	fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS] <filename(s)|->\n        (.gz, .zst and tar files are opened read-only)\nOptions:\n", ProgramName)
 	fmt.Fprintf(os.Stderr, "  -timeout=duration\n        DB file open timeout (default 1s)\n")
 	fmt.Fprintf(os.Stderr, "  -ro, -readonly   \n        Open the DB in read-only mode\n")
+	fmt.Fprintf(os.Stderr, "  -theme=name\n        Color theme, built-in or from %s\n", configFile())
+	fmt.Fprintf(os.Stderr, "  -mouse\n        Enable mouse support\n")
+	fmt.Fprintf(os.Stderr, "  -addr=host:port\n        Address for serve to listen on (default %s)\n", DefaultServeAddr)
+	fmt.Fprintf(os.Stderr, "  -gzip\n        Compress output written by subcommands\n")
+	fmt.Fprintf(os.Stderr, "  -dry-run\n        Show what run would change without writing it\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n        %s\n", ProgramName, cmd.usage, cmd.description)
+	}
 }
 
 func main() {
 	var err error
 
-	parseArgs()
+	if len(os.Args) == 1 {
+		printUsage(nil)
+		exit(1)
+	}
+	if cmd := getSubcommand(os.Args[1]); cmd != nil {
+		if err = cmd.run(parseArgs(os.Args[2:])); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: %s\n", ProgramName, cmd.name, err.Error())
+			exit(1)
+		}
+		removeTempFiles()
+		return
+	}
+	databaseFiles = parseArgs(os.Args[1:])
+	defer removeTempFiles()
+
+	if err = loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config file %s: %s\n", configFile(), err.Error())
+		exit(1)
+	}
+	style, err := loadStyle()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		exit(1)
+	}
+	keymap, err := loadKeymap(AppConfig.Keys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error in [keys] of %s: %s\n", configFile(), err.Error())
+		exit(1)
+	}
+
+	// Compressed files (and stdin) are extracted before the terminal is taken over
+	dbFiles := make([]string, len(databaseFiles))
+	extracted := make([]bool, len(databaseFiles))
+	for i, databaseFile := range databaseFiles {
+		if dbFiles[i], extracted[i], err = extractDatabase(databaseFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %s\n", err.Error())
+			exit(1)
+		}
+	}
+	readOnly := AppArgs.ReadOnly
 
 	err = termbox.Init()
 	if err != nil {
 		panic(err)
 	}
 	defer termbox.Close()
-	style := defaultStyle()
 	termbox.SetOutputMode(termbox.Output256)
+	inputMode := termbox.InputEsc
+	if keymap.usesAlt() {
+		inputMode = termbox.InputAlt
+	}
+	if AppArgs.Mouse {
+		inputMode |= termbox.InputMouse
+	}
+	termbox.SetInputMode(inputMode)
 
-	for _, databaseFile := range databaseFiles {
+	for i, databaseFile := range databaseFiles {
 		currentFilename = databaseFile
-		db, err = bolt.Open(databaseFile, 0600, &bolt.Options{Timeout: AppArgs.DBOpenTimeout})
+		currentDBFile = dbFiles[i]
+		// An extracted copy is thrown away at the end, so don't let anything be changed in it
+		AppArgs.ReadOnly = readOnly || extracted[i]
+		db, err = bolt.Open(currentDBFile, 0600, &bolt.Options{Timeout: AppArgs.DBOpenTimeout})
 		if err == bolt.ErrTimeout {
 			termbox.Close()
 			fmt.Printf("File %s is locked. Make sure it's not used by another app and try again\n", databaseFile)
-			os.Exit(1)
+			exit(1)
 		} else if err != nil {
 			if len(databaseFiles) > 1 {
-				mainLoop(nil, style)
+				mainLoop(nil, style, keymap)
 				continue
 			} else {
 				termbox.Close()
 				fmt.Printf("Error reading file: %q\n", err.Error())
-				os.Exit(1)
+				exit(1)
 			}
 		}
 
@@ -129,7 +259,7 @@ func main() {
 		}
 
 		// Kick off the UI loop
-		mainLoop(memBolt, style)
+		mainLoop(memBolt, style, keymap)
 		defer db.Close()
 	}
 }
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
@@ -14,6 +18,7 @@ BoltDB A Database, basically a collection of buckets
 */
 type BoltDB struct {
 	buckets []BoltBucket
+	visible VisibleIndex
 }
 
 /*
@@ -24,6 +29,7 @@ type BoltBucket struct {
 	pairs     []BoltPair
 	buckets   []BoltBucket
 	parent    *BoltBucket
+	sequence  uint64
 	expanded  bool
 	errorFlag bool
 }
@@ -123,67 +129,21 @@ func (bd *BoltDB) getVisibleItemCount(path []string) (int, error) {
 	return vis, retErr
 }
 
-func (bd *BoltDB) buildVisiblePathSlice() ([][]string, error) {
-	var retSlice [][]string
-	var retErr error
-	// The root path, recurse for root buckets
-	for i := range bd.buckets {
-		bktS, bktErr := bd.buckets[i].buildVisiblePathSlice([]string{})
-		if bktErr == nil {
-			retSlice = append(retSlice, bktS...)
-		} else {
-			// Something went wrong, set the error flag
-			bd.buckets[i].errorFlag = true
-		}
-	}
-	return retSlice, retErr
-}
-
 func (bd *BoltDB) getPrevVisiblePath(path []string) []string {
-	visPaths, err := bd.buildVisiblePathSlice()
 	if path == nil {
-		if len(visPaths) > 0 {
-			return visPaths[len(visPaths)-1]
-		}
-		return nil
+		return bd.visibleRowPath(len(bd.visibleRows()) - 1)
 	}
-	if err == nil {
-		for idx, pth := range visPaths {
-			isCurPath := true
-			for i := range path {
-				if len(pth) <= i || path[i] != pth[i] {
-					isCurPath = false
-					break
-				}
-			}
-			if isCurPath && idx > 0 {
-				return visPaths[idx-1]
-			}
-		}
+	if idx := bd.findVisibleRow(path); idx > 0 {
+		return bd.visibleRowPath(idx - 1)
 	}
 	return nil
 }
 func (bd *BoltDB) getNextVisiblePath(path []string) []string {
-	visPaths, err := bd.buildVisiblePathSlice()
 	if path == nil {
-		if len(visPaths) > 0 {
-			return visPaths[0]
-		}
-		return nil
+		return bd.visibleRowPath(0)
 	}
-	if err == nil {
-		for idx, pth := range visPaths {
-			isCurPath := true
-			for i := range path {
-				if len(pth) <= i || path[i] != pth[i] {
-					isCurPath = false
-					break
-				}
-			}
-			if isCurPath && len(visPaths) > idx+1 {
-				return visPaths[idx+1]
-			}
-		}
+	if idx := bd.findVisibleRow(path); idx >= 0 {
+		return bd.visibleRowPath(idx + 1)
 	}
 	return nil
 }
@@ -192,7 +152,7 @@ func (bd *BoltDB) toggleOpenBucket(path []string) error {
 	// Find the BoltBucket with a path == path
 	b, err := bd.getBucketFromPath(path)
 	if err == nil {
-		b.expanded = !b.expanded
+		bd.setExpanded(b, !b.expanded)
 	}
 	return err
 }
@@ -201,7 +161,7 @@ func (bd *BoltDB) closeBucket(path []string) error {
 	// Find the BoltBucket with a path == path
 	b, err := bd.getBucketFromPath(path)
 	if err == nil {
-		b.expanded = false
+		bd.setExpanded(b, false)
 	}
 	return err
 }
@@ -210,7 +170,7 @@ func (bd *BoltDB) openBucket(path []string) error {
 	// Find the BoltBucket with a path == path
 	b, err := bd.getBucketFromPath(path)
 	if err == nil {
-		b.expanded = true
+		bd.setExpanded(b, true)
 	}
 	return err
 }
@@ -225,6 +185,7 @@ func (bd *BoltDB) getBucket(k string) (*BoltBucket, error) {
 }
 
 func (bd *BoltDB) openAllBuckets() {
+	bd.invalidateVisible()
 	for i := range bd.buckets {
 		bd.buckets[i].openAllBuckets()
 		bd.buckets[i].expanded = true
@@ -232,6 +193,7 @@ func (bd *BoltDB) openAllBuckets() {
 }
 
 func (bd *BoltDB) syncOpenBuckets(shadow *BoltDB) {
+	bd.invalidateVisible()
 	// First test this bucket
 	for i := range bd.buckets {
 		for j := range shadow.buckets {
@@ -242,10 +204,32 @@ func (bd *BoltDB) syncOpenBuckets(shadow *BoltDB) {
 	}
 }
 
+/*
+viewDatabase runs fn in a read transaction. In read-only mode the database
+is closed once it's loaded, so then it's opened again just for this
+*/
+func viewDatabase(fn func(tx *bolt.Tx) error) error {
+	err := db.View(fn)
+	if err != bolt.ErrDatabaseNotOpen {
+		return err
+	}
+	timeout := AppArgs.DBOpenTimeout
+	if timeout == 0 {
+		// Don't hang the UI if someone else has it locked
+		timeout = time.Second
+	}
+	rdb, err := bolt.Open(currentDBFile, 0600, &bolt.Options{ReadOnly: true, Timeout: timeout})
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
+	return rdb.View(fn)
+}
+
 func (bd *BoltDB) refreshDatabase() *BoltDB {
 	// Reload the database into memBolt
 	memBolt = new(BoltDB)
-	db.View(func(tx *bolt.Tx) error {
+	viewDatabase(func(tx *bolt.Tx) error {
 		return tx.ForEach(func(nm []byte, b *bolt.Bucket) error {
 			bb, err := readBucket(b)
 			if err == nil {
@@ -270,31 +254,6 @@ func (b *BoltBucket) GetPath() []string {
 	return []string{b.name}
 }
 
-/*
-buildVisiblePathSlice builds a slice of string slices containing all visible paths in this bucket
-The passed prefix is the path leading to the current bucket
-*/
-func (b *BoltBucket) buildVisiblePathSlice(prefix []string) ([][]string, error) {
-	var retSlice [][]string
-	var retErr error
-	retSlice = append(retSlice, append(prefix, b.name))
-	if b.expanded {
-		// Add subbuckets
-		for i := range b.buckets {
-			bktS, bktErr := b.buckets[i].buildVisiblePathSlice(append(prefix, b.name))
-			if bktErr != nil {
-				return retSlice, bktErr
-			}
-			retSlice = append(retSlice, bktS...)
-		}
-		// Add pairs
-		for i := range b.pairs {
-			retSlice = append(retSlice, append(append(prefix, b.name), b.pairs[i].key))
-		}
-	}
-	return retSlice, retErr
-}
-
 func (b *BoltBucket) syncOpenBuckets(shadow *BoltBucket) {
 	// First test this bucket
 	b.expanded = shadow.expanded
@@ -323,11 +282,14 @@ func (b *BoltBucket) getBucket(k string) (*BoltBucket, error) {
 	return nil, errors.New("Bucket Not Found")
 }
 
+/*
+getPair finds the pair with key 'k'. The pairs are in
+key order, as bolt gives them to us, so we can binary search
+*/
 func (b *BoltBucket) getPair(k string) (*BoltPair, error) {
-	for i := range b.pairs {
-		if b.pairs[i].key == k {
-			return &b.pairs[i], nil
-		}
+	i := sort.Search(len(b.pairs), func(i int) bool { return b.pairs[i].key >= k })
+	if i < len(b.pairs) && b.pairs[i].key == k {
+		return &b.pairs[i], nil
 	}
 	return nil, errors.New("Pair Not Found")
 }
@@ -349,6 +311,9 @@ func (p *BoltPair) GetPath() []string {
 func addBucketFromBoltBucket(path []string, bb *BoltBucket) error {
 	if err := insertBucket(path, bb.name); err == nil {
 		bucketPath := append(path, bb.name)
+		if err = setBucketSequence(bucketPath, bb.sequence); err != nil {
+			return err
+		}
 		for i := range bb.pairs {
 			if err = insertPair(bucketPath, bb.pairs[i].key, bb.pairs[i].val); err != nil {
 				return err
@@ -401,6 +366,7 @@ func deleteKey(path []string) error {
 
 func readBucket(b *bolt.Bucket) (*BoltBucket, error) {
 	bb := new(BoltBucket)
+	bb.sequence = b.Sequence()
 	b.ForEach(func(k, v []byte) error {
 		if v == nil {
 			tb, err := readBucket(b.Bucket(k))
@@ -508,25 +474,77 @@ func updatePairValue(path []string, v string) error {
 		return errors.New("DB is in Read-Only Mode")
 	}
 	err := db.Update(func(tx *bolt.Tx) error {
-		// len(b.GetPath())-1 is the key for the pair we're updating,
-		// the rest are buckets leading to that key
-		b := tx.Bucket([]byte(path[0]))
-		if b != nil {
-			if len(path) > 0 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("updatePairValue: Invalid Path")
-					}
+		return putPairValue(tx, path, []byte(v))
+	})
+	return err
+}
+
+/*
+putPairValue is the inside of updatePairValue, for
+when there's more than one pair to update in a transaction
+*/
+func putPairValue(tx *bolt.Tx, path []string, v []byte) error {
+	// len(b.GetPath())-1 is the key for the pair we're updating,
+	// the rest are buckets leading to that key
+	b := tx.Bucket([]byte(path[0]))
+	if b != nil {
+		if len(path) > 0 {
+			for i := range path[1 : len(path)-1] {
+				b = b.Bucket([]byte(path[i+1]))
+				if b == nil {
+					return errors.New("updatePairValue: Invalid Path")
 				}
 			}
-			// Now update the last key in the path
-			err := b.Put([]byte(path[len(path)-1]), []byte(v))
-			return err
 		}
-		return errors.New("updatePairValue: Invalid Path")
+		// Now update the last key in the path
+		return b.Put([]byte(path[len(path)-1]), v)
+	}
+	return errors.New("updatePairValue: Invalid Path")
+}
+
+/*
+getBoltBucket walks 'path' from the root of the transaction,
+returning nil if any part of it isn't a bucket
+*/
+func getBoltBucket(tx *bolt.Tx, path []string) *bolt.Bucket {
+	if len(path) == 0 {
+		return nil
+	}
+	b := tx.Bucket([]byte(path[0]))
+	for i := 1; i < len(path) && b != nil; i++ {
+		b = b.Bucket([]byte(path[i]))
+	}
+	return b
+}
+
+func setBucketSequence(path []string, seq uint64) error {
+	if AppArgs.ReadOnly {
+		return errors.New("DB is in Read-Only Mode")
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := getBoltBucket(tx, path)
+		if b == nil {
+			return errors.New("setBucketSequence: Invalid Path")
+		}
+		return b.SetSequence(seq)
 	})
-	return err
+}
+
+func nextBucketSequence(path []string) (uint64, error) {
+	if AppArgs.ReadOnly {
+		return 0, errors.New("DB is in Read-Only Mode")
+	}
+	var seq uint64
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := getBoltBucket(tx, path)
+		if b == nil {
+			return errors.New("nextBucketSequence: Invalid Path")
+		}
+		var err error
+		seq, err = b.NextSequence()
+		return err
+	})
+	return seq, err
 }
 
 func insertBucket(path []string, n string) error {
@@ -600,93 +618,102 @@ func insertPair(path []string, k string, v string) error {
 	return err
 }
 
-func exportValue(path []string, fName string) error {
-	return db.View(func(tx *bolt.Tx) error {
+// exportValue writes the value at 'path' to 'w', with a newline after it
+func exportValue(path []string, w io.Writer) error {
+	return viewDatabase(func(tx *bolt.Tx) error {
 		// len(b.path)-1 is the key whose value we want to export
 		// the rest are buckets leading to that key
 		b := tx.Bucket([]byte(path[0]))
-		if b != nil {
-			if len(path) > 1 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("exportValue: Invalid Path: " + strings.Join(path, "/"))
-					}
+		if b == nil {
+			return errors.New("exportValue: Invalid Bucket")
+		}
+		if len(path) > 1 {
+			for i := range path[1 : len(path)-1] {
+				b = b.Bucket([]byte(path[i+1]))
+				if b == nil {
+					return errors.New("exportValue: Invalid Path: " + strings.Join(path, "/"))
 				}
 			}
-			bk := []byte(path[len(path)-1])
-			v := b.Get(bk)
-			return writeToFile(fName, string(v)+"\n", os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
-		} else {
-			return errors.New("exportValue: Invalid Bucket")
 		}
-		return nil
+		bk := []byte(path[len(path)-1])
+		v := b.Get(bk)
+		_, err := io.WriteString(w, string(v)+"\n")
+		return err
 	})
 }
 
-func exportJSON(path []string, fName string) error {
-	return db.View(func(tx *bolt.Tx) error {
+// exportJSON writes the pair or bucket at 'path' to 'w' as JSON
+func exportJSON(path []string, w io.Writer) error {
+	return viewDatabase(func(tx *bolt.Tx) error {
 		// len(b.path)-1 is the key whose value we want to export
 		// the rest are buckets leading to that key
 		b := tx.Bucket([]byte(path[0]))
-		if b != nil {
-			if len(path) > 1 {
-				for i := range path[1 : len(path)-1] {
-					b = b.Bucket([]byte(path[i+1]))
-					if b == nil {
-						return errors.New("exportValue: Invalid Path: " + strings.Join(path, "/"))
-					}
+		if b == nil {
+			return errors.New("exportValue: Invalid Bucket")
+		}
+		if len(path) > 1 {
+			for i := range path[1 : len(path)-1] {
+				b = b.Bucket([]byte(path[i+1]))
+				if b == nil {
+					return errors.New("exportValue: Invalid Path: " + strings.Join(path, "/"))
 				}
 			}
-			bk := []byte(path[len(path)-1])
-			if v := b.Get(bk); v != nil {
-				return writeToFile(fName, "{\""+string(bk)+"\":\""+string(v)+"\"}", os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
-			}
-			if b.Bucket(bk) != nil {
-				return writeToFile(fName, genJSONString(b.Bucket(bk)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
-			}
-			return writeToFile(fName, genJSONString(b), os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+		}
+		bk := []byte(path[len(path)-1])
+		var d interface{}
+		if v := b.Get(bk); v != nil {
+			d = map[string]string{string(bk): string(v)}
+		} else if b.Bucket(bk) != nil {
+			d = bucketToJSON(b.Bucket(bk))
 		} else {
-			return errors.New("exportValue: Invalid Bucket")
+			d = bucketToJSON(b)
 		}
-		return nil
+		out, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
 	})
 }
 
-func genJSONString(b *bolt.Bucket) string {
-	ret := "{"
+// jsonSequenceKey holds a bucket's sequence in exported JSON, when it isn't 0
+const jsonSequenceKey = "$sequence"
+
+// bucketToJSON is b as a map, values are strings and buckets are maps
+func bucketToJSON(b *bolt.Bucket) map[string]interface{} {
+	m := make(map[string]interface{})
+	if seq := b.Sequence(); seq != 0 {
+		m[jsonSequenceKey] = seq
+	}
 	b.ForEach(func(k, v []byte) error {
-		ret = fmt.Sprintf("%s\"%s\":", ret, string(k))
 		if v == nil {
-			ret = fmt.Sprintf("%s%s,", ret, genJSONString(b.Bucket(k)))
+			m[string(k)] = bucketToJSON(b.Bucket(k))
 		} else {
-			ret = fmt.Sprintf("%s\"%s\",", ret, string(v))
+			m[string(k)] = string(v)
 		}
 		return nil
 	})
-	ret = fmt.Sprintf("%s}", ret[:len(ret)-1])
-	return ret
+	return m
 }
 
-var f *os.File
-
 func logToFile(s string) error {
 	return writeToFile("bolt-log", s+"\n", os.O_RDWR|os.O_APPEND)
 }
 
+// writeToFile opens 'fn', writes 's' and closes it again
 func writeToFile(fn, s string, mode int) error {
-	var err error
-	if f == nil {
-		f, err = os.OpenFile(fn, mode, 0660)
-	}
+	f, err := os.OpenFile(expandHome(fn), mode, 0660)
 	if err != nil {
 		return err
 	}
 	if _, err = f.WriteString(s); err != nil {
+		f.Close()
 		return err
 	}
 	if err = f.Sync(); err != nil {
+		f.Close()
 		return err
 	}
-	return nil
+	return f.Close()
 }
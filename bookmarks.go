@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+const bookmarksStateFile = "bookmarks.json"
+
+/*
+Bookmarks maps a letter to the path it was set on,
+they're kept per database file (see databaseStateDir)
+*/
+type Bookmarks map[string][]string
+
+func loadBookmarks() (Bookmarks, error) {
+	marks := make(Bookmarks)
+	err := loadDatabaseState(bookmarksStateFile, &marks)
+	return marks, err
+}
+
+func isBookmarkLetter(ch rune) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func (screen *BrowserScreen) setBookmark(letter string) error {
+	if len(screen.currentPath) == 0 {
+		return errors.New("Nothing to bookmark")
+	}
+	if screen.bookmarks == nil {
+		screen.bookmarks = make(Bookmarks)
+	}
+	screen.bookmarks[letter] = append([]string{}, screen.currentPath...)
+	return saveDatabaseState(bookmarksStateFile, screen.bookmarks)
+}
+
+func (screen *BrowserScreen) jumpToBookmark(letter string) error {
+	path, ok := screen.bookmarks[letter]
+	if !ok {
+		return fmt.Errorf("Bookmark %s not set", letter)
+	}
+	return screen.jumpToPath(path)
+}
+
+/*
+handleMarkKeyEvent takes the letter typed after set-bookmark (m)
+or jump-bookmark (the quote key), anything else cancels
+*/
+func (screen *BrowserScreen) handleMarkKeyEvent(event termbox.Event) int {
+	mode := screen.mode
+	screen.mode = modeBrowse
+	if !isBookmarkLetter(event.Ch) {
+		screen.setMessage("Cancelled")
+		return BrowserScreenIndex
+	}
+	letter := string(event.Ch)
+	if mode == modeMarkSet {
+		if err := screen.setBookmark(letter); err != nil {
+			screen.setMessage(err.Error())
+		} else {
+			screen.setMessage(fmt.Sprintf("Bookmark %s set to %s", letter, formatQueryPath(screen.currentPath)))
+		}
+	} else if err := screen.jumpToBookmark(letter); err != nil {
+		screen.setMessage(err.Error())
+	}
+	return BrowserScreenIndex
+}
+
+// startGoto opens the command line ready to go to an absolute path
+func (screen *BrowserScreen) startGoto() {
+	screen.startCommand()
+	screen.queuedCommand = "cd /"
+	screen.commandCursor = len(screen.queuedCommand)
+}
+
+func runMarksCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(screen.bookmarks) == 0 {
+		screen.setMessage("No bookmarks, set one with m<letter>")
+		return BrowserScreenIndex, nil
+	}
+	var letters []string
+	for l := range screen.bookmarks {
+		letters = append(letters, l)
+	}
+	sort.Strings(letters)
+	var marks []string
+	for _, l := range letters {
+		marks = append(marks, l+" "+formatQueryPath(screen.bookmarks[l]))
+	}
+	screen.setMessage(strings.Join(marks, ", "))
+	return BrowserScreenIndex, nil
+}
+
+func runDelmarkCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) == 0 {
+		return BrowserScreenIndex, errors.New("Usage: delmark <letter>...")
+	}
+	for _, l := range cmd.args {
+		if _, ok := screen.bookmarks[l]; !ok {
+			return BrowserScreenIndex, fmt.Errorf("Bookmark %s not set", l)
+		}
+		delete(screen.bookmarks, l)
+	}
+	return BrowserScreenIndex, saveDatabaseState(bookmarksStateFile, screen.bookmarks)
+}
+
+func completeBookmarks(screen *BrowserScreen, argIdx int, prefix string) []string {
+	var ret []string
+	for l := range screen.bookmarks {
+		ret = append(ret, l)
+	}
+	sort.Strings(ret)
+	return filterPrefix(ret, prefix)
+}
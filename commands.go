@@ -0,0 +1,761 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+BrowserCommand is something that can be run from the ':' prompt
+*/
+type BrowserCommand struct {
+	names       []string
+	usage       string
+	description string
+	// complete returns the candidates for argument 'argIdx' starting with 'prefix'
+	complete func(screen *BrowserScreen, argIdx int, prefix string) []string
+	run      func(screen *BrowserScreen, cmd *parsedCommand) (int, error)
+}
+
+var browserCommands []BrowserCommand
+
+func init() {
+	// Set up here rather than in the var declaration since :help refers back to the list
+	browserCommands = []BrowserCommand{
+		{[]string{"cd"}, "cd <path>", "go to a bucket or pair, relative to the current bucket", completeBucketPaths, runCdCommand},
+		{[]string{"put"}, "put <key> <value>", "create or update a pair in the current bucket", completeKeys, runPutCommand},
+		{[]string{"mkb", "mkbucket"}, "mkb <name>", "create a bucket in the current bucket", nil, runMkbCommand},
+		{[]string{"rm", "delete"}, "rm [path]", "delete the current (or given) item", completePaths, runRmCommand},
+		{[]string{"export"}, "export <value|json|yaml|toml|csv|tsv> <file> [key=enc] [value=enc] [recursive]", "export the current item (or bucket, for csv) to a file", completeExport, runExportCommand},
+		{[]string{"copy", "yank"}, "copy [value|json|yaml|toml|csv|tsv] [csv options]", "copy the current item to the clipboard (with OSC 52, so it works over ssh)", completeCopy, runCopyCommand},
+		{[]string{"import"}, "import <csv|tsv|yaml|toml> <file> [key=enc] [value=enc]", "put the contents of a file into the current bucket", completeImport, runImportCommand},
+		{[]string{"marks"}, "marks", "list bookmarks", nil, runMarksCommand},
+		{[]string{"delmark"}, "delmark <letter>...", "delete bookmarks", completeBookmarks, runDelmarkCommand},
+		{[]string{"query"}, "query <path> [as <decoder>] [where <cond> [and <cond>]...] [limit n] [offset n]", "find pairs, e.g. users/* where key ^= \"2024-\" and .status == \"active\"", nil, runQueryCommand},
+		{[]string{"results"}, "results", "go back to the last query's results", nil, runResultsCommand},
+		{[]string{"seek"}, "seek <key>", "go to the first key in the current bucket at or after 'key' (\"text\", 0x0a0b hex or a uint64)", nil, runSeekCommand},
+		{[]string{"scan"}, "scan [prefix]", "list the keys in the current bucket starting with 'prefix', a page at a time", nil, runScanCommand},
+		{[]string{"jq"}, "jq [value|bucket|results] <expression>", "change JSON values with a jq expression, shows what changes before writing", completeJqScope, runJqCommand},
+		{[]string{"run"}, "run <script.star>", "run a Starlark script on the current bucket, shows what changes before writing", nil, runRunCommand},
+		{[]string{"filter"}, "filter [pattern]", "only show pairs in the current bucket matching a glob or text, no pattern clears it", nil, runFilterCommand},
+		{[]string{"sort"}, "sort [byte|natural|size] [reverse|forward] [mixed|buckets-first]", "change how the current bucket is ordered, 'default' resets it", completeSort, runSortCommand},
+		{[]string{"set"}, "set <option>=<value>", "change a setting, no arguments shows them", completeSetOptions, runSetCommand},
+		{[]string{"help"}, "help [command]", "list commands", completeCommandNames, runHelpCommand},
+		{[]string{"q", "quit"}, "q", "quit", nil, func(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+			return ExitScreenIndex, nil
+		}},
+	}
+}
+
+func getBrowserCommand(name string) *BrowserCommand {
+	for i := range browserCommands {
+		for _, n := range browserCommands[i].names {
+			if n == name {
+				return &browserCommands[i]
+			}
+		}
+	}
+	return nil
+}
+
+/*
+commandToken is one word of a command line. Words are split on spaces,
+double quotes group words and a backslash escapes the next character.
+'\/' is kept as is so that paths can tell it apart from a separator
+*/
+type commandToken struct {
+	text  string
+	start int
+	end   int
+}
+
+/*
+parsedCommand is a command line split into its name and arguments,
+'rest' is the raw text of each argument to the end of the line
+*/
+type parsedCommand struct {
+	name string
+	args []string
+	rest []string
+}
+
+func tokenizeCommand(line string) []commandToken {
+	var tokens []commandToken
+	var cur []rune
+	inQuote, inToken := false, false
+	start := 0
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && i+1 < len(runes):
+			if !inToken {
+				inToken, start = true, i
+			}
+			i++
+			if runes[i] == '/' {
+				cur = append(cur, '\\')
+			}
+			cur = append(cur, runes[i])
+		case r == '"':
+			if !inToken {
+				inToken, start = true, i
+			}
+			inQuote = !inQuote
+		case r == ' ' && !inQuote:
+			if inToken {
+				tokens = append(tokens, commandToken{string(cur), start, i})
+				cur, inToken = nil, false
+			}
+		default:
+			if !inToken {
+				inToken, start = true, i
+			}
+			cur = append(cur, r)
+		}
+	}
+	if inToken {
+		tokens = append(tokens, commandToken{string(cur), start, len(runes)})
+	}
+	return tokens
+}
+
+func parseCommand(line string) *parsedCommand {
+	tokens := tokenizeCommand(line)
+	if len(tokens) == 0 {
+		return nil
+	}
+	runes := []rune(line)
+	cmd := &parsedCommand{name: tokens[0].text}
+	for _, t := range tokens[1:] {
+		cmd.args = append(cmd.args, t.text)
+		cmd.rest = append(cmd.rest, string(runes[t.start:]))
+	}
+	return cmd
+}
+
+// escapeCommandWord escapes a bucket or key name for use in a command line path
+func escapeCommandWord(s string) string {
+	r := strings.NewReplacer("\\", "\\\\", " ", "\\ ", "\"", "\\\"", "/", "\\/")
+	return r.Replace(s)
+}
+
+// unescapeCommandWord undoes the '\/' the tokenizer leaves in words that aren't paths
+func unescapeCommandWord(s string) string {
+	return strings.Replace(s, "\\/", "/", -1)
+}
+
+/*
+splitCommandPath splits a path argument on '/', a leading '/'
+means the path starts at the root instead of the current bucket
+*/
+func splitCommandPath(p string) (parts []string, absolute bool) {
+	absolute = strings.HasPrefix(p, "/")
+	var cur []rune
+	runes := []rune(p)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == '\\' && i+1 < len(runes) && runes[i+1] == '/' {
+			cur = append(cur, '/')
+			i++
+		} else if runes[i] == '/' {
+			if len(cur) > 0 {
+				parts = append(parts, string(cur))
+			}
+			cur = nil
+		} else {
+			cur = append(cur, runes[i])
+		}
+	}
+	if len(cur) > 0 {
+		parts = append(parts, string(cur))
+	}
+	return parts, absolute
+}
+
+/*
+parseBucketPath is splitCommandPath for a bucket path given on the command
+line, which always starts at the root. Anything that splitCommandPath
+would quietly skip or that only means something in the browser is an
+error, rather than ending up in some other bucket
+*/
+func parseBucketPath(p string) ([]string, error) {
+	parts, _ := splitCommandPath(p)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("No bucket in the path %q", p)
+	}
+	if strings.Contains(strings.Replace(p, "\\/", "", -1), "//") {
+		return nil, fmt.Errorf("Empty bucket name in the path %q", p)
+	}
+	for _, part := range parts {
+		if part == "." || part == ".." {
+			return nil, fmt.Errorf("%s in the path %q, bucket paths start at the root", part, p)
+		}
+	}
+	return parts, nil
+}
+
+/*
+resolvePath turns a path argument into a full path,
+handling '.' and '..' like a shell would
+*/
+func (screen *BrowserScreen) resolvePath(p string) []string {
+	parts, absolute := splitCommandPath(p)
+	var ret []string
+	if !absolute {
+		ret = append(ret, screen.currentBucketPath()...)
+	}
+	for _, part := range parts {
+		switch part {
+		case ".":
+		case "..":
+			if len(ret) > 0 {
+				ret = ret[:len(ret)-1]
+			}
+		default:
+			ret = append(ret, part)
+		}
+	}
+	return ret
+}
+
+/*
+currentBucketPath is the bucket the cursor is in: the current
+bucket itself, or the parent of the current pair
+*/
+func (screen *BrowserScreen) currentBucketPath() []string {
+	_, p, err := screen.db.getGenericFromPath(screen.currentPath)
+	if err != nil || len(screen.currentPath) == 0 {
+		return []string{}
+	}
+	if p != nil {
+		return append([]string{}, screen.currentPath[:len(screen.currentPath)-1]...)
+	}
+	return append([]string{}, screen.currentPath...)
+}
+
+/*
+jumpToPath moves the cursor to 'path', opening all of the buckets leading to it
+*/
+func (screen *BrowserScreen) jumpToPath(path []string) error {
+	if _, _, err := screen.db.getGenericFromPath(path); err != nil {
+		return fmt.Errorf("No such path: %s", strings.Join(path, "/"))
+	}
+	for i := 1; i < len(path); i++ {
+		screen.db.openBucket(path[:i])
+	}
+	screen.currentPath = append([]string{}, path...)
+	return nil
+}
+
+func runCdCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) == 0 || cmd.args[0] == "/" {
+		screen.currentPath = screen.db.getNextVisiblePath(nil)
+		return BrowserScreenIndex, nil
+	}
+	path := screen.resolvePath(cmd.args[0])
+	if len(path) == 0 {
+		screen.currentPath = screen.db.getNextVisiblePath(nil)
+		return BrowserScreenIndex, nil
+	}
+	if err := screen.jumpToPath(path); err != nil {
+		return BrowserScreenIndex, err
+	}
+	screen.db.openBucket(path)
+	return BrowserScreenIndex, nil
+}
+
+func runPutCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) < 2 {
+		return BrowserScreenIndex, errors.New("Usage: put <key> <value>")
+	}
+	bucketPath := screen.currentBucketPath()
+	key := unescapeCommandWord(cmd.args[0])
+	// A quoted value is taken as is, otherwise everything after the key is the value
+	val := cmd.rest[1]
+	if len(cmd.args) == 2 {
+		val = cmd.args[1]
+	}
+	var err error
+	if b, _ := screen.db.getBucketFromPath(bucketPath); b != nil {
+		if _, pErr := b.getPair(key); pErr == nil {
+			err = updatePairValue(append(bucketPath, key), val)
+		} else {
+			err = insertPair(bucketPath, key, val)
+		}
+	} else {
+		err = insertPair(bucketPath, key, val)
+	}
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	screen.refreshDatabase()
+	screen.jumpToPath(append(bucketPath, key))
+	screen.setMessage("Pair updated!")
+	return BrowserScreenIndex, nil
+}
+
+func runMkbCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) != 1 {
+		return BrowserScreenIndex, errors.New("Usage: mkb <name>")
+	}
+	bucketPath := screen.currentBucketPath()
+	name := unescapeCommandWord(cmd.args[0])
+	if err := insertBucket(bucketPath, name); err != nil {
+		return BrowserScreenIndex, err
+	}
+	screen.refreshDatabase()
+	screen.jumpToPath(append(bucketPath, name))
+	screen.setMessage("Bucket created!")
+	return BrowserScreenIndex, nil
+}
+
+func runRmCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) > 0 {
+		if err := screen.jumpToPath(screen.resolvePath(cmd.args[0])); err != nil {
+			return BrowserScreenIndex, err
+		}
+	}
+	// Still ask first, same as 'D'
+	screen.startDeleteItem()
+	return BrowserScreenIndex, nil
+}
+
+func runExportCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) >= 2 && (cmd.args[0] == "csv" || cmd.args[0] == "tsv") {
+		return BrowserScreenIndex, screen.runCSVCommand(false, cmd.args[0], cmd.args[1:])
+	}
+	if len(cmd.args) != 2 {
+		return BrowserScreenIndex, errors.New("Usage: export <value|json|yaml|toml|csv|tsv> <file>")
+	}
+	b, p, err := screen.db.getGenericFromPath(screen.currentPath)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	fileName := unescapeCommandWord(cmd.args[1])
+	var export ExportFunc
+	switch cmd.args[0] {
+	case "value":
+		if p == nil {
+			return BrowserScreenIndex, errors.New("Only pairs have a value to export")
+		}
+		export = valueExport(screen.currentPath)
+	case "json":
+		if b == nil && p == nil {
+			return BrowserScreenIndex, errors.New("Nothing to export")
+		}
+		export = jsonExport(screen.currentPath)
+	case "yaml", "toml":
+		export = structuredExport(screen.currentPath, cmd.args[0])
+	default:
+		return BrowserScreenIndex, errors.New("Unknown export format " + cmd.args[0])
+	}
+	return BrowserScreenIndex, screen.exportTo(fileName, export)
+}
+
+/*
+setOption is a setting that can be changed with ':set'
+*/
+type setOption struct {
+	name   string
+	values func() []string
+	get    func(screen *BrowserScreen) string
+	set    func(screen *BrowserScreen, val string) error
+}
+
+var setOptions = []setOption{
+	{
+		"decoder", decoderNames,
+		func(screen *BrowserScreen) string { return screen.getDecoderName(screen.currentBucketPath()) },
+		func(screen *BrowserScreen, val string) error {
+			if getDecoder(val) == nil {
+				return fmt.Errorf("Unknown decoder %s (available: %s)", val, strings.Join(decoderNames(), ", "))
+			}
+			screen.setDecoderName(screen.currentBucketPath(), val)
+			return nil
+		},
+	},
+	{
+		"layout", layoutModeNames,
+		func(screen *BrowserScreen) string { return string(screen.layout) },
+		func(screen *BrowserScreen, val string) error {
+			layout, err := parseLayoutMode(val)
+			if err != nil {
+				return err
+			}
+			screen.layout = layout
+			screen.zoom = zoomNone
+			return nil
+		},
+	},
+	{
+		"split", func() []string { return nil },
+		func(screen *BrowserScreen) string { return strconv.FormatFloat(screen.splitRatio, 'f', 2, 64) },
+		func(screen *BrowserScreen, val string) error {
+			ratio, err := parseSplitRatio(val)
+			if err != nil {
+				return err
+			}
+			screen.splitRatio = ratio
+			return nil
+		},
+	},
+}
+
+func getSetOption(name string) *setOption {
+	for i := range setOptions {
+		if setOptions[i].name == name {
+			return &setOptions[i]
+		}
+	}
+	return nil
+}
+
+func runSetCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) == 0 {
+		var settings []string
+		for _, opt := range setOptions {
+			settings = append(settings, opt.name+"="+opt.get(screen))
+		}
+		screen.setMessage(strings.Join(settings, " "))
+		return BrowserScreenIndex, nil
+	}
+	for _, arg := range cmd.args {
+		pts := strings.SplitN(arg, "=", 2)
+		opt := getSetOption(pts[0])
+		if opt == nil {
+			return BrowserScreenIndex, errors.New("Unknown option " + pts[0])
+		}
+		if len(pts) == 1 {
+			screen.setMessage(opt.name + "=" + opt.get(screen))
+			continue
+		}
+		if err := opt.set(screen, pts[1]); err != nil {
+			return BrowserScreenIndex, err
+		}
+		screen.setMessage(opt.name + "=" + opt.get(screen))
+	}
+	return BrowserScreenIndex, nil
+}
+
+func runHelpCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) > 0 {
+		c := getBrowserCommand(cmd.args[0])
+		if c == nil {
+			return BrowserScreenIndex, errors.New("Unknown command " + cmd.args[0])
+		}
+		screen.setMessageWithTimeout(":"+c.usage+" - "+c.description, time.Second*5)
+		return BrowserScreenIndex, nil
+	}
+	var names []string
+	for _, c := range browserCommands {
+		names = append(names, c.names[0])
+	}
+	screen.setMessageWithTimeout("Commands: "+strings.Join(names, ", ")+" (:help <command> for more)", time.Second*5)
+	return BrowserScreenIndex, nil
+}
+
+func completeCommandNames(screen *BrowserScreen, argIdx int, prefix string) []string {
+	if argIdx > 0 {
+		return nil
+	}
+	var ret []string
+	for _, c := range browserCommands {
+		for _, n := range c.names {
+			if strings.HasPrefix(n, prefix) {
+				ret = append(ret, n)
+			}
+		}
+	}
+	return ret
+}
+
+func completeBucketPaths(screen *BrowserScreen, argIdx int, prefix string) []string {
+	if argIdx > 0 {
+		return nil
+	}
+	return screen.completePath(prefix, true)
+}
+
+func completePaths(screen *BrowserScreen, argIdx int, prefix string) []string {
+	if argIdx > 0 {
+		return nil
+	}
+	return screen.completePath(prefix, false)
+}
+
+func completeKeys(screen *BrowserScreen, argIdx int, prefix string) []string {
+	if argIdx > 0 {
+		return nil
+	}
+	var ret []string
+	if b, err := screen.db.getBucketFromPath(screen.currentBucketPath()); err == nil {
+		for _, p := range b.pairs {
+			if name := escapeCommandWord(p.key); strings.HasPrefix(name, prefix) {
+				ret = append(ret, name)
+			}
+		}
+	}
+	return ret
+}
+
+func runImportCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) == 2 && (cmd.args[0] == "yaml" || cmd.args[0] == "toml") {
+		return BrowserScreenIndex, screen.runStructuredImport(cmd.args[0], cmd.args[1])
+	}
+	if len(cmd.args) < 2 || (cmd.args[0] != "csv" && cmd.args[0] != "tsv") {
+		return BrowserScreenIndex, errors.New("Usage: import <csv|tsv|yaml|toml> <file> [key=enc] [value=enc]")
+	}
+	return BrowserScreenIndex, screen.runCSVCommand(true, cmd.args[0], cmd.args[1:])
+}
+
+func completeExport(screen *BrowserScreen, argIdx int, prefix string) []string {
+	switch argIdx {
+	case 0:
+		return filterPrefix([]string{"value", "json", "yaml", "toml", "csv", "tsv"}, prefix)
+	case 1:
+		return nil
+	}
+	return completeCSVOptions(prefix)
+}
+
+func completeImport(screen *BrowserScreen, argIdx int, prefix string) []string {
+	switch argIdx {
+	case 0:
+		return filterPrefix([]string{"csv", "tsv", "yaml", "toml"}, prefix)
+	case 1:
+		return nil
+	}
+	return completeCSVOptions(prefix)
+}
+
+func completeCSVOptions(prefix string) []string {
+	opts := []string{"recursive"}
+	for _, enc := range byteEncodings {
+		opts = append(opts, "key="+enc, "value="+enc)
+	}
+	return filterPrefix(opts, prefix)
+}
+
+func completeSetOptions(screen *BrowserScreen, argIdx int, prefix string) []string {
+	var ret []string
+	for _, opt := range setOptions {
+		if strings.HasPrefix(prefix, opt.name+"=") {
+			for _, v := range opt.values() {
+				ret = append(ret, opt.name+"="+v)
+			}
+		} else {
+			ret = append(ret, opt.name+"=")
+		}
+	}
+	return filterPrefix(ret, prefix)
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	var ret []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+/*
+completePath completes a (possibly partial) path argument, bucket
+candidates end with a '/' so that you can keep tabbing down the tree
+*/
+func (screen *BrowserScreen) completePath(prefix string, bucketsOnly bool) []string {
+	// Everything up to the last unescaped '/' is the bucket we're looking in
+	dirEnd := -1
+	for i := 0; i < len(prefix); i++ {
+		if prefix[i] == '\\' {
+			i++
+		} else if prefix[i] == '/' {
+			dirEnd = i
+		}
+	}
+	dir, base := prefix[:dirEnd+1], prefix[dirEnd+1:]
+
+	// 'dir' is still escaped the way it was typed
+	dirUnescaped := dir
+	if tokens := tokenizeCommand(dir); len(tokens) > 0 {
+		dirUnescaped = tokens[0].text
+	}
+	var buckets []BoltBucket
+	var pairs []BoltPair
+	if dirPath := screen.resolvePath(dirUnescaped); len(dirPath) == 0 {
+		buckets = screen.db.buckets
+	} else if b, err := screen.db.getBucketFromPath(dirPath); err == nil {
+		buckets, pairs = b.buckets, b.pairs
+	} else {
+		return nil
+	}
+	var ret []string
+	for _, b := range buckets {
+		if name := escapeCommandWord(b.name); strings.HasPrefix(name, base) {
+			ret = append(ret, dir+name+"/")
+		}
+	}
+	if !bucketsOnly {
+		for _, p := range pairs {
+			if name := escapeCommandWord(p.key); strings.HasPrefix(name, base) {
+				ret = append(ret, dir+name)
+			}
+		}
+	}
+	return ret
+}
+
+/*
+completeCommandLine completes the word before the cursor. It returns the
+new line and cursor, along with all of the candidates if there was more than one
+*/
+func (screen *BrowserScreen) completeCommandLine(line string, cursor int) (string, int, []string) {
+	runes := []rune(line)
+	before := string(runes[:cursor])
+	tokens := tokenizeCommand(before)
+	// Which word are we in, and where does it start?
+	wordIdx, wordStart := len(tokens), cursor
+	if len(tokens) > 0 && tokens[len(tokens)-1].end == cursor {
+		wordIdx, wordStart = len(tokens)-1, tokens[len(tokens)-1].start
+	}
+	word := string(runes[wordStart:cursor])
+
+	var candidates []string
+	if wordIdx == 0 {
+		candidates = completeCommandNames(screen, 0, word)
+	} else if c := getBrowserCommand(tokens[0].text); c != nil && c.complete != nil {
+		candidates = c.complete(screen, wordIdx-1, word)
+	}
+	if len(candidates) == 0 {
+		return line, cursor, nil
+	}
+	sort.Strings(candidates)
+	completion := candidates[0]
+	for _, c := range candidates[1:] {
+		completion = commonPrefix(completion, c)
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(completion, "/") && !strings.HasSuffix(completion, "=") {
+		completion += " "
+	}
+	newLine := string(runes[:wordStart]) + completion + string(runes[cursor:])
+	newCursor := wordStart + len([]rune(completion))
+	if len(candidates) == 1 {
+		return newLine, newCursor, nil
+	}
+	return newLine, newCursor, candidates
+}
+
+func commonPrefix(a, b string) string {
+	ar, br := []rune(a), []rune(b)
+	i := 0
+	for i < len(ar) && i < len(br) && ar[i] == br[i] {
+		i++
+	}
+	return string(ar[:i])
+}
+
+/*
+runCommandLine runs a line typed at the ':' prompt
+*/
+func (screen *BrowserScreen) runCommandLine(line string) int {
+	cmd := parseCommand(line)
+	if cmd == nil {
+		return BrowserScreenIndex
+	}
+	c := getBrowserCommand(cmd.name)
+	if c == nil {
+		screen.setMessage("Unknown command: " + cmd.name)
+		return BrowserScreenIndex
+	}
+	ret, err := c.run(screen, cmd)
+	if err != nil {
+		screen.setMessage(err.Error())
+	}
+	return ret
+}
+
+// How many commands we keep in the history file
+const maxCommandHistory = 500
+
+/*
+CommandHistory holds previously run commands, and where we are
+when stepping through them with up/down
+*/
+type CommandHistory struct {
+	entries []string
+	idx     int
+	draft   string
+}
+
+func commandHistoryFile() string {
+	return filepath.Join(configDir(), "history")
+}
+
+func loadCommandHistory() *CommandHistory {
+	h := new(CommandHistory)
+	if f, err := os.Open(commandHistoryFile()); err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				h.entries = append(h.entries, line)
+			}
+		}
+	}
+	h.idx = len(h.entries)
+	return h
+}
+
+// add appends 'cmd' to the history and saves it
+func (h *CommandHistory) add(cmd string) error {
+	if cmd == "" {
+		return nil
+	}
+	if len(h.entries) == 0 || h.entries[len(h.entries)-1] != cmd {
+		h.entries = append(h.entries, cmd)
+	}
+	if len(h.entries) > maxCommandHistory {
+		h.entries = h.entries[len(h.entries)-maxCommandHistory:]
+	}
+	h.idx = len(h.entries)
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(commandHistoryFile(), []byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+}
+
+// prev steps back through the history, 'current' is kept to come back to
+func (h *CommandHistory) prev(current string) (string, bool) {
+	if h.idx == 0 {
+		return "", false
+	}
+	if h.idx == len(h.entries) {
+		h.draft = current
+	}
+	h.idx--
+	return h.entries[h.idx], true
+}
+
+func (h *CommandHistory) next() (string, bool) {
+	if h.idx >= len(h.entries) {
+		return "", false
+	}
+	h.idx++
+	if h.idx == len(h.entries) {
+		return h.draft, true
+	}
+	return h.entries[h.idx], true
+}
+
+func (h *CommandHistory) reset() {
+	h.idx = len(h.entries)
+	h.draft = ""
+}
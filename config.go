@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+/*
+ThemeConfig is a theme section from the config file. Each key is a UI
+element mapping to {fg, bg} (or just a colour for the foreground),
+and the optional "base" key names the theme it starts from
+*/
+type ThemeConfig map[string]interface{}
+
+/*
+Config is what we read from ~/.config/bolt/config.toml
+*/
+type Config struct {
+	Theme  string                 `toml:"theme"`
+	Themes map[string]ThemeConfig `toml:"themes"`
+	// Key sequence => action name, see keymap.go
+	Keys   map[string]string `toml:"keys"`
+	Layout LayoutConfig      `toml:"layout"`
+}
+
+var AppConfig Config
+
+// configDir returns the directory our config (and any saved state) lives in
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, ProgramName)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", ProgramName)
+}
+
+func configFile() string {
+	return filepath.Join(configDir(), "config.toml")
+}
+
+/*
+databaseStateDir is where we keep what we remember about the open
+database (sort orders, bookmarks...), one directory per database file.
+It's keyed on the name that was given, not the temp copy of a compressed
+file, so a backup keeps its bookmarks between runs. Stdin is always "-",
+wherever bolt is run from
+*/
+func databaseStateDir() string {
+	path := currentFilename
+	if path != "-" {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	sum := sha1.Sum([]byte(path))
+	return filepath.Join(configDir(), "databases", hex.EncodeToString(sum[:8]))
+}
+
+// loadDatabaseState reads the JSON file 'name' for the open database into 'v'
+func loadDatabaseState(name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(databaseStateDir(), name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func saveDatabaseState(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := databaseStateDir()
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// Write it alongside and rename so a crash can't leave half a file
+	tmp := filepath.Join(dir, name+".tmp")
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
+
+/*
+loadConfig reads the config file into AppConfig.
+A missing config file is not an error, we just use the defaults
+*/
+func loadConfig() error {
+	if _, err := toml.DecodeFile(configFile(), &AppConfig); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if AppConfig.Layout.Mode != "" {
+		if _, err := parseLayoutMode(AppConfig.Layout.Mode); err != nil {
+			return err
+		}
+	}
+	if AppConfig.Layout.Split != 0 && (AppConfig.Layout.Split <= 0 || AppConfig.Layout.Split >= 1) {
+		return errors.New("layout.split must be between 0 and 1")
+	}
+	return nil
+}
+
+/*
+loadStyle builds the Style for the theme picked with -theme,
+falling back to the theme set in the config file
+*/
+func loadStyle() (Style, error) {
+	name := AppArgs.Theme
+	if name == "" {
+		name = AppConfig.Theme
+	}
+	return buildStyle(name, AppConfig.Themes)
+}
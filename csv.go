@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// byteEncodings are the ways keys and values can be written as text
+var byteEncodings = []string{"utf8", "hex", "base64", "uint64"}
+
+/*
+CSVOptions are the settings for a CSV or TSV export or import.
+With recursive set, sub-buckets are exported too and the first
+column is the path of the bucket each pair is in
+*/
+type CSVOptions struct {
+	comma     rune
+	recursive bool
+	keyEnc    string
+	valueEnc  string
+}
+
+/*
+parseCSVOptions reads the words after the file name, e.g. "key=uint64
+value=base64 recursive". 'tsv' or 'csv' picks the separator,
+otherwise it comes from the file name
+*/
+func parseCSVOptions(fileName string, words []string) (CSVOptions, error) {
+	o := CSVOptions{comma: ',', keyEnc: "utf8", valueEnc: "utf8"}
+	if strings.EqualFold(filepath.Ext(fileName), ".tsv") {
+		o.comma = '\t'
+	}
+	for _, w := range words {
+		switch {
+		case w == "csv":
+			o.comma = ','
+		case w == "tsv":
+			o.comma = '\t'
+		case w == "recursive":
+			o.recursive = true
+		case strings.HasPrefix(w, "key="):
+			o.keyEnc = strings.TrimPrefix(w, "key=")
+		case strings.HasPrefix(w, "value="):
+			o.valueEnc = strings.TrimPrefix(w, "value=")
+		default:
+			return o, fmt.Errorf("Unknown CSV option %q (key=, value=, recursive, csv or tsv)", w)
+		}
+	}
+	for _, enc := range []string{o.keyEnc, o.valueEnc} {
+		if !stringInSlice(enc, byteEncodings) {
+			return o, fmt.Errorf("Unknown encoding %q, use one of %s", enc, strings.Join(byteEncodings, ", "))
+		}
+	}
+	return o, nil
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeBytes(enc string, b []byte) (string, error) {
+	switch enc {
+	case "hex":
+		return hex.EncodeToString(b), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(b), nil
+	case "uint64":
+		if len(b) != 8 {
+			return "", fmt.Errorf("%s is %d bytes, not a uint64", stringify(b), len(b))
+		}
+		return strconv.FormatUint(binary.BigEndian.Uint64(b), 10), nil
+	}
+	return string(b), nil
+}
+
+func decodeBytes(enc string, s string) ([]byte, error) {
+	switch enc {
+	case "hex":
+		return hex.DecodeString(s)
+	case "base64":
+		return base64.StdEncoding.DecodeString(s)
+	case "uint64":
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q isn't a uint64", s)
+		}
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, u)
+		return b, nil
+	}
+	return []byte(s), nil
+}
+
+/*
+csvPathColumn is a bucket path relative to the exported bucket, the names are
+in the key encoding and a '/' or '\' in one is escaped with a '\'
+*/
+func csvPathColumn(path [][]byte, enc string) (string, error) {
+	var parts []string
+	for _, name := range path {
+		s, err := encodeBytes(enc, name)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, strings.NewReplacer("\\", "\\\\", "/", "\\/").Replace(s))
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+func splitCSVPathColumn(s string, enc string) ([][]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var parts []string
+	var cur []byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			i++
+			cur = append(cur, s[i])
+		case s[i] == '/':
+			parts = append(parts, string(cur))
+			cur = nil
+		default:
+			cur = append(cur, s[i])
+		}
+	}
+	parts = append(parts, string(cur))
+	var path [][]byte
+	for _, p := range parts {
+		name, err := decodeBytes(enc, p)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, name)
+	}
+	return path, nil
+}
+
+// csvHeader is the first row of an export, imports use it to find the columns
+func csvHeader(o CSVOptions) []string {
+	if o.recursive {
+		return []string{"path", "key", "value"}
+	}
+	return []string{"key", "value"}
+}
+
+/*
+exportCSV writes the pairs in the bucket at 'path' (the whole database
+for an empty path, which needs recursive) and returns how many it wrote
+*/
+func exportCSV(tx *bolt.Tx, path []string, out io.Writer, o CSVOptions) (int, error) {
+	w := csv.NewWriter(out)
+	w.Comma = o.comma
+	if err := w.Write(csvHeader(o)); err != nil {
+		return 0, err
+	}
+	count := 0
+	var walk func(b *bolt.Bucket, rel [][]byte) error
+	walk = func(b *bolt.Bucket, rel [][]byte) error {
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				if !o.recursive {
+					return nil
+				}
+				return walk(b.Bucket(k), append(rel[:len(rel):len(rel)], k))
+			}
+			key, err := encodeBytes(o.keyEnc, k)
+			if err != nil {
+				return err
+			}
+			val, err := encodeBytes(o.valueEnc, v)
+			if err != nil {
+				return fmt.Errorf("value of %s: %s", stringify(k), err)
+			}
+			row := []string{key, val}
+			if o.recursive {
+				p, err := csvPathColumn(rel, o.keyEnc)
+				if err != nil {
+					return err
+				}
+				row = append([]string{p}, row...)
+			}
+			count++
+			return w.Write(row)
+		})
+	}
+	var err error
+	if len(path) == 0 {
+		if !o.recursive {
+			return 0, errors.New("Only buckets have pairs, export the whole database with recursive")
+		}
+		err = tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walk(b, [][]byte{name})
+		})
+	} else if b := getBoltBucket(tx, path); b == nil {
+		err = fmt.Errorf("No such bucket %s", formatQueryPath(path))
+	} else {
+		err = walk(b, nil)
+	}
+	if err != nil {
+		return count, err
+	}
+	w.Flush()
+	return count, w.Error()
+}
+
+/*
+importCSV puts the rows of a CSV file into the bucket at 'path', making the
+buckets in a path column as it goes. The header says which column is which,
+without one the columns are key and value
+*/
+func importCSV(tx *bolt.Tx, path []string, in io.Reader, o CSVOptions) (int, error) {
+	r := csv.NewReader(in)
+	r.Comma = o.comma
+	r.FieldsPerRecord = -1
+	pathCol, keyCol, valCol := -1, 0, 1
+	count := 0
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if line == 1 && stringInSlice("key", row) && stringInSlice("value", row) {
+			pathCol = -1
+			for i, name := range row {
+				switch name {
+				case "path":
+					pathCol = i
+				case "key":
+					keyCol = i
+				case "value":
+					valCol = i
+				}
+			}
+			continue
+		}
+		if keyCol >= len(row) || valCol >= len(row) || pathCol >= len(row) {
+			return count, fmt.Errorf("Line %d: not enough columns", line)
+		}
+		bktPath := make([][]byte, 0, len(path))
+		for _, name := range path {
+			bktPath = append(bktPath, []byte(name))
+		}
+		if pathCol >= 0 {
+			rel, err := splitCSVPathColumn(row[pathCol], o.keyEnc)
+			if err != nil {
+				return count, fmt.Errorf("Line %d: %s", line, err)
+			}
+			bktPath = append(bktPath, rel...)
+		}
+		if len(bktPath) == 0 {
+			return count, fmt.Errorf("Line %d: pairs have to go in a bucket", line)
+		}
+		key, err := decodeBytes(o.keyEnc, row[keyCol])
+		if err != nil {
+			return count, fmt.Errorf("Line %d: %s", line, err)
+		}
+		val, err := decodeBytes(o.valueEnc, row[valCol])
+		if err != nil {
+			return count, fmt.Errorf("Line %d: %s", line, err)
+		}
+		b, err := tx.CreateBucketIfNotExists(bktPath[0])
+		for i := 1; i < len(bktPath) && err == nil; i++ {
+			b, err = b.CreateBucketIfNotExists(bktPath[i])
+		}
+		if err != nil {
+			return count, fmt.Errorf("Line %d: %s", line, err)
+		}
+		if b.Bucket(key) != nil {
+			return count, fmt.Errorf("Line %d: %s is a bucket", line, stringify(key))
+		}
+		if err := b.Put(key, val); err != nil {
+			return count, fmt.Errorf("Line %d: %s", line, err)
+		}
+		count++
+	}
+}
+
+/*
+exportCSVFile exports to a file, or stdout for "-". A file is only
+replaced once every row is written, see writeFileAtomic
+*/
+func exportCSVFile(bdb *bolt.DB, path []string, fileName string, o CSVOptions) (int, error) {
+	var count int
+	export := func(w io.Writer) (string, error) {
+		return "", bdb.View(func(tx *bolt.Tx) error {
+			var err error
+			count, err = exportCSV(tx, path, w, o)
+			return err
+		})
+	}
+	var err error
+	if fileName == "-" {
+		_, err = export(os.Stdout)
+	} else {
+		_, err = writeFileAtomic(fileName, export)
+	}
+	return count, err
+}
+
+// importCSVFile imports from a file, or stdin for "-", all in one transaction
+func importCSVFile(bdb *bolt.DB, path []string, fileName string, o CSVOptions) (int, error) {
+	in := io.Reader(os.Stdin)
+	if fileName != "-" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		in = f
+	}
+	var count int
+	err := bdb.Update(func(tx *bolt.Tx) error {
+		var err error
+		count, err = importCSV(tx, path, in, o)
+		return err
+	})
+	return count, err
+}
+
+/*
+runCSVCommand does ':export csv' and ':import csv' (or tsv) for the current bucket,
+'words' are the file name and then its options
+*/
+func (screen *BrowserScreen) runCSVCommand(importing bool, format string, words []string) error {
+	if len(words) == 0 {
+		return errors.New("No file name")
+	}
+	fileName := unescapeCommandWord(words[0])
+	opts := words[1:]
+	if format != "" {
+		opts = append([]string{format}, opts...)
+	}
+	o, err := parseCSVOptions(fileName, opts)
+	if err != nil {
+		return err
+	}
+	path := screen.currentBucketPath()
+	if importing {
+		if AppArgs.ReadOnly {
+			return errors.New("DB is in Read-Only Mode")
+		}
+		count, err := importCSVFile(db, path, expandHome(fileName), o)
+		if err != nil {
+			return err
+		}
+		screen.refreshDatabase()
+		screen.setMessage(fmt.Sprintf("Imported %d pairs from %s", count, fileName))
+		return nil
+	}
+	return screen.exportTo(fileName, csvExport(path, o))
+}
+
+func csvExport(path []string, o CSVOptions) ExportFunc {
+	return func(w io.Writer) (string, error) {
+		var count int
+		err := viewDatabase(func(tx *bolt.Tx) error {
+			var err error
+			count, err = exportCSV(tx, path, w, o)
+			return err
+		})
+		return fmt.Sprintf("Exported %d pairs", count), err
+	}
+}
+
+func runExportCSV(args []string) error {
+	return runCSVSubcommand(args, false)
+}
+
+func runImportCSV(args []string) error {
+	return runCSVSubcommand(args, true)
+}
+
+// runCSVSubcommand is the 'export-csv' and 'import-csv' subcommands
+func runCSVSubcommand(args []string, importing bool) error {
+	name := "export-csv"
+	if importing {
+		name = "import-csv"
+	}
+	if len(args) < 3 {
+		return errors.New("Usage: " + ProgramName + " " + name + " <filename> <bucket path> <file|-> [key=enc] [value=enc] [recursive] [tsv]")
+	}
+	dbFile, fileName := args[0], args[2]
+	// "" or "/" is the whole database, which needs recursive
+	var path []string
+	var err error
+	if args[1] != "" && args[1] != "/" {
+		if path, err = parseBucketPath(args[1]); err != nil {
+			return err
+		}
+	}
+	o, err := parseCSVOptions(fileName, args[3:])
+	if err != nil {
+		return err
+	}
+	if importing {
+		if AppArgs.ReadOnly {
+			return errors.New("DB is in Read-Only Mode")
+		}
+		bdb, err := openForWrite(dbFile)
+		if err != nil {
+			return err
+		}
+		defer bdb.Close()
+		count, err := importCSVFile(bdb, path, fileName, o)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Imported %d pairs\n", count)
+		}
+		return err
+	}
+	bdb, err := openForBackup(dbFile)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	count, err := exportCSVFile(bdb, path, fileName, o)
+	if err == nil && fileName != "-" {
+		fmt.Fprintf(os.Stderr, "Exported %d pairs\n", count)
+	}
+	return err
+}
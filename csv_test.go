@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// csvPairs is path/key -> value for the pairs a CSV export of 'path' holds
+func csvPairs(tx *bolt.Tx, path []string, recursive bool) map[string]string {
+	m := map[string]string{}
+	var walk func(b *bolt.Bucket, prefix string)
+	walk = func(b *bolt.Bucket, prefix string) {
+		b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				m[prefix+string(k)] = string(v)
+			} else if recursive {
+				walk(b.Bucket(k), prefix+formatQueryPath([]string{string(k)})+"/")
+			}
+			return nil
+		})
+	}
+	if len(path) == 0 {
+		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			walk(b, formatQueryPath([]string{string(name)})+"/")
+			return nil
+		})
+	} else {
+		walk(getBoltBucket(tx, path), "")
+	}
+	return m
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	bdb, err := bolt.Open(filepath.Join(dir, "csv.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		if err := fillServeTestDB(tx); err != nil {
+			return err
+		}
+		// Separators, quotes and newlines have to survive too
+		if err := tx.Bucket([]byte("users")).Put([]byte("a,b"), []byte("say \"hi\"\nbye\t!")); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("a/b")).Put([]byte("k"), []byte("v"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct {
+		path []string
+		opts []string
+	}{
+		{[]string{"users", "active"}, nil},
+		{[]string{"users", "active"}, []string{"tsv"}},
+		{[]string{"users"}, []string{"recursive", "key=hex", "value=base64"}},
+		{[]string{"nums"}, []string{"key=uint64"}},
+		// The whole database, "a/b" has to stay one bucket
+		{nil, []string{"recursive", "value=base64"}},
+	} {
+		o, err := parseCSVOptions("out.csv", c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		var exported, imported int
+		err = bdb.View(func(tx *bolt.Tx) error {
+			exported, err = exportCSV(tx, c.path, &out, o)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%v %v: %s", c.path, c.opts, err)
+		}
+		copyDB, err := bolt.Open(filepath.Join(dir, fmt.Sprintf("copy%d.db", i)), 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = copyDB.Update(func(tx *bolt.Tx) error {
+			imported, err = importCSV(tx, c.path, bytes.NewReader(out.Bytes()), o)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%v %v: %s\n%s", c.path, c.opts, err, out.String())
+		}
+		if imported != exported {
+			t.Errorf("%v %v: exported %d pairs and imported %d", c.path, c.opts, exported, imported)
+		}
+		var want, got map[string]string
+		bdb.View(func(tx *bolt.Tx) error {
+			want = csvPairs(tx, c.path, o.recursive)
+			return nil
+		})
+		copyDB.View(func(tx *bolt.Tx) error {
+			got = csvPairs(tx, c.path, o.recursive)
+			return nil
+		})
+		copyDB.Close()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v %v: got\n%q\nwant\n%q\nfrom\n%s", c.path, c.opts, got, want, out.String())
+		}
+	}
+}
+
+func TestExportCSVFile(t *testing.T) {
+	dir := t.TempDir()
+	bdb, err := bolt.Open(filepath.Join(dir, "csv.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	if err = bdb.Update(fillServeTestDB); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, "out.csv")
+	if err = os.WriteFile(name, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// "u0" isn't 8 bytes, so the export fails part way and the old file stays
+	o, _ := parseCSVOptions(name, []string{"key=uint64"})
+	if _, err = exportCSVFile(bdb, []string{"users", "active"}, name, o); err == nil || !strings.Contains(err.Error(), "not a uint64") {
+		t.Errorf("got %v exporting keys that aren't uint64s", err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "old" {
+		t.Errorf("the file is now %q", b)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 2 {
+		t.Errorf("%d files left, want 2", len(files))
+	}
+
+	o, _ = parseCSVOptions(name, nil)
+	if n, err := exportCSVFile(bdb, []string{"users", "active"}, name, o); err != nil || n != 6 {
+		t.Errorf("exported %d pairs, %v", n, err)
+	}
+	if b, _ := os.ReadFile(name); !strings.HasPrefix(string(b), "key,value\nu0,") {
+		t.Errorf("the file is %q", b)
+	}
+}
+
+func TestCSVSubcommandPaths(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "typo.db")
+	if err := runImportCSV([]string{missing, "b", filepath.Join(dir, "in.csv")}); !os.IsNotExist(err) {
+		t.Errorf("got %v importing into a database that isn't there", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("importing made a new database")
+	}
+	for _, p := range []string{"a//b", "a/../b", "./a"} {
+		if err := runExportCSV([]string{missing, p, "-"}); err == nil || !strings.Contains(err.Error(), p) {
+			t.Errorf("%s: got %v", p, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+/*
+Decoder turns a raw value into something readable for the right pane
+*/
+type Decoder struct {
+	name   string
+	decode func(v []byte) (string, error)
+}
+
+const defaultDecoderName = "auto"
+
+var decoders = []Decoder{
+	{"auto", decodeAuto},
+	{"string", func(v []byte) (string, error) { return string(v), nil }},
+	{"hex", func(v []byte) (string, error) { return hex.Dump(v), nil }},
+	{"base64", func(v []byte) (string, error) { return base64.StdEncoding.EncodeToString(v), nil }},
+	{"uint64", decodeUint64},
+	{"json", decodeJSON},
+	{"msgpack", decodeMsgpack},
+}
+
+func getDecoder(name string) *Decoder {
+	for i := range decoders {
+		if decoders[i].name == name {
+			return &decoders[i]
+		}
+	}
+	return nil
+}
+
+func decoderNames() []string {
+	var names []string
+	for _, d := range decoders {
+		names = append(names, d.name)
+	}
+	return names
+}
+
+// decodeAuto pretty prints JSON, and otherwise does what stringify does
+func decodeAuto(v []byte) (string, error) {
+	if json.Valid(v) && len(bytes.TrimSpace(v)) > 0 && (v[0] == '{' || v[0] == '[') {
+		return decodeJSON(v)
+	}
+	return stringify(v), nil
+}
+
+func decodeUint64(v []byte) (string, error) {
+	if len(v) != 8 {
+		return "", fmt.Errorf("Not a uint64 (%d bytes)", len(v))
+	}
+	return fmt.Sprintf("%d", binary.BigEndian.Uint64(v)), nil
+}
+
+func decodeJSON(v []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, v, "", "  "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func decodeMsgpack(v []byte) (string, error) {
+	var d interface{}
+	if err := msgpack.Unmarshal(v, &d); err != nil {
+		return "", err
+	}
+	out, err := json.MarshalIndent(jsonSafe(d), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// jsonSafe converts what msgpack gives us into something encoding/json can marshal
+func jsonSafe(d interface{}) interface{} {
+	switch t := d.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			t[k] = jsonSafe(v)
+		}
+		return t
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = jsonSafe(v)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = jsonSafe(t[i])
+		}
+		return t
+	case []byte:
+		return stringify(t)
+	}
+	return d
+}
+
+/*
+parsers turn a value into data (maps, slices, strings, numbers) for
+decoders that have some structure, for queries to look inside
+*/
+var parsers = map[string]func(v []byte) (interface{}, error){
+	"json":    parseJSON,
+	"msgpack": parseMsgpack,
+}
+
+func parseJSON(v []byte) (interface{}, error) {
+	var d interface{}
+	err := json.Unmarshal(v, &d)
+	return d, err
+}
+
+func parseMsgpack(v []byte) (interface{}, error) {
+	var d interface{}
+	if err := msgpack.Unmarshal(v, &d); err != nil {
+		return nil, err
+	}
+	return jsonSafe(d), nil
+}
+
+/*
+parseValue parses 'v' with the named decoder, "auto" tries JSON and then msgpack
+*/
+func parseValue(name string, v []byte) (interface{}, error) {
+	if name == defaultDecoderName {
+		if d, err := parseJSON(v); err == nil {
+			return d, nil
+		}
+		return parseMsgpack(v)
+	}
+	parse, ok := parsers[name]
+	if !ok {
+		return nil, fmt.Errorf("The %s decoder has no fields", name)
+	}
+	return parse(v)
+}
+
+/*
+decodeValue runs the named decoder, falling back to stringify
+(with the error) if the value can't be decoded that way
+*/
+func decodeValue(name string, v []byte) (string, error) {
+	dec := getDecoder(name)
+	if dec == nil {
+		return stringify(v), errors.New("Unknown decoder " + name)
+	}
+	s, err := dec.decode(v)
+	if err != nil {
+		return stringify(v), fmt.Errorf("%s: %s", dec.name, err)
+	}
+	return strings.TrimRight(s, "\n"), nil
+}
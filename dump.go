@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// dumpFormat is the first line of a dump, so restore can tell what it's been given
+const dumpFormat = "bolt-dump"
+
+const dumpVersion = 1
+
+// How many records restore puts in each transaction
+const restoreBatchSize = 10000
+
+/*
+DumpRecord is one line of a dump, a JSON object. Byte strings
+are base64 (encoding/json does that for []byte) so any key or value
+survives the trip. Buckets come before everything in them:
+
+	{"format":"bolt-dump","version":1}
+	{"type":"bucket","path":["dXNlcnM="],"sequence":42}
+	{"type":"pair","path":["dXNlcnM="],"key":"MjAyNC0wMQ==","value":"e30="}
+
+'path' is the full path of the bucket (for a pair, the bucket it's in)
+*/
+type DumpRecord struct {
+	Format   string   `json:"format,omitempty"`
+	Version  int      `json:"version,omitempty"`
+	Type     string   `json:"type,omitempty"`
+	Path     [][]byte `json:"path,omitempty"`
+	Sequence uint64   `json:"sequence,omitempty"`
+	Key      []byte   `json:"key,omitempty"`
+	// A pointer so that an empty value is written and a missing one isn't
+	Value *[]byte `json:"value,omitempty"`
+}
+
+// dumpDatabase writes every bucket and pair in 'tx' to 'out', returning how many records it wrote
+func dumpDatabase(tx *bolt.Tx, out io.Writer) (int, error) {
+	enc := json.NewEncoder(out)
+	if err := enc.Encode(DumpRecord{Format: dumpFormat, Version: dumpVersion}); err != nil {
+		return 0, err
+	}
+	count := 0
+	var dumpBucket func(b *bolt.Bucket, path [][]byte) error
+	dumpBucket = func(b *bolt.Bucket, path [][]byte) error {
+		if err := enc.Encode(DumpRecord{Type: "bucket", Path: path, Sequence: b.Sequence()}); err != nil {
+			return err
+		}
+		count++
+		return b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				return dumpBucket(b.Bucket(k), append(path[:len(path):len(path)], k))
+			}
+			count++
+			return enc.Encode(DumpRecord{Type: "pair", Path: path, Key: k, Value: &v})
+		})
+	}
+	err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return dumpBucket(b, [][]byte{name})
+	})
+	return count, err
+}
+
+/*
+restoreDatabase reads a dump into 'bdb', which has to be empty. It's
+done in batches of restoreBatchSize records so a dump bigger than
+memory can still be restored
+*/
+func restoreDatabase(bdb *bolt.DB, in io.Reader) (int, error) {
+	dec := json.NewDecoder(in)
+	var header DumpRecord
+	if err := dec.Decode(&header); err != nil || header.Format != dumpFormat {
+		return 0, errors.New("Not a bolt dump")
+	}
+	if header.Version > dumpVersion {
+		return 0, fmt.Errorf("Dump is version %d, this only understands up to %d", header.Version, dumpVersion)
+	}
+	err := bdb.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Cursor().First(); k != nil {
+			return errors.New("Database isn't empty, restore into a new file")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for done := false; !done; {
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			// The bucket the last record was in, pairs usually follow their bucket
+			var lastPath [][]byte
+			var last *bolt.Bucket
+			for n := 0; n < restoreBatchSize; n++ {
+				var r DumpRecord
+				if err := dec.Decode(&r); err == io.EOF {
+					done = true
+					return nil
+				} else if err != nil {
+					return fmt.Errorf("Record %d: %s", count+1, err)
+				}
+				if len(r.Path) == 0 {
+					return fmt.Errorf("Record %d: no path", count+1)
+				}
+				var b *bolt.Bucket
+				if r.Type == "bucket" {
+					var err error
+					if len(r.Path) == 1 {
+						b, err = tx.CreateBucket(r.Path[0])
+					} else if parent := getDumpBucket(tx, r.Path[:len(r.Path)-1]); parent != nil {
+						b, err = parent.CreateBucket(r.Path[len(r.Path)-1])
+					} else {
+						err = errors.New("its parent hasn't been restored")
+					}
+					if err != nil {
+						return fmt.Errorf("Record %d: bucket %s: %s", count+1, formatDumpPath(r.Path), err)
+					}
+				} else if last != nil && pathsEqual(r.Path, lastPath) {
+					b = last
+				} else if b = getDumpBucket(tx, r.Path); b == nil {
+					return fmt.Errorf("Record %d: bucket %s hasn't been restored", count+1, formatDumpPath(r.Path))
+				}
+				switch r.Type {
+				case "bucket":
+					if err := b.SetSequence(r.Sequence); err != nil {
+						return err
+					}
+				case "pair":
+					var v []byte
+					if r.Value != nil {
+						v = *r.Value
+					}
+					if err := b.Put(r.Key, v); err != nil {
+						return fmt.Errorf("Record %d: %s", count+1, err)
+					}
+				default:
+					return fmt.Errorf("Record %d: unknown type %q", count+1, r.Type)
+				}
+				lastPath, last = r.Path, b
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			// It was empty, so leave it that way rather than half restored
+			if cerr := emptyDatabase(bdb); cerr != nil {
+				return count, fmt.Errorf("%s (and the part restored is still there: %s)", err, cerr)
+			}
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// emptyDatabase deletes every bucket in 'bdb'
+func emptyDatabase(bdb *bolt.DB) error {
+	return bdb.Update(func(tx *bolt.Tx) error {
+		var names [][]byte
+		tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func getDumpBucket(tx *bolt.Tx, path [][]byte) *bolt.Bucket {
+	if len(path) == 0 {
+		return nil
+	}
+	b := tx.Bucket(path[0])
+	for i := 1; i < len(path) && b != nil; i++ {
+		b = b.Bucket(path[i])
+	}
+	return b
+}
+
+func pathsEqual(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func formatDumpPath(path [][]byte) string {
+	var p []string
+	for _, name := range path {
+		p = append(p, string(name))
+	}
+	return formatQueryPath(p)
+}
+
+// runDump is the 'dump' subcommand
+func runDump(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: " + ProgramName + " dump <filename> <output|->")
+	}
+	dbFile, outFile := args[0], args[1]
+	bdb, err := openForBackup(dbFile)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+
+	var out io.Writer = os.Stdout
+	if outFile != "-" {
+		f, err := os.OpenFile(outFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	out = w
+	if AppArgs.Gzip || strings.HasSuffix(outFile, ".gz") {
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	var count int
+	err = bdb.View(func(tx *bolt.Tx) error {
+		count, err = dumpDatabase(tx, out)
+		return err
+	})
+	if gz, ok := out.(*gzip.Writer); ok && err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		if outFile != "-" {
+			// Don't leave half a dump lying around
+			os.Remove(outFile)
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Dumped %d records\n", count)
+	return nil
+}
+
+// runRestore is the 'restore' subcommand, gzipped dumps are spotted by their first bytes
+func runRestore(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: " + ProgramName + " restore <dump|-> <filename>")
+	}
+	inFile, dbFile := args[0], args[1]
+	var in io.Reader = os.Stdin
+	if inFile != "-" {
+		f, err := os.Open(inFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	br := bufio.NewReader(in)
+	in = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	_, statErr := os.Stat(dbFile)
+	created := os.IsNotExist(statErr)
+	bdb, err := bolt.Open(dbFile, 0600, &bolt.Options{Timeout: AppArgs.DBOpenTimeout})
+	if err != nil {
+		return err
+	}
+	count, err := restoreDatabase(bdb, in)
+	if cerr := bdb.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if created {
+			// Nothing to show for it, so don't leave a new file behind
+			os.Remove(dbFile)
+		}
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Restored %d records\n", count)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// fillDumpTestDB makes buckets that are easy to get wrong: empty ones, names that need escaping and sequences
+func fillDumpTestDB(tx *bolt.Tx) error {
+	a, err := tx.CreateBucket([]byte("a"))
+	if err != nil {
+		return err
+	}
+	if err = a.SetSequence(42); err != nil {
+		return err
+	}
+	b, err := a.CreateBucket([]byte("b"))
+	if err != nil {
+		return err
+	}
+	if err = b.Put([]byte("k"), []byte("nested")); err != nil {
+		return err
+	}
+	// Not the same bucket as a/b
+	ab, err := tx.CreateBucket([]byte("a/b"))
+	if err != nil {
+		return err
+	}
+	if err = ab.Put([]byte("k"), []byte("slash")); err != nil {
+		return err
+	}
+	if _, err = a.CreateBucket([]byte("empty")); err != nil {
+		return err
+	}
+	bin, err := tx.CreateBucket([]byte{0, 0xff, '\n'})
+	if err != nil {
+		return err
+	}
+	if err = bin.Put([]byte{0xfe, 0}, []byte{}); err != nil {
+		return err
+	}
+	return bin.Put([]byte("\"quoted\"\n"), []byte{0, 1, 2, 0xff})
+}
+
+func dumpFile(t *testing.T, bdb *bolt.DB) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := bdb.View(func(tx *bolt.Tx) error {
+		_, err := dumpDatabase(tx, &buf)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDumpRestoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src, err := bolt.Open(filepath.Join(dir, "src.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if err = src.Update(fillDumpTestDB); err != nil {
+		t.Fatal(err)
+	}
+	dump := dumpFile(t, src)
+
+	dst, err := bolt.Open(filepath.Join(dir, "dst.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err = restoreDatabase(dst, bytes.NewReader(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if again := dumpFile(t, dst); !bytes.Equal(dump, again) {
+		t.Fatalf("restored database dumps differently:\n%s\nvs\n%s", dump, again)
+	}
+	err = dst.View(func(tx *bolt.Tx) error {
+		if seq := tx.Bucket([]byte("a")).Sequence(); seq != 42 {
+			t.Errorf("sequence of a is %d, want 42", seq)
+		}
+		if tx.Bucket([]byte("a")).Bucket([]byte("empty")) == nil {
+			t.Error("empty bucket wasn't restored")
+		}
+		if v := tx.Bucket([]byte{0, 0xff, '\n'}).Get([]byte{0xfe, 0}); v == nil || len(v) != 0 {
+			t.Errorf("empty value restored as %v", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second restore into the same file is refused
+	if _, err = restoreDatabase(dst, bytes.NewReader(dump)); err == nil {
+		t.Error("restore into a database that isn't empty worked")
+	}
+}
+
+func TestRestoreFailure(t *testing.T) {
+	dir := t.TempDir()
+	src, err := bolt.Open(filepath.Join(dir, "src.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	err = src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("big"))
+		for i := 0; i < restoreBatchSize && err == nil; i++ {
+			err = b.Put([]byte(fmt.Sprintf("%05d", i)), nil)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first batch is written before the bad line is read
+	dump := append(dumpFile(t, src), "{\"type\":\n"...)
+	dumpName := filepath.Join(dir, "bad.dump")
+	if err = os.WriteFile(dumpName, dump, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new file is removed...
+	newDB := filepath.Join(dir, "new.db")
+	if err = runRestore([]string{dumpName, newDB}); err == nil {
+		t.Fatal("no error from a bad dump")
+	}
+	if _, err = os.Stat(newDB); !os.IsNotExist(err) {
+		t.Errorf("the half restored file is still there: %v", err)
+	}
+
+	// ...and one that was already there is left empty
+	dst, err := bolt.Open(filepath.Join(dir, "dst.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if n, err := restoreDatabase(dst, bytes.NewReader(dump)); err == nil || n != 0 {
+		t.Errorf("restored %d records, %v", n, err)
+	}
+	dst.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Cursor().First(); k != nil {
+			t.Errorf("%s is still there", k)
+		}
+		return nil
+	})
+}
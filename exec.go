@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+ExecItem is what an `exec` template is given for each bucket and pair.
+Key and Value are the raw bytes (use the hex, base64 or uint64 helpers on
+binary ones), Decoded is the value as JSON or msgpack data, nil if it's
+neither (or a bucket)
+*/
+type ExecItem struct {
+	// Path is the whole path, as a query would print it
+	Path string
+	// Bucket is the path of the bucket it's in, "" at the root
+	Bucket   string
+	Key      string
+	Value    string
+	Decoded  interface{}
+	IsBucket bool
+	Sequence uint64
+	Depth    int
+}
+
+var execFuncs = template.FuncMap{
+	"hex":    func(s string) string { return hex.EncodeToString([]byte(s)) },
+	"base64": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"uint64": func(s string) (uint64, error) {
+		if len(s) != 8 {
+			return 0, fmt.Errorf("%s is %d bytes, not a uint64", stringify([]byte(s)), len(s))
+		}
+		return binary.BigEndian.Uint64([]byte(s)), nil
+	},
+	"stringify": func(s string) string { return stringify([]byte(s)) },
+	// decode parses a value with a decoder of our own, like "msgpack"
+	"decode": func(name, s string) (interface{}, error) {
+		return parseValue(name, []byte(s))
+	},
+}
+
+/*
+decodeExecValue is JSON, or msgpack if it isn't text: almost anything
+starts like some msgpack value, so plain strings would turn into numbers
+*/
+func decodeExecValue(v []byte) interface{} {
+	if d, err := parseJSON(v); err == nil {
+		return d
+	}
+	if !utf8.Valid(v) {
+		if d, err := parseMsgpack(v); err == nil {
+			return d
+		}
+	}
+	return nil
+}
+
+func parseExecTemplate(text string) (*template.Template, error) {
+	return template.New("exec").Funcs(execFuncs).Parse(text)
+}
+
+/*
+execTemplate walks everything under 'path' (the whole database when it's
+empty) in one transaction, running 'tmpl' for each bucket and pair in
+order. Each one's output gets a line of its own, and output that's empty
+is skipped, so {{if}} can pick what to print
+*/
+func execTemplate(tx *bolt.Tx, path []string, tmpl *template.Template, out io.Writer) error {
+	var buf bytes.Buffer
+	var walk func(b *bolt.Bucket, bktPath []string) error
+	each := func(k, v []byte, b *bolt.Bucket, bktPath []string) error {
+		item := ExecItem{
+			Path:   formatQueryPath(appendPath(bktPath, string(k))),
+			Bucket: formatQueryPath(bktPath),
+			Key:    string(k),
+			Value:  string(v),
+			Depth:  len(bktPath),
+		}
+		var child *bolt.Bucket
+		if v == nil {
+			child = b.Bucket(k)
+			item.IsBucket = true
+			item.Sequence = child.Sequence()
+		} else {
+			item.Decoded = decodeExecValue(v)
+		}
+		buf.Reset()
+		if err := tmpl.Execute(&buf, item); err != nil {
+			return fmt.Errorf("%s: %s", item.Path, err)
+		}
+		if buf.Len() > 0 {
+			if !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+				buf.WriteByte('\n')
+			}
+			if _, err := out.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		if child != nil {
+			return walk(child, appendPath(bktPath, string(k)))
+		}
+		return nil
+	}
+	walk = func(b *bolt.Bucket, bktPath []string) error {
+		return b.ForEach(func(k, v []byte) error {
+			return each(k, v, b, bktPath)
+		})
+	}
+	if len(path) == 0 {
+		return walk(tx.Cursor().Bucket(), nil)
+	}
+	b := getBoltBucket(tx, path)
+	if b == nil {
+		return fmt.Errorf("No bucket %s", formatQueryPath(path))
+	}
+	return walk(b, path)
+}
+
+// runExec is the 'exec' subcommand
+func runExec(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("Usage: " + ProgramName + " exec <filename> <template> [bucket path]")
+	}
+	tmpl, err := parseExecTemplate(args[1])
+	if err != nil {
+		return err
+	}
+	var path []string
+	if len(args) == 3 {
+		if path, err = parseBucketPath(args[2]); err != nil {
+			return err
+		}
+	}
+	bdb, err := openForBackup(args[0])
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	out := bufio.NewWriter(os.Stdout)
+	err = bdb.View(func(tx *bolt.Tx) error {
+		return execTemplate(tx, path, tmpl, out)
+	})
+	if ferr := out.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
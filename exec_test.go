@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestExecTemplate(t *testing.T) {
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "exec.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	if err = bdb.Update(fillServeTestDB); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		tmpl, path, want string
+	}{
+		{`{{if .IsBucket}}{{.Path}} {{.Sequence}}{{end}}`, "", "a\\/b 0\na\\/b/keys 0\nnums 0\nusers 7\nusers/active 0\n"},
+		{`{{if not .IsBucket}}{{.Key}}={{with .Decoded}}{{.id}}{{end}}{{end}}`, "users/active", "u0=0\nu1=1\nu2=2\nu3=3\nu4=4\nx=\n"},
+		{`{{if eq .Depth 1}}{{.Bucket}} {{hex .Key}} {{base64 .Value}}{{end}}`, "users", "users 616374697665 \nusers 62696e AP8=\n"},
+		{"{{uint64 .Key}}: {{json .Value}}\n", "nums", "1000: \"thousand\"\n"},
+	} {
+		tmpl, err := parseExecTemplate(c.tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, _ := splitCommandPath(c.path)
+		var out bytes.Buffer
+		err = bdb.View(func(tx *bolt.Tx) error {
+			return execTemplate(tx, path, tmpl, &out)
+		})
+		if err != nil || out.String() != c.want {
+			t.Errorf("%s: got %q (%v), want %q", c.tmpl, out.String(), err, c.want)
+		}
+	}
+	tmpl, _ := parseExecTemplate(`{{uint64 .Key}}`)
+	err = bdb.View(func(tx *bolt.Tx) error {
+		return execTemplate(tx, []string{"users"}, tmpl, &bytes.Buffer{})
+	})
+	if err == nil {
+		t.Error("no error for a key that isn't a uint64")
+	}
+}
+
+func TestParseBucketPath(t *testing.T) {
+	for p, want := range map[string]string{
+		"users/active":  "users/active",
+		"/users/":       "users",
+		`a\/b/keys`:     `a\/b/keys`,
+		"":              "",
+		"/":             "",
+		"users//active": "",
+		"users/../nums": "",
+	} {
+		path, err := parseBucketPath(p)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%q: no error, got %v", p, path)
+			}
+		} else if err != nil || formatQueryPath(path) != want {
+			t.Errorf("%q: got %v (%v), want %s", p, path, err, want)
+		}
+	}
+}
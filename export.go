@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	termboxUtil "github.com/br0xen/termbox-util"
+	"github.com/nsf/termbox-go"
+)
+
+// Some terminals ignore OSC 52 copies bigger than this
+const clipboardLimit = 100000
+
+/*
+ExportFunc writes an export to 'w' and returns
+the message to show when it's done
+*/
+type ExportFunc func(w io.Writer) (string, error)
+
+// expandHome turns a leading ~ into the home directory, like a shell would
+func expandHome(fileName string) string {
+	if fileName != "~" && !strings.HasPrefix(fileName, "~/") {
+		return fileName
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fileName
+	}
+	return filepath.Join(home, fileName[1:])
+}
+
+/*
+exportTo runs 'export' into a file of its own, asking
+first if that would overwrite a file that's already there
+*/
+func (screen *BrowserScreen) exportTo(fileName string, export ExportFunc) error {
+	if fileName == "" {
+		return errors.New("No file name")
+	}
+	name := expandHome(fileName)
+	run := func() error {
+		msg, err := writeFileAtomic(name, export)
+		if err != nil {
+			return err
+		}
+		screen.setMessage(msg + " to file: " + fileName)
+		return nil
+	}
+	if _, err := os.Stat(name); err != nil {
+		return run()
+	}
+	w, h := termbox.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateConfirmModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Overwrite '%s'?", fileName), inpW-1, termboxUtil.AlignCenter))
+	mod.SetText(termboxUtil.AlignText("It's already there", inpW-1, termboxUtil.AlignCenter))
+	mod.Show()
+	screen.confirmModal = mod
+	screen.pendingExport = run
+	screen.mode = modeExportConfirm
+	return nil
+}
+
+/*
+writeFileAtomic runs 'export' into a temp file next to 'name' and only
+renames it over 'name' if it worked, so a failed export doesn't leave
+half a file or lose the one that was there. An overwritten file keeps
+its permissions
+*/
+func writeFileAtomic(name string, export ExportFunc) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return "", err
+	}
+	msg, err := export(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if fi, serr := os.Stat(name); serr == nil && err == nil {
+		err = os.Chmod(f.Name(), fi.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return msg, nil
+}
+
+func (screen *BrowserScreen) handleExportConfirmKeyEvent(event termbox.Event) int {
+	screen.confirmModal.HandleEvent(event)
+	if screen.confirmModal.IsDone() {
+		screen.mode = modeBrowse
+		if screen.confirmModal.IsAccepted() {
+			if err := screen.pendingExport(); err != nil {
+				screen.setMessage(err.Error())
+			}
+		} else {
+			screen.setMessage("Cancelled, nothing was written")
+		}
+		screen.pendingExport = nil
+		screen.confirmModal.Clear()
+	}
+	return BrowserScreenIndex
+}
+
+/*
+copyToClipboard sets the clipboard with the OSC 52 escape sequence,
+which the terminal handles, so it works over ssh too. tmux and screen
+need it wrapped up to pass it on
+*/
+func copyToClipboard(data []byte) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString(data) + "\a"
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;" + strings.Replace(seq, "\x1b", "\x1b\x1b", -1) + "\x1b\\"
+	} else if strings.HasPrefix(os.Getenv("TERM"), "screen") {
+		seq = "\x1bP" + seq + "\x1b\\"
+	}
+	// termbox draws on the tty, so the sequence goes there too
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		_, err = os.Stdout.WriteString(seq)
+		return err
+	}
+	defer tty.Close()
+	_, err = tty.WriteString(seq)
+	return err
+}
+
+// copyExport runs 'export' into the clipboard instead of a file
+func (screen *BrowserScreen) copyExport(export ExportFunc) error {
+	var buf bytes.Buffer
+	msg, err := export(&buf)
+	if err != nil {
+		return err
+	}
+	if err = copyToClipboard(buf.Bytes()); err != nil {
+		return err
+	}
+	msg += fmt.Sprintf(" to the clipboard (%d bytes)", buf.Len())
+	if buf.Len() > clipboardLimit {
+		msg += " (some terminals won't take that much)"
+	}
+	screen.setMessage(msg)
+	return nil
+}
+
+func valueExport(path []string) ExportFunc {
+	return func(w io.Writer) (string, error) {
+		return "Value exported", exportValue(path, w)
+	}
+}
+
+func jsonExport(path []string) ExportFunc {
+	return func(w io.Writer) (string, error) {
+		return "JSON exported", exportJSON(path, w)
+	}
+}
+
+func structuredExport(path []string, format string) ExportFunc {
+	return func(w io.Writer) (string, error) {
+		return strings.ToUpper(format) + " exported", viewDatabase(func(tx *bolt.Tx) error {
+			return exportStructured(tx, path, format, w)
+		})
+	}
+}
+
+/*
+runCopyCommand copies the current item to the clipboard as its value,
+JSON, YAML, TOML or CSV (which takes the same options as :export csv)
+*/
+func runCopyCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	format := "value"
+	if len(cmd.args) > 0 {
+		format = cmd.args[0]
+	}
+	b, p, err := screen.db.getGenericFromPath(screen.currentPath)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	var export ExportFunc
+	switch format {
+	case "value":
+		if p == nil {
+			return BrowserScreenIndex, errors.New("Only pairs have a value to copy")
+		}
+		export = valueExport(screen.currentPath)
+	case "json":
+		if b == nil && p == nil {
+			return BrowserScreenIndex, errors.New("Nothing to copy")
+		}
+		export = jsonExport(screen.currentPath)
+	case "yaml", "toml":
+		export = structuredExport(screen.currentPath, format)
+	case "csv", "tsv":
+		o, err := parseCSVOptions("", cmd.args)
+		if err != nil {
+			return BrowserScreenIndex, err
+		}
+		export = csvExport(screen.currentBucketPath(), o)
+	default:
+		return BrowserScreenIndex, errors.New("Usage: copy [value|json|yaml|toml|csv|tsv]")
+	}
+	return BrowserScreenIndex, screen.copyExport(export)
+}
+
+func completeCopy(screen *BrowserScreen, argIdx int, prefix string) []string {
+	if argIdx == 0 {
+		return filterPrefix([]string{"value", "json", "yaml", "toml", "csv", "tsv"}, prefix)
+	}
+	return completeCSVOptions(prefix)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.json")
+	if err := os.WriteFile(name, []byte("old"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed export leaves the old file alone and no temp file behind
+	_, err := writeFileAtomic(name, func(w io.Writer) (string, error) {
+		io.WriteString(w, "half")
+		return "", errors.New("can't export binary data")
+	})
+	if err == nil {
+		t.Fatal("no error from a failed export")
+	}
+	if b, _ := os.ReadFile(name); string(b) != "old" {
+		t.Errorf("the file is now %q", b)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files left, want 1", len(files))
+	}
+
+	msg, err := writeFileAtomic(name, func(w io.Writer) (string, error) {
+		_, err := io.WriteString(w, "new")
+		return "JSON exported", err
+	})
+	if err != nil || msg != "JSON exported" {
+		t.Fatalf("got %q, %v", msg, err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "new" {
+		t.Errorf("the file is %q, want new", b)
+	}
+	if fi, _ := os.Stat(name); fi.Mode().Perm() != 0640 {
+		t.Errorf("the file's mode is %s, want 0640", fi.Mode().Perm())
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	var err error
+	db, err = bolt.Open(filepath.Join(t.TempDir(), "json.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("b"))
+		if err != nil {
+			return err
+		}
+		if err = b.SetSequence(12); err != nil {
+			return err
+		}
+		if err = b.Put([]byte(`say "hi"`), []byte(`C:\dir`)); err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("empty"))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		path []string
+		want interface{}
+	}{
+		{[]string{"b"}, map[string]interface{}{
+			"$sequence": 12.0,
+			`say "hi"`:  `C:\dir`,
+			"empty":     map[string]interface{}{},
+		}},
+		{[]string{"b", `say "hi"`}, map[string]interface{}{`say "hi"`: `C:\dir`}},
+	} {
+		var buf bytes.Buffer
+		if err = exportJSON(c.path, &buf); err != nil {
+			t.Fatal(err)
+		}
+		var got interface{}
+		if err = json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Errorf("%v: %s isn't JSON: %s", c.path, buf.String(), err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %s", c.path, buf.String())
+		}
+	}
+}
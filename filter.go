@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+/*
+A filter hides the pairs in a bucket whose keys don't match it. Patterns
+with '*', '?' or '[' are globs, anything else matches keys containing it.
+Sub-buckets are always shown so you can still get to them
+*/
+
+func matchFilter(pattern, key string) bool {
+	shown := stringify([]byte(key))
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, err := path.Match(pattern, shown)
+		return ok && err == nil
+	}
+	return strings.Contains(shown, pattern)
+}
+
+func (screen *BrowserScreen) getFilter(bktPath []string) string {
+	return screen.filters[statePathKey(bktPath)]
+}
+
+func (screen *BrowserScreen) setFilter(bktPath []string, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("Bad filter %q: %s", pattern, err)
+	}
+	if screen.filters == nil {
+		screen.filters = make(map[string]string)
+	}
+	if pattern == "" {
+		delete(screen.filters, statePathKey(bktPath))
+	} else {
+		screen.filters[statePathKey(bktPath)] = pattern
+	}
+	screen.db.invalidateVisible()
+	return nil
+}
+
+// bucketFilter is what the visible index uses to hide pairs
+func (screen *BrowserScreen) bucketFilter(b *BoltBucket) string {
+	if len(screen.filters) == 0 {
+		return ""
+	}
+	return screen.getFilter(b.GetPath())
+}
+
+func runFilterCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	bktPath := screen.currentBucketPath()
+	if len(bktPath) == 0 {
+		return BrowserScreenIndex, fmt.Errorf("Not in a bucket")
+	}
+	pattern := ""
+	if len(cmd.rest) > 0 {
+		pattern = cmd.rest[0]
+	}
+	if err := screen.setFilter(bktPath, pattern); err != nil {
+		return BrowserScreenIndex, err
+	}
+	if pattern == "" {
+		screen.setMessage("Filter cleared for " + strings.Join(bktPath, "/"))
+	} else {
+		screen.setMessage(fmt.Sprintf("Filtering %s by %q", strings.Join(bktPath, "/"), pattern))
+	}
+	// The cursor may have been on something that's now hidden
+	if screen.db.findVisibleRow(screen.currentPath) < 0 {
+		screen.jumpToPath(bktPath)
+	}
+	return BrowserScreenIndex, nil
+}
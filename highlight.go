@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/nsf/termbox-go"
+)
+
+/*
+jsonColours picks a colour for each rune of 's', JSON as the decoders
+print it, from the theme's syntax_ colours. Keys are the strings
+followed by a ':'
+*/
+func jsonColours(s string, style Style) []termbox.Attribute {
+	rs := []rune(s)
+	fgs := make([]termbox.Attribute, len(rs))
+	fill := func(from, to int, fg termbox.Attribute) int {
+		for i := from; i < to; i++ {
+			fgs[i] = fg
+		}
+		return to
+	}
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '"':
+			end := i + 1
+			for ; end < len(rs) && rs[end] != '"'; end++ {
+				if rs[end] == '\\' {
+					end++
+				}
+			}
+			if end < len(rs) {
+				// The closing quote
+				end++
+			}
+			fg := style.syntaxStringFg
+			next := end
+			for next < len(rs) && unicode.IsSpace(rs[next]) {
+				next++
+			}
+			if next < len(rs) && rs[next] == ':' {
+				fg = style.syntaxKeyFg
+			}
+			i = fill(i, end, fg)
+		case r == '-' || unicode.IsDigit(r):
+			end := i + 1
+			for end < len(rs) && (unicode.IsDigit(rs[end]) || strings.ContainsRune(".eE+-", rs[end])) {
+				end++
+			}
+			i = fill(i, end, style.syntaxNumberFg)
+		case unicode.IsLetter(r):
+			// true, false and null
+			end := i + 1
+			for end < len(rs) && unicode.IsLetter(rs[end]) {
+				end++
+			}
+			i = fill(i, end, style.syntaxLiteralFg)
+		case strings.ContainsRune("{}[],:", r):
+			i = fill(i, i+1, style.syntaxPunctFg)
+		default:
+			i++
+		}
+	}
+	return fgs
+}
+
+// isJSONValue checks that the value pane is showing JSON, which gets coloured
+func isJSONValue(decName, val string) bool {
+	switch decName {
+	case "json", "msgpack":
+		return true
+	case defaultDecoderName:
+		// auto only pretty prints objects and arrays, anything else is stringified
+		return (strings.HasPrefix(val, "{") || strings.HasPrefix(val, "[")) && json.Valid([]byte(val))
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestJSONColours(t *testing.T) {
+	style := defaultStyle()
+	style.syntaxPunctFg = termbox.ColorYellow
+	s := "{\n  \"id\": -1.5e3,\n  \"ok\": true,\n  \"name\": \"a \\\"b\\\": c\"\n}"
+	fgs := jsonColours(s, style)
+	rs := []rune(s)
+	// The runs of each colour, leaving out what's uncoloured
+	var got []string
+	var cur []rune
+	var curFg termbox.Attribute
+	for i, r := range rs {
+		if (fgs[i] == 0 || fgs[i] != curFg) && len(cur) > 0 {
+			got = append(got, string(cur))
+			cur = nil
+		}
+		curFg = fgs[i]
+		if fgs[i] != 0 {
+			cur = append(cur, r)
+		}
+	}
+	got = append(got, string(cur))
+	want := []string{"{", `"id"`, ":", "-1.5e3", ",", `"ok"`, ":", "true", ",", `"name"`, ":", `"a \"b\": c"`, "}"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	if fgs[4] != style.syntaxKeyFg || fgs[len(rs)-4] != style.syntaxStringFg {
+		t.Error("keys and strings have the wrong colours")
+	}
+}
+
+func TestWrapColoured(t *testing.T) {
+	s := "Value: abcdef\nxy"
+	fgs := make([]termbox.Attribute, len(s))
+	for i := range fgs {
+		fgs[i] = termbox.Attribute(i + 1)
+	}
+	lines, lineFgs := wrapColoured(s, fgs, 10, 7)
+	if len(lines) != 3 || lines[0] != "Value: abc" || lines[1] != "       def" || lines[2] != "xy" {
+		t.Fatalf("got %q", lines)
+	}
+	if lineFgs[1][6] != 0 || lineFgs[1][7] != 11 || lineFgs[2][0] != 15 {
+		t.Errorf("got colours %v", lineFgs)
+	}
+	line, f := sliceColoured(lines[1], lineFgs[1], 8)
+	if line != "ef" || f[0] != 12 {
+		t.Errorf("sliced to %q %v", line, f)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/itchyny/gojq"
+	"github.com/nsf/termbox-go"
+)
+
+/*
+PairUpdate is a new value for a pair, with the value
+it had when we looked so we can tell if it's changed since
+*/
+type PairUpdate struct {
+	path []string
+	old  []byte
+	new  []byte
+}
+
+/*
+DiffLine is a line of the preview, kind is ' ' (the same),
+'-' (removed), '+' (added) or '#' (the path of the next pair)
+*/
+type DiffLine struct {
+	kind byte
+	text string
+}
+
+var errNotJSON = errors.New("not JSON")
+
+// How many unchanged lines are shown around each change
+const diffContext = 2
+
+/*
+applyJq runs the compiled jq program on a JSON value and returns the
+new value, or nil if it's the same. The program has to give exactly one result
+*/
+func applyJq(code *gojq.Code, v []byte) ([]byte, interface{}, interface{}, error) {
+	in, err := parseJSONNumbers(v)
+	if err != nil {
+		return nil, nil, nil, errNotJSON
+	}
+	iter := code.Run(in)
+	out, ok := iter.Next()
+	if !ok {
+		return nil, nil, nil, errors.New("jq gave no result")
+	}
+	if err, isErr := out.(error); isErr {
+		return nil, nil, nil, err
+	}
+	if _, more := iter.Next(); more {
+		return nil, nil, nil, errors.New("jq gave more than one result")
+	}
+	if reflect.DeepEqual(in, out) {
+		return nil, in, out, nil
+	}
+	// Keep multi-line values multi-line
+	newVal, err := marshalJq(out, bytes.Contains(v, []byte("\n")))
+	return newVal, in, out, err
+}
+
+/*
+parseJSONNumbers parses JSON keeping integers exact,
+as ints or big.Ints (which jq understands) instead of float64s
+*/
+func parseJSONNumbers(v []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.UseNumber()
+	var d interface{}
+	if err := dec.Decode(&d); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("more than one JSON value")
+	}
+	return fixJSONNumbers(d), nil
+}
+
+func fixJSONNumbers(d interface{}) interface{} {
+	switch t := d.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			t[k] = fixJSONNumbers(v)
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = fixJSONNumbers(t[i])
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return int(i)
+		}
+		if b, ok := new(big.Int).SetString(string(t), 10); ok {
+			return b
+		}
+		f, _ := t.Float64()
+		return f
+	}
+	return d
+}
+
+func marshalJq(v interface{}, indent bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+/*
+diffLines is a line diff of 'a' and 'b' (longest common subsequence),
+with only diffContext lines kept around each change
+*/
+func diffLines(a, b []string) []DiffLine {
+	var all []DiffLine
+	if len(a)*len(b) > 4000000 {
+		// Too big to line up, show it all as changed
+		for _, l := range a {
+			all = append(all, DiffLine{'-', l})
+		}
+		for _, l := range b {
+			all = append(all, DiffLine{'+', l})
+		}
+	} else {
+		// lcs[i][j] is the longest common run of a[i:] and b[j:]
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && a[i] == b[j]:
+				all = append(all, DiffLine{' ', a[i]})
+				i++
+				j++
+			case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+				all = append(all, DiffLine{'+', b[j]})
+				j++
+			default:
+				all = append(all, DiffLine{'-', a[i]})
+				i++
+			}
+		}
+	}
+	// Drop unchanged lines that aren't near a change
+	var ret []DiffLine
+	skipped := false
+	for i, l := range all {
+		near := l.kind != ' '
+		for d := 1; d <= diffContext && !near; d++ {
+			near = (i-d >= 0 && all[i-d].kind != ' ') || (i+d < len(all) && all[i+d].kind != ' ')
+		}
+		if near {
+			if skipped && len(ret) > 0 {
+				ret = append(ret, DiffLine{' ', "..."})
+			}
+			ret = append(ret, l)
+			skipped = false
+		} else {
+			skipped = true
+		}
+	}
+	return ret
+}
+
+type jqTarget struct {
+	path  []string
+	value []byte
+}
+
+/*
+jqTargets reads the values the jq command works on: the current
+pair, every pair directly in the current bucket, or the query results
+*/
+func (screen *BrowserScreen) jqTargets(scope string) ([]jqTarget, error) {
+	var paths [][]string
+	bucketPath := []string(nil)
+	switch scope {
+	case "value":
+		if _, p, err := screen.db.getGenericFromPath(screen.currentPath); err != nil || p == nil {
+			return nil, errors.New("Not on a pair")
+		}
+		paths = append(paths, screen.currentPath)
+	case "bucket":
+		bucketPath = screen.currentBucketPath()
+		if len(bucketPath) == 0 {
+			return nil, errors.New("Not in a bucket")
+		}
+	case "results":
+		if len(screen.results) == 0 {
+			return nil, errors.New("No query results, run :query first")
+		}
+		for _, r := range screen.results {
+			paths = append(paths, r.path)
+		}
+	}
+	var targets []jqTarget
+	err := viewDatabase(func(tx *bolt.Tx) error {
+		if bucketPath != nil {
+			b := getBoltBucket(tx, bucketPath)
+			if b == nil {
+				return errors.New("No such bucket " + strings.Join(bucketPath, "/"))
+			}
+			return b.ForEach(func(k, v []byte) error {
+				if v != nil {
+					targets = append(targets, jqTarget{appendPath(bucketPath, string(k)), append([]byte{}, v...)})
+				}
+				return nil
+			})
+		}
+		for _, p := range paths {
+			if b := getBoltBucket(tx, p[:len(p)-1]); b != nil {
+				if v := b.Get([]byte(p[len(p)-1])); v != nil {
+					targets = append(targets, jqTarget{p, append([]byte{}, v...)})
+				}
+			}
+		}
+		return nil
+	})
+	return targets, err
+}
+
+/*
+runJqCommand works out what a jq program would change and shows
+a preview, nothing is written until that is confirmed
+*/
+func runJqCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if AppArgs.ReadOnly {
+		return BrowserScreenIndex, errors.New("DB is in Read-Only Mode")
+	}
+	if len(cmd.args) == 0 {
+		return BrowserScreenIndex, errors.New("Usage: jq [value|bucket|results] <expression>")
+	}
+	scope, expr := "", cmd.rest[0]
+	switch cmd.args[0] {
+	case "value", "bucket", "results":
+		if len(cmd.rest) < 2 {
+			return BrowserScreenIndex, errors.New("Usage: jq [value|bucket|results] <expression>")
+		}
+		scope, expr = cmd.args[0], cmd.rest[1]
+	default:
+		scope = "bucket"
+		if _, p, err := screen.db.getGenericFromPath(screen.currentPath); err == nil && p != nil {
+			scope = "value"
+		}
+	}
+	q, err := gojq.Parse(expr)
+	if err != nil {
+		return BrowserScreenIndex, fmt.Errorf("jq: %s", err)
+	}
+	code, err := gojq.Compile(q)
+	if err != nil {
+		return BrowserScreenIndex, fmt.Errorf("jq: %s", err)
+	}
+	targets, err := screen.jqTargets(scope)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+
+	var updates []PairUpdate
+	var preview []DiffLine
+	notJSON, failed := 0, 0
+	var firstErr error
+	for _, t := range targets {
+		newVal, in, out, err := applyJq(code, t.value)
+		if err != nil {
+			if err == errNotJSON {
+				notJSON++
+			} else {
+				failed++
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %s", formatQueryPath(t.path), err)
+				}
+			}
+			continue
+		}
+		if newVal == nil {
+			continue
+		}
+		updates = append(updates, PairUpdate{path: t.path, old: t.value, new: newVal})
+		before, _ := marshalJq(in, true)
+		after, _ := marshalJq(out, true)
+		preview = append(preview, DiffLine{'#', formatQueryPath(t.path)})
+		preview = append(preview, diffLines(strings.Split(string(before), "\n"), strings.Split(string(after), "\n"))...)
+	}
+	if firstErr != nil && len(updates) == 0 {
+		return BrowserScreenIndex, firstErr
+	}
+	summary := fmt.Sprintf("jq changes %d of %d values", len(updates), len(targets))
+	if notJSON > 0 {
+		summary += fmt.Sprintf(", %d aren't JSON", notJSON)
+	}
+	if failed > 0 {
+		summary += fmt.Sprintf(", %d failed (%s)", failed, firstErr)
+	}
+	if len(updates) == 0 {
+		screen.setMessage(summary)
+		return BrowserScreenIndex, nil
+	}
+	screen.showPreview(preview, summary+" - y writes them, n cancels", func() (string, error) {
+		if err := writePairUpdates(updates); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Updated %d values", len(updates)), nil
+	}, nil)
+	return BrowserScreenIndex, nil
+}
+
+/*
+writePairUpdates writes all of the updates in one transaction. If any
+pair has changed since we read it, nothing is written
+*/
+func writePairUpdates(updates []PairUpdate) error {
+	if AppArgs.ReadOnly {
+		return errors.New("DB is in Read-Only Mode")
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, u := range updates {
+			b := getBoltBucket(tx, u.path[:len(u.path)-1])
+			if b == nil || !bytes.Equal(b.Get([]byte(u.path[len(u.path)-1])), u.old) {
+				return fmt.Errorf("%s changed since the preview, nothing was written", formatQueryPath(u.path))
+			}
+			if err := putPairValue(tx, u.path, u.new); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func completeJqScope(screen *BrowserScreen, argIdx int, prefix string) []string {
+	if argIdx != 0 {
+		return nil
+	}
+	return filterPrefix([]string{"value", "bucket", "results"}, prefix)
+}
+
+func (screen *BrowserScreen) handlePreviewKeyEvent(event termbox.Event) int {
+	switch event.Ch {
+	case 'y':
+		screen.keymap.reset()
+		screen.mode = modeBrowse
+		if screen.previewCommit == nil {
+			// Nothing to write, it was just to look at
+			screen.closePreview()
+			screen.clearMessage()
+			return BrowserScreenIndex
+		}
+		if msg, err := screen.previewCommit(); err != nil {
+			screen.setMessage(err.Error())
+		} else {
+			screen.setMessage(msg)
+		}
+		// Committing is instead of cancelling
+		screen.previewCancel = nil
+		screen.closePreview()
+		screen.refreshDatabase()
+		return BrowserScreenIndex
+	case 'n':
+		screen.keymap.reset()
+		screen.cancelPreview()
+		return BrowserScreenIndex
+	}
+	for _, action := range screen.keymap.handleKey(keyFromEvent(event)) {
+		switch action {
+		case actionDown, actionPaneDown:
+			screen.scrollPreview(1)
+		case actionUp, actionPaneUp:
+			screen.scrollPreview(-1)
+		case actionPageDown:
+			screen.scrollPreview(screen.body.h / 2)
+		case actionPageUp:
+			screen.scrollPreview(-screen.body.h / 2)
+		case actionTop:
+			screen.scrollPreview(-len(screen.preview))
+		case actionBottom:
+			screen.scrollPreview(len(screen.preview))
+		case actionClose, actionQuit:
+			screen.cancelPreview()
+		}
+	}
+	return BrowserScreenIndex
+}
+
+/*
+showPreview shows 'lines' in modePreview. 'y' calls commit to write the
+changes, which returns what to say about it, anything else that closes the
+preview calls cancel. Either can be nil
+*/
+func (screen *BrowserScreen) showPreview(lines []DiffLine, msg string, commit func() (string, error), cancel func()) {
+	screen.preview = lines
+	screen.previewOffset = 0
+	screen.previewCommit, screen.previewCancel = commit, cancel
+	screen.mode = modePreview
+	screen.setMessageWithTimeout(msg, -1)
+}
+
+func (screen *BrowserScreen) cancelPreview() {
+	screen.mode = modeBrowse
+	if screen.previewCommit != nil {
+		screen.setMessage("Cancelled, nothing was written")
+	} else {
+		screen.clearMessage()
+	}
+	screen.closePreview()
+}
+
+func (screen *BrowserScreen) closePreview() {
+	if screen.previewCancel != nil {
+		screen.previewCancel()
+	}
+	screen.preview, screen.previewCommit, screen.previewCancel = nil, nil, nil
+}
+
+func (screen *BrowserScreen) scrollPreview(by int) {
+	screen.previewOffset += by
+	if max := len(screen.preview) - screen.body.h; screen.previewOffset > max {
+		screen.previewOffset = max
+	}
+	if screen.previewOffset < 0 {
+		screen.previewOffset = 0
+	}
+}
+
+// drawPreview shows the diff over both panes
+func (screen *BrowserScreen) drawPreview(style Style) {
+	area := screen.body
+	for i := 0; i < area.h && screen.previewOffset+i < len(screen.preview); i++ {
+		l := screen.preview[screen.previewOffset+i]
+		fg, bg := style.defaultFg, style.defaultBg
+		text := string(l.kind) + " " + l.text
+		switch l.kind {
+		case '-':
+			fg = style.diffRemoveFg
+		case '+':
+			fg = style.diffAddFg
+		case '#':
+			fg, bg, text = style.titleFg, style.titleBg, l.text
+		}
+		drawClipped(text, area.x, area.y+i, area, fg, bg)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+/*
+Action is the name of something the user can do from the browser,
+these are the names used for the [keys] section of the config file
+*/
+type Action string
+
+const (
+	actionNone               Action = ""
+	actionHelp               Action = "help"
+	actionQuit               Action = "quit"
+	actionTop                Action = "top"
+	actionBottom             Action = "bottom"
+	actionRefresh            Action = "refresh"
+	actionPageDown           Action = "page-down"
+	actionPageUp             Action = "page-up"
+	actionDown               Action = "down"
+	actionUp                 Action = "up"
+	actionPaneDown           Action = "pane-down"
+	actionPaneUp             Action = "pane-up"
+	actionPaneLeft           Action = "pane-left"
+	actionPaneRight          Action = "pane-right"
+	actionWrapTree           Action = "wrap-tree"
+	actionCycleSort          Action = "cycle-sort"
+	actionSetBookmark        Action = "set-bookmark"
+	actionJumpBookmark       Action = "jump-bookmark"
+	actionGoto               Action = "goto"
+	actionSeek               Action = "seek"
+	actionWrapDetails        Action = "wrap-details"
+	actionOpen               Action = "open"
+	actionClose              Action = "close"
+	actionInsertPair         Action = "insert-pair"
+	actionInsertPairAtParent Action = "insert-pair-at-parent"
+	actionInsertBucket       Action = "insert-bucket"
+	actionInsertBucketParent Action = "insert-bucket-at-parent"
+	actionEdit               Action = "edit"
+	actionRename             Action = "rename"
+	actionSetSequence        Action = "set-sequence"
+	actionNextSequence       Action = "next-sequence"
+	actionDelete             Action = "delete"
+	actionExportValue        Action = "export-value"
+	actionExportJSON         Action = "export-json"
+	actionExportCSV          Action = "export-csv"
+	actionCopyValue          Action = "copy-value"
+	actionCopyJSON           Action = "copy-json"
+	actionImport             Action = "import"
+	actionSnapshot           Action = "snapshot"
+	actionCommand            Action = "command"
+	actionZoomTree           Action = "zoom-tree"
+	actionZoomDetails        Action = "zoom-details"
+	actionSplitGrow          Action = "split-grow"
+	actionSplitShrink        Action = "split-shrink"
+	actionCycleLayout        Action = "cycle-layout"
+)
+
+/*
+ActionInfo describes an action for the help screen.
+group 0 actions are for moving around, group 1 for changing things
+*/
+type ActionInfo struct {
+	action      Action
+	description string
+	group       int
+}
+
+// actions lists every action, in the order they're shown on the about screen
+var actions = []ActionInfo{
+	{actionClose, "close parent", 0},
+	{actionDown, "down", 0},
+	{actionUp, "up", 0},
+	{actionOpen, "open item", 0},
+	{actionTop, "goto top", 0},
+	{actionBottom, "goto bottom", 0},
+	{actionPageDown, "jump down", 0},
+	{actionPageUp, "jump up", 0},
+	{actionPaneDown, "scroll right pane down", 0},
+	{actionPaneUp, "scroll right pane up", 0},
+	{actionPaneLeft, "scroll right pane left", 0},
+	{actionPaneRight, "scroll right pane right", 0},
+	{actionWrapTree, "wrap long items in tree", 0},
+	{actionWrapDetails, "wrap long values", 0},
+	{actionCycleSort, "change bucket sort order", 0},
+	{actionSetBookmark, "bookmark (then a letter)", 0},
+	{actionJumpBookmark, "go to bookmark (then a letter)", 0},
+	{actionGoto, "go to path", 0},
+	{actionSeek, "seek to first key >= (:seek)", 0},
+	{actionRefresh, "reload the db", 0},
+	{actionZoomTree, "zoom tree pane", 0},
+	{actionZoomDetails, "zoom details pane", 0},
+	{actionSplitGrow, "grow tree pane", 0},
+	{actionSplitShrink, "shrink tree pane", 0},
+	{actionCycleLayout, "switch layout", 0},
+
+	{actionInsertPair, "create pair", 1},
+	{actionInsertPairAtParent, "create pair at parent", 1},
+	{actionInsertBucket, "create bucket", 1},
+	{actionInsertBucketParent, "create bucket at parent", 1},
+	{actionEdit, "edit value of pair", 1},
+	{actionRename, "rename pair/bucket", 1},
+	{actionSetSequence, "set bucket sequence", 1},
+	{actionNextSequence, "next bucket sequence", 1},
+	{actionDelete, "delete item", 1},
+	{actionExportValue, "export as string to file", 1},
+	{actionExportJSON, "export as json/yaml/toml to file", 1},
+	{actionExportCSV, "export bucket as csv/tsv", 1},
+	{actionCopyValue, "copy value to clipboard", 1},
+	{actionCopyJSON, "copy as json to clipboard", 1},
+	{actionImport, "import csv/tsv/yaml/toml into bucket", 1},
+	{actionSnapshot, "snapshot db to backup file", 1},
+	{actionCommand, "command line (:help)", 1},
+	{actionHelp, "this screen", 1},
+	{actionQuit, "quit program", 1},
+}
+
+func getActionInfo(a Action) *ActionInfo {
+	for i := range actions {
+		if actions[i].action == a {
+			return &actions[i]
+		}
+	}
+	return nil
+}
+
+// defaultBindings are the keys bound before the config file is applied
+var defaultBindings = [][2]string{
+	{"?", "help"},
+	{"q", "quit"},
+	{"esc", "quit"},
+	{"ctrl+c", "quit"},
+	{"g", "top"},
+	{"G", "bottom"},
+	{"ctrl+r", "refresh"},
+	{"ctrl+f", "page-down"},
+	{"ctrl+b", "page-up"},
+	{"j", "down"},
+	{"down", "down"},
+	{"k", "up"},
+	{"up", "up"},
+	{"J", "pane-down"},
+	{"K", "pane-up"},
+	{"H", "pane-left"},
+	{"L", "pane-right"},
+	{"w", "wrap-tree"},
+	{"W", "wrap-details"},
+	{"o", "cycle-sort"},
+	{"m", "set-bookmark"},
+	{"'", "jump-bookmark"},
+	{"ctrl+g", "goto"},
+	{"/", "seek"},
+	{"l", "open"},
+	{"right", "open"},
+	{"enter", "open"},
+	{"h", "close"},
+	{"left", "close"},
+	{"p", "insert-pair"},
+	{"P", "insert-pair-at-parent"},
+	{"b", "insert-bucket"},
+	{"B", "insert-bucket-at-parent"},
+	{"e", "edit"},
+	{"r", "rename"},
+	{"s", "set-sequence"},
+	{"n", "next-sequence"},
+	{"D", "delete"},
+	{"x", "export-value"},
+	{"X", "export-json"},
+	{"c", "export-csv"},
+	{"y", "copy-value"},
+	{"Y", "copy-json"},
+	{"C", "import"},
+	{"S", "snapshot"},
+	{":", "command"},
+	{"z", "zoom-tree"},
+	{"Z", "zoom-details"},
+	{">", "split-grow"},
+	{"<", "split-shrink"},
+	{"V", "cycle-layout"},
+}
+
+/*
+Key is a single key press, ch is set for printable characters
+and key for everything else
+*/
+type Key struct {
+	key termbox.Key
+	ch  rune
+	alt bool
+}
+
+func keyFromEvent(event termbox.Event) Key {
+	k := Key{ch: event.Ch, alt: event.Mod&termbox.ModAlt != 0}
+	if event.Ch == 0 {
+		k.key = event.Key
+	}
+	return k
+}
+
+var namedKeys = map[string]termbox.Key{
+	"esc":       termbox.KeyEsc,
+	"enter":     termbox.KeyEnter,
+	"tab":       termbox.KeyTab,
+	"space":     termbox.KeySpace,
+	"backspace": termbox.KeyBackspace2,
+	"delete":    termbox.KeyDelete,
+	"insert":    termbox.KeyInsert,
+	"home":      termbox.KeyHome,
+	"end":       termbox.KeyEnd,
+	"pgup":      termbox.KeyPgup,
+	"pgdn":      termbox.KeyPgdn,
+	"up":        termbox.KeyArrowUp,
+	"down":      termbox.KeyArrowDown,
+	"left":      termbox.KeyArrowLeft,
+	"right":     termbox.KeyArrowRight,
+	"f1":        termbox.KeyF1,
+	"f2":        termbox.KeyF2,
+	"f3":        termbox.KeyF3,
+	"f4":        termbox.KeyF4,
+	"f5":        termbox.KeyF5,
+	"f6":        termbox.KeyF6,
+	"f7":        termbox.KeyF7,
+	"f8":        termbox.KeyF8,
+	"f9":        termbox.KeyF9,
+	"f10":       termbox.KeyF10,
+	"f11":       termbox.KeyF11,
+	"f12":       termbox.KeyF12,
+}
+
+// How named keys are shown on the about screen
+var keyDisplayNames = map[termbox.Key]string{
+	termbox.KeyArrowUp:    "↑",
+	termbox.KeyArrowDown:  "↓",
+	termbox.KeyArrowLeft:  "←",
+	termbox.KeyArrowRight: "→",
+}
+
+/*
+parseKeySequence parses a binding from the config file. Keys in a
+sequence are separated by spaces ("g g", "ctrl+w j"), a run of plain
+characters is also a sequence ("gg", "dd"), and a key can be prefixed
+with "ctrl+" or "alt+"
+*/
+func parseKeySequence(s string) ([]Key, error) {
+	var seq []Key
+	for _, tok := range strings.Fields(s) {
+		keys, err := parseKeyToken(tok)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, keys...)
+	}
+	if len(seq) == 0 {
+		return nil, fmt.Errorf("Empty key binding")
+	}
+	return seq, nil
+}
+
+func parseKeyToken(tok string) ([]Key, error) {
+	lower := strings.ToLower(tok)
+	if strings.HasPrefix(lower, "alt+") && len(tok) > 4 {
+		k, err := parseKeyToken(tok[4:])
+		if err != nil || len(k) != 1 {
+			return nil, fmt.Errorf("Invalid key %q", tok)
+		}
+		k[0].alt = true
+		return k, nil
+	}
+	if strings.HasPrefix(lower, "ctrl+") && len(tok) > 5 {
+		rest := lower[5:]
+		if len(rest) == 1 && rest[0] >= 'a' && rest[0] <= 'z' {
+			return []Key{{key: termbox.KeyCtrlA + termbox.Key(rest[0]-'a')}}, nil
+		}
+		if rest == "space" {
+			return []Key{{key: termbox.KeyCtrlSpace}}, nil
+		}
+		return nil, fmt.Errorf("Invalid key %q", tok)
+	}
+	if k, ok := namedKeys[lower]; ok {
+		return []Key{{key: k}}, nil
+	}
+	var keys []Key
+	for _, r := range tok {
+		keys = append(keys, Key{ch: r})
+	}
+	return keys, nil
+}
+
+func (k Key) String() string {
+	var s string
+	if k.ch != 0 {
+		s = string(k.ch)
+	} else if n, ok := keyDisplayNames[k.key]; ok {
+		s = n
+	} else if k.key >= termbox.KeyCtrlA && k.key <= termbox.KeyCtrlZ && k.key != termbox.KeyTab && k.key != termbox.KeyEnter {
+		s = "ctrl+" + string(rune('a'+k.key-termbox.KeyCtrlA))
+	} else {
+		for name, nk := range namedKeys {
+			if nk == k.key {
+				s = name
+				break
+			}
+		}
+	}
+	if k.alt {
+		s = "alt+" + s
+	}
+	return s
+}
+
+func keySequenceString(seq []Key) string {
+	var parts []string
+	for _, k := range seq {
+		parts = append(parts, k.String())
+	}
+	return strings.Join(parts, "")
+}
+
+/*
+Binding associates a key sequence to an action
+*/
+type Binding struct {
+	keys   []Key
+	action Action
+}
+
+/*
+Keymap holds the active bindings, and the keys typed so far
+for a sequence that hasn't finished yet
+*/
+type Keymap struct {
+	bindings []Binding
+	pending  []Key
+	// The action for 'pending' if it also starts a longer binding
+	pendingAction Action
+}
+
+func hasKeyPrefix(seq, prefix []Key) bool {
+	if len(prefix) > len(seq) {
+		return false
+	}
+	for i := range prefix {
+		if seq[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+bind binds keys to action, replacing anything else bound to exactly
+those keys. Binding to actionNone just removes the binding
+*/
+func (km *Keymap) bind(keys []Key, action Action) {
+	for i := range km.bindings {
+		if len(km.bindings[i].keys) == len(keys) && hasKeyPrefix(km.bindings[i].keys, keys) {
+			km.bindings = append(km.bindings[:i], km.bindings[i+1:]...)
+			break
+		}
+	}
+	if action != actionNone {
+		km.bindings = append(km.bindings, Binding{keys: keys, action: action})
+	}
+}
+
+/*
+loadKeymap builds the default keymap and then applies the
+[keys] overrides from the config file
+*/
+func loadKeymap(overrides map[string]string) (*Keymap, error) {
+	km := new(Keymap)
+	for _, b := range defaultBindings {
+		keys, err := parseKeySequence(b[0])
+		if err != nil {
+			return nil, err
+		}
+		km.bind(keys, Action(b[1]))
+	}
+	// Apply overrides in a stable order
+	var keyStrings []string
+	for k := range overrides {
+		keyStrings = append(keyStrings, k)
+	}
+	sort.Strings(keyStrings)
+	for _, k := range keyStrings {
+		keys, err := parseKeySequence(k)
+		if err != nil {
+			return nil, err
+		}
+		action := Action(overrides[k])
+		if action == "none" {
+			action = actionNone
+		}
+		if action != actionNone && getActionInfo(action) == nil {
+			return nil, fmt.Errorf("Unknown action %q bound to %q", overrides[k], k)
+		}
+		km.bind(keys, action)
+	}
+	return km, nil
+}
+
+/*
+handleKey feeds one key press into the keymap. It returns the
+actions that should run now, which is empty while we're in the
+middle of a sequence
+*/
+func (km *Keymap) handleKey(k Key) []Action {
+	km.pending = append(km.pending, k)
+	var exact Action
+	longer := false
+	for _, b := range km.bindings {
+		if !hasKeyPrefix(b.keys, km.pending) {
+			continue
+		}
+		if len(b.keys) == len(km.pending) {
+			exact = b.action
+		} else {
+			longer = true
+		}
+	}
+	if longer {
+		// Wait for the rest of the sequence
+		km.pendingAction = exact
+		return nil
+	}
+	if exact != actionNone {
+		km.reset()
+		return []Action{exact}
+	}
+	// This key broke the sequence; finish whatever the keys
+	// before it were bound to, then try it on its own
+	var ret []Action
+	if km.pendingAction != actionNone {
+		ret = append(ret, km.pendingAction)
+	}
+	hadPending := len(km.pending) > 1
+	km.reset()
+	if hadPending {
+		ret = append(ret, km.handleKey(k)...)
+	}
+	return ret
+}
+
+func (km *Keymap) reset() {
+	km.pending = km.pending[:0]
+	km.pendingAction = actionNone
+}
+
+// usesAlt is whether any binding needs termbox's alt input mode
+func (km *Keymap) usesAlt() bool {
+	for _, b := range km.bindings {
+		for _, k := range b.keys {
+			if k.alt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keysForAction lists the key sequences bound to 'a', for the help screen
+func (km *Keymap) keysForAction(a Action) []string {
+	var ret []string
+	for _, b := range km.bindings {
+		if b.action == a {
+			ret = append(ret, keySequenceString(b.keys))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+/*
+Rect is an area of the screen
+*/
+type Rect struct {
+	x, y int
+	w, h int
+}
+
+func (r Rect) contains(x, y int) bool {
+	return x >= r.x && x < r.x+r.w && y >= r.y && y < r.y+r.h
+}
+
+func (r Rect) empty() bool {
+	return r.w <= 0 || r.h <= 0
+}
+
+/*
+LayoutMode is how the tree and the details pane share the screen
+*/
+type LayoutMode string
+
+const (
+	// layoutAuto is side by side on wide screens, stacked otherwise
+	layoutAuto LayoutMode = "auto"
+	// layoutSide puts the tree on the left, details on the right
+	layoutSide LayoutMode = "side"
+	// layoutStacked puts the tree on top, details below
+	layoutStacked LayoutMode = "stacked"
+)
+
+var layoutModes = []LayoutMode{layoutAuto, layoutSide, layoutStacked}
+
+/*
+ZoomMode is which pane (if any) is taking up the whole screen
+*/
+type ZoomMode int
+
+const (
+	zoomNone ZoomMode = iota
+	zoomLeft
+	zoomRight
+)
+
+const (
+	defaultSplitRatio = 0.5
+	// Below this width 'auto' stacks the panes
+	defaultSideBySideWidth = 80
+	// Don't let a split make either pane smaller than this
+	minPaneSize = 3
+	// How much the split keys move the divider
+	splitStep = 0.05
+)
+
+/*
+LayoutConfig is the [layout] section of the config file
+*/
+type LayoutConfig struct {
+	Mode  string  `toml:"mode"`
+	Split float64 `toml:"split"`
+	// The narrowest screen 'auto' puts the panes side by side on
+	SideBySideWidth int `toml:"side_by_side_width"`
+}
+
+func layoutModeNames() []string {
+	var names []string
+	for _, m := range layoutModes {
+		names = append(names, string(m))
+	}
+	return names
+}
+
+func parseLayoutMode(s string) (LayoutMode, error) {
+	for _, m := range layoutModes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return layoutAuto, fmt.Errorf("Unknown layout %q (auto, side or stacked)", s)
+}
+
+func parseSplitRatio(s string) (float64, error) {
+	ratio, err := strconv.ParseFloat(s, 64)
+	if err != nil || ratio <= 0 || ratio >= 1 {
+		return 0, errors.New("Split must be between 0 and 1")
+	}
+	return ratio, nil
+}
+
+// currentLayout resolves 'auto' for the current screen size
+func (screen *BrowserScreen) currentLayout() LayoutMode {
+	if screen.layout == layoutSide || screen.layout == layoutStacked {
+		return screen.layout
+	}
+	w, _ := termbox.Size()
+	minW := AppConfig.Layout.SideBySideWidth
+	if minW <= 0 {
+		minW = defaultSideBySideWidth
+	}
+	if w > minW {
+		return layoutSide
+	}
+	return layoutStacked
+}
+
+/*
+performLayout works out where the panes go, the draw functions just use
+screen.leftPane and screen.rightPane. Row 0 is the header, row 1 is the
+'=' line and the last row is the footer
+*/
+func (screen *BrowserScreen) performLayout() {
+	w, h := termbox.Size()
+	if screen.splitRatio <= 0 || screen.splitRatio >= 1 {
+		screen.splitRatio = defaultSplitRatio
+	}
+	body := Rect{x: 0, y: 2, w: w, h: h - 3}
+	screen.body = body
+	screen.divider = Rect{}
+	screen.leftPane, screen.rightPane = body, Rect{}
+	switch {
+	case screen.zoom == zoomLeft:
+	case screen.zoom == zoomRight:
+		screen.leftPane, screen.rightPane = Rect{}, body
+	case screen.currentLayout() == layoutSide:
+		divX := screen.splitPosition(body.w)
+		screen.leftPane = Rect{x: 0, y: body.y, w: divX, h: body.h}
+		screen.divider = Rect{x: divX, y: body.y - 1, w: 1, h: body.h + 1}
+		screen.rightPane = Rect{x: divX + 1, y: body.y, w: body.w - divX - 1, h: body.h}
+	default:
+		if body.h < minPaneSize*2+1 {
+			// Not enough room to stack them, just show the tree
+			break
+		}
+		divY := screen.splitPosition(body.h)
+		screen.leftPane = Rect{x: 0, y: body.y, w: body.w, h: divY}
+		screen.divider = Rect{x: 0, y: body.y + divY, w: body.w, h: 1}
+		screen.rightPane = Rect{x: 0, y: body.y + divY + 1, w: body.w, h: body.h - divY - 1}
+	}
+	screen.viewPort.firstRow = screen.leftPane.y
+	screen.viewPort.numberOfRows = screen.leftPane.h
+}
+
+// splitPosition is how much of 'size' the first pane gets
+func (screen *BrowserScreen) splitPosition(size int) int {
+	pos := int(float64(size) * screen.splitRatio)
+	if pos < minPaneSize {
+		pos = minPaneSize
+	}
+	if pos > size-minPaneSize-1 {
+		pos = size - minPaneSize - 1
+	}
+	return pos
+}
+
+// moveSplit grows (or with a negative 'by', shrinks) the first pane
+func (screen *BrowserScreen) moveSplit(by float64) {
+	ratio := screen.splitRatio + by
+	if ratio >= 0.1 && ratio <= 0.9 {
+		screen.splitRatio = ratio
+	}
+}
+
+// dragDivider moves the divider to where the mouse is
+func (screen *BrowserScreen) dragDivider(x, y int) {
+	w, h := termbox.Size()
+	var ratio float64
+	if screen.divider.h == 1 {
+		// Stacked
+		ratio = float64(y-2) / float64(h-3)
+	} else {
+		ratio = float64(x) / float64(w)
+	}
+	if ratio >= 0.1 && ratio <= 0.9 {
+		screen.splitRatio = ratio
+	}
+}
+
+func (screen *BrowserScreen) toggleZoom(zoom ZoomMode) {
+	if screen.zoom == zoom {
+		screen.zoom = zoomNone
+	} else {
+		screen.zoom = zoom
+	}
+}
+
+func (screen *BrowserScreen) cycleLayout() {
+	for i, m := range layoutModes {
+		if m == screen.layout {
+			screen.layout = layoutModes[(i+1)%len(layoutModes)]
+			return
+		}
+	}
+	screen.layout = layoutSide
+}
+
+/*
+drawClipped draws 's' at x, y but only the part of it that is inside 'r'
+*/
+func drawClipped(s string, x, y int, r Rect, fg, bg termbox.Attribute) {
+	drawClippedColoured(s, nil, x, y, r, fg, bg)
+}
+
+// drawClippedColoured is drawClipped with a colour for each rune, 0 is 'fg'
+func drawClippedColoured(s string, fgs []termbox.Attribute, x, y int, r Rect, fg, bg termbox.Attribute) {
+	if y < r.y || y >= r.y+r.h {
+		return
+	}
+	i := 0
+	for _, ch := range s {
+		chFg := fg
+		if i < len(fgs) && fgs[i] != 0 {
+			chFg = fgs[i]
+		}
+		i++
+		chW := runewidth.RuneWidth(ch)
+		if x+chW > r.x+r.w {
+			break
+		}
+		if x >= r.x {
+			termbox.SetCell(x, y, ch, chFg, bg)
+		}
+		// Wide characters take up the next cell too
+		x += chW
+	}
+}
@@ -1,4 +1,4 @@
-// +build !windows
+//go:build !windows
 
 package main
 
@@ -9,8 +9,8 @@ import (
 	"github.com/nsf/termbox-go"
 )
 
-func mainLoop(memBolt *BoltDB, style Style) {
-	screens := defaultScreensForData(memBolt)
+func mainLoop(memBolt *BoltDB, style Style, keymap *Keymap) {
+	screens := defaultScreensForData(memBolt, style, keymap)
 	displayScreen := screens[BrowserScreenIndex]
 	layoutAndDrawScreen(displayScreen, style)
 	for {
@@ -30,8 +30,21 @@ func mainLoop(memBolt *BoltDB, style Style) {
 				break
 			}
 		}
+		if event.Type == termbox.EventMouse {
+			newScreenIndex := displayScreen.handleMouseEvent(event)
+			if newScreenIndex < len(screens) {
+				displayScreen = screens[newScreenIndex]
+				layoutAndDrawScreen(displayScreen, style)
+			} else {
+				break
+			}
+		}
 		if event.Type == termbox.EventResize {
 			layoutAndDrawScreen(displayScreen, style)
 		}
 	}
+	if browser, ok := screens[BrowserScreenIndex].(*BrowserScreen); ok {
+		// Nowhere to tell anyone if this fails, the terminal is going away
+		browser.saveSession()
+	}
 }
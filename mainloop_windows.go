@@ -1,4 +1,5 @@
-// +build windows
+//go:build windows
+
 package main
 
 // Windows doesn't support process backgrounding like *nix.
@@ -6,8 +7,8 @@ package main
 
 import "github.com/nsf/termbox-go"
 
-func mainLoop(memBolt *BoltDB, style Style) {
-	screens := defaultScreensForData(memBolt)
+func mainLoop(memBolt *BoltDB, style Style, keymap *Keymap) {
+	screens := defaultScreensForData(memBolt, style, keymap)
 	displayScreen := screens[BrowserScreenIndex]
 	layoutAndDrawScreen(displayScreen, style)
 	for {
@@ -21,8 +22,21 @@ func mainLoop(memBolt *BoltDB, style Style) {
 				break
 			}
 		}
+		if event.Type == termbox.EventMouse {
+			newScreenIndex := displayScreen.handleMouseEvent(event)
+			if newScreenIndex < len(screens) {
+				displayScreen = screens[newScreenIndex]
+				layoutAndDrawScreen(displayScreen, style)
+			} else {
+				break
+			}
+		}
 		if event.Type == termbox.EventResize {
 			layoutAndDrawScreen(displayScreen, style)
 		}
 	}
+	if browser, ok := screens[BrowserScreenIndex].(*BrowserScreen); ok {
+		// Nowhere to tell anyone if this fails, the terminal is going away
+		browser.saveSession()
+	}
 }
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+A query picks pairs out of the database:
+
+	users/* as json where key ^= "2024-" and .status == "active" limit 10 offset 20
+
+The path is a glob for each bucket name from the root, with '**' standing
+for any number of buckets, and the pairs directly in the matching buckets
+are checked against the conditions. 'key' and 'value' compare the raw
+bytes, with literals written as "text", 0x0a0b (hex) or 42 (a big-endian
+uint64). '.a.b' looks inside the value using a decoder, picked with 'as'
+(the default tries json and then msgpack). The operators are == != < <= >
+>= ^= (starts with) and ~ (glob). Conditions on the key also set where the
+bucket's cursor starts and stops, so key ranges don't scan the whole bucket.
+*/
+type Query struct {
+	path    []string
+	decoder string
+	conds   []QueryCond
+	limit   int
+	offset  int
+}
+
+const (
+	condKey = iota
+	condValue
+	condField
+)
+
+/*
+QueryCond is one condition of the 'where' clause
+*/
+type QueryCond struct {
+	target int
+	// The keys to follow into the value for condField
+	field []string
+	op    string
+	lit   QueryLiteral
+}
+
+/*
+QueryLiteral is what a condition compares against,
+raw is what it is as bytes for comparing keys and values
+*/
+type QueryLiteral struct {
+	raw   []byte
+	str   string
+	num   float64
+	isNum bool
+	// "string", "number", "bool", "null" or "hex"
+	kind string
+}
+
+/*
+QueryResult is a pair a query matched
+*/
+type QueryResult struct {
+	path  []string
+	value []byte
+}
+
+// errQueryDone stops walking the database once we have 'limit' results
+var errQueryDone = errors.New("query done")
+
+var queryOperators = []string{"==", "!=", "<=", ">=", "^=", "<", ">", "~"}
+
+type queryToken struct {
+	text string
+	// Quoted strings are never keywords or operators
+	quoted bool
+	op     bool
+}
+
+func tokenizeQuery(expr string) ([]queryToken, error) {
+	var tokens []queryToken
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, errors.New("Unterminated string")
+			}
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("Bad string %s", expr[i:end+1])
+			}
+			tokens = append(tokens, queryToken{text: s, quoted: true})
+			i = end + 1
+		default:
+			if op := queryOperatorAt(expr, i); op != "" {
+				tokens = append(tokens, queryToken{text: op, op: true})
+				i += len(op)
+				continue
+			}
+			end := i
+			for end < len(expr) && expr[end] != ' ' && expr[end] != '\t' && expr[end] != '"' && queryOperatorAt(expr, end) == "" {
+				end++
+			}
+			tokens = append(tokens, queryToken{text: expr[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+func queryOperatorAt(expr string, i int) string {
+	for _, op := range queryOperators {
+		if strings.HasPrefix(expr[i:], op) {
+			return op
+		}
+	}
+	return ""
+}
+
+/*
+parseQuery parses a query expression, see Query
+*/
+func parseQuery(expr string) (*Query, error) {
+	tokens, err := tokenizeQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 || tokens[0].op {
+		return nil, errors.New("A query starts with a bucket path, like users/*")
+	}
+	q := &Query{decoder: defaultDecoderName}
+	q.path, _ = splitCommandPath(tokens[0].text)
+	if len(q.path) == 0 {
+		return nil, errors.New("Empty query path, use ** for every bucket")
+	}
+	for _, seg := range q.path {
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, fmt.Errorf("Bad path glob %q", seg)
+		}
+	}
+	tokens = tokens[1:]
+
+	next := func() (queryToken, error) {
+		if len(tokens) == 0 {
+			return queryToken{}, errors.New("Unexpected end of query")
+		}
+		t := tokens[0]
+		tokens = tokens[1:]
+		return t, nil
+	}
+	isWord := func(t queryToken, w string) bool { return !t.quoted && !t.op && t.text == w }
+	for len(tokens) > 0 {
+		t, _ := next()
+		switch {
+		case isWord(t, "as"):
+			name, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := parsers[name.text]; !ok && name.text != defaultDecoderName {
+				return nil, fmt.Errorf("Can't query inside %s values", name.text)
+			}
+			q.decoder = name.text
+		case isWord(t, "where"), isWord(t, "and"):
+			cond, err := parseQueryCond(next)
+			if err != nil {
+				return nil, err
+			}
+			q.conds = append(q.conds, cond)
+		case isWord(t, "limit"), isWord(t, "offset"):
+			n, err := next()
+			if err != nil {
+				return nil, err
+			}
+			v, err := strconv.Atoi(n.text)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Bad %s %q", t.text, n.text)
+			}
+			if t.text == "limit" {
+				q.limit = v
+			} else {
+				q.offset = v
+			}
+		default:
+			return nil, fmt.Errorf("Unexpected %q, expected where, and, as, limit or offset", t.text)
+		}
+	}
+	return q, nil
+}
+
+func parseQueryCond(next func() (queryToken, error)) (QueryCond, error) {
+	var cond QueryCond
+	operand, err := next()
+	if err != nil {
+		return cond, err
+	}
+	switch {
+	case operand.quoted || operand.op:
+		return cond, fmt.Errorf("Expected key, value or .field, got %q", operand.text)
+	case operand.text == "key":
+		cond.target = condKey
+	case operand.text == "value":
+		cond.target = condValue
+	case strings.HasPrefix(operand.text, "."):
+		cond.target = condField
+		if operand.text != "." {
+			cond.field = strings.Split(operand.text[1:], ".")
+		}
+	default:
+		return cond, fmt.Errorf("Expected key, value or .field, got %q", operand.text)
+	}
+	op, err := next()
+	if err != nil {
+		return cond, err
+	}
+	if !op.op {
+		return cond, fmt.Errorf("Expected an operator after %s, got %q", operand.text, op.text)
+	}
+	cond.op = op.text
+	litTok, err := next()
+	if err != nil {
+		return cond, err
+	}
+	if cond.lit, err = parseQueryLiteral(litTok); err != nil {
+		return cond, err
+	}
+	if cond.target != condField && cond.lit.raw == nil {
+		return cond, fmt.Errorf("Can't compare %s with %s", operand.text, litTok.text)
+	}
+	return cond, nil
+}
+
+/*
+parseQueryLiteral works out what a literal is. Numbers are also
+kept as big-endian uint64 bytes (when they can be) for key and value
+*/
+func parseQueryLiteral(t queryToken) (QueryLiteral, error) {
+	lit := QueryLiteral{str: t.text}
+	if t.quoted {
+		lit.kind = "string"
+		lit.raw = []byte(t.text)
+		return lit, nil
+	}
+	switch {
+	case t.op:
+		return lit, fmt.Errorf("Expected a value, got %q", t.text)
+	case t.text == "true" || t.text == "false":
+		lit.kind = "bool"
+	case t.text == "null":
+		lit.kind = "null"
+	case strings.HasPrefix(t.text, "0x"):
+		b, err := hex.DecodeString(t.text[2:])
+		if err != nil {
+			return lit, fmt.Errorf("Bad hex %q", t.text)
+		}
+		lit.kind, lit.raw = "hex", b
+	default:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return lit, fmt.Errorf("Bad value %q, strings need quotes", t.text)
+		}
+		lit.kind, lit.num, lit.isNum = "number", n, true
+		if u, err := strconv.ParseUint(t.text, 10, 64); err == nil {
+			lit.raw = make([]byte, 8)
+			binary.BigEndian.PutUint64(lit.raw, u)
+		}
+	}
+	return lit, nil
+}
+
+/*
+run walks the buckets matching the path inside 'tx', calling 'fn' for
+each pair that matches until it returns false. decoderFor picks the
+decoder for .field conditions in a bucket when the query doesn't say
+*/
+func (q *Query) run(tx *bolt.Tx, decoderFor func(bucketPath []string) string, fn func(QueryResult) bool) error {
+	qr := &queryRun{q: q, tx: tx, decoderFor: decoderFor, fn: fn}
+	err := qr.walk(nil, nil, q.path)
+	if err == errQueryDone {
+		return nil
+	}
+	return err
+}
+
+type queryRun struct {
+	q          *Query
+	tx         *bolt.Tx
+	decoderFor func([]string) string
+	fn         func(QueryResult) bool
+	matched    int
+	returned   int
+}
+
+// eachBucket calls fn for every bucket directly in b (or the root when b is nil)
+func (qr *queryRun) eachBucket(b *bolt.Bucket, fn func(name []byte, child *bolt.Bucket) error) error {
+	if b == nil {
+		return qr.tx.ForEach(fn)
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			if err := fn(k, b.Bucket(k)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (qr *queryRun) walk(b *bolt.Bucket, bktPath []string, segs []string) error {
+	if len(segs) == 0 {
+		return qr.scan(b, bktPath)
+	}
+	seg := segs[0]
+	if seg == "**" {
+		// No buckets at all...
+		if err := qr.walk(b, bktPath, segs[1:]); err != nil {
+			return err
+		}
+		// ...or one more, and maybe more after that
+		return qr.eachBucket(b, func(name []byte, child *bolt.Bucket) error {
+			return qr.walk(child, appendPath(bktPath, string(name)), segs)
+		})
+	}
+	if !strings.ContainsAny(seg, "*?[\\") {
+		// A plain name, no need to look at the others
+		var child *bolt.Bucket
+		if b == nil {
+			child = qr.tx.Bucket([]byte(seg))
+		} else {
+			child = b.Bucket([]byte(seg))
+		}
+		if child == nil {
+			return nil
+		}
+		return qr.walk(child, appendPath(bktPath, seg), segs[1:])
+	}
+	return qr.eachBucket(b, func(name []byte, child *bolt.Bucket) error {
+		if ok, _ := path.Match(seg, string(name)); ok {
+			return qr.walk(child, appendPath(bktPath, string(name)), segs[1:])
+		}
+		return nil
+	})
+}
+
+func appendPath(p []string, name string) []string {
+	return append(append([]string{}, p...), name)
+}
+
+// scan checks the pairs in b, using the key conditions to limit where the cursor goes
+func (qr *queryRun) scan(b *bolt.Bucket, bktPath []string) error {
+	if b == nil {
+		// The root only has buckets
+		return nil
+	}
+	var start []byte
+	for _, cond := range qr.q.conds {
+		if cond.target != condKey {
+			continue
+		}
+		switch cond.op {
+		case "==", ">=", ">", "^=":
+			if bytes.Compare(cond.lit.raw, start) > 0 {
+				start = cond.lit.raw
+			}
+		}
+	}
+	decoder := qr.q.decoder
+	if decoder == defaultDecoderName && qr.decoderFor != nil {
+		decoder = qr.decoderFor(bktPath)
+	}
+	c := b.Cursor()
+	k, v := c.First()
+	if start != nil {
+		k, v = c.Seek(start)
+	}
+	for ; k != nil; k, v = c.Next() {
+		if qr.pastEnd(k) {
+			break
+		}
+		if v == nil || !qr.matches(k, v, decoder) {
+			continue
+		}
+		qr.matched++
+		if qr.matched <= qr.q.offset {
+			continue
+		}
+		qr.returned++
+		if !qr.fn(QueryResult{path: appendPath(bktPath, string(k)), value: v}) {
+			return errQueryDone
+		}
+		if qr.q.limit > 0 && qr.returned >= qr.q.limit {
+			return errQueryDone
+		}
+	}
+	return nil
+}
+
+// pastEnd is whether no key from 'k' on can match the key conditions
+func (qr *queryRun) pastEnd(k []byte) bool {
+	for _, cond := range qr.q.conds {
+		if cond.target != condKey {
+			continue
+		}
+		c := bytes.Compare(k, cond.lit.raw)
+		switch cond.op {
+		case "<":
+			if c >= 0 {
+				return true
+			}
+		case "<=", "==":
+			if c > 0 {
+				return true
+			}
+		case "^=":
+			if c > 0 && !bytes.HasPrefix(k, cond.lit.raw) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (qr *queryRun) matches(k, v []byte, decoder string) bool {
+	var parsed interface{}
+	var parseErr error
+	didParse := false
+	for _, cond := range qr.q.conds {
+		switch cond.target {
+		case condKey:
+			if !compareQueryBytes(k, cond.op, cond.lit.raw) {
+				return false
+			}
+		case condValue:
+			if !compareQueryBytes(v, cond.op, cond.lit.raw) {
+				return false
+			}
+		case condField:
+			if !didParse {
+				parsed, parseErr = parseValue(decoder, v)
+				didParse = true
+			}
+			if parseErr != nil {
+				return false
+			}
+			field, ok := queryField(parsed, cond.field)
+			if !ok || !compareQueryField(field, cond.op, cond.lit) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func compareQueryBytes(b []byte, op string, lit []byte) bool {
+	switch op {
+	case "^=":
+		return bytes.HasPrefix(b, lit)
+	case "~":
+		ok, _ := path.Match(string(lit), stringify(b))
+		return ok
+	}
+	return compareResult(bytes.Compare(b, lit), op)
+}
+
+func compareResult(c int, op string) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// queryField follows 'field' into d, numbers pick from lists
+func queryField(d interface{}, field []string) (interface{}, bool) {
+	for _, f := range field {
+		switch t := d.(type) {
+		case map[string]interface{}:
+			v, ok := t[f]
+			if !ok {
+				return nil, false
+			}
+			d = v
+		case []interface{}:
+			i, err := strconv.Atoi(f)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			d = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return d, true
+}
+
+func toQueryNumber(d interface{}) (float64, bool) {
+	switch n := d.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func compareQueryField(d interface{}, op string, lit QueryLiteral) bool {
+	switch lit.kind {
+	case "number":
+		n, ok := toQueryNumber(d)
+		if !ok {
+			return op == "!="
+		}
+		c := 0
+		if n < lit.num {
+			c = -1
+		} else if n > lit.num {
+			c = 1
+		}
+		return compareResult(c, op)
+	case "bool":
+		b, ok := d.(bool)
+		eq := ok && b == (lit.str == "true")
+		return (op == "==" && eq) || (op == "!=" && !eq)
+	case "null":
+		eq := d == nil
+		return (op == "==" && eq) || (op == "!=" && !eq)
+	}
+	s, ok := d.(string)
+	if !ok {
+		return op == "!="
+	}
+	switch op {
+	case "^=":
+		return strings.HasPrefix(s, lit.str)
+	case "~":
+		m, _ := path.Match(lit.str, s)
+		return m
+	}
+	return compareResult(strings.Compare(s, lit.str), op)
+}
+
+// runQuery is the 'query' subcommand, it prints each matching path and value
+func runQuery(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: " + ProgramName + " query <filename> <expression>")
+	}
+	q, err := parseQuery(args[1])
+	if err != nil {
+		return err
+	}
+	bdb, err := openForBackup(args[0])
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	return bdb.View(func(tx *bolt.Tx) error {
+		return q.run(tx, nil, func(r QueryResult) bool {
+			fmt.Printf("%s\t%s\n", formatQueryPath(r.path), stringify(r.value))
+			return true
+		})
+	})
+}
+
+// formatQueryPath shows a result's path the way it would be typed
+func formatQueryPath(p []string) string {
+	var parts []string
+	for _, name := range p {
+		parts = append(parts, strings.Replace(stringify([]byte(name)), "/", "\\/", -1))
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestQuery(t *testing.T) {
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "query.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		if err := fillServeTestDB(tx); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte("a/b")).Put([]byte("k"), []byte("v")); err != nil {
+			return err
+		}
+		docs, err := tx.CreateBucket([]byte("docs"))
+		if err != nil {
+			return err
+		}
+		return docs.Put([]byte("d"), []byte(`{"name":"ann","tags":["a","b"],"ok":true,"n":null}`))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, 1000)
+	thousand := formatQueryPath([]string{"nums", string(k)})
+
+	active := func(keys ...string) string {
+		var paths []string
+		for _, k := range keys {
+			paths = append(paths, "users/active/"+k)
+		}
+		return strings.Join(paths, " ")
+	}
+	for _, c := range []struct {
+		expr, want string
+	}{
+		{`users/*`, active("u0", "u1", "u2", "u3", "u4", "x")},
+		{`users/act?ve where key ~ "u[13]"`, active("u1", "u3")},
+		{`** where key == "bin"`, "users/bin"},
+		{`users where key ^= "b"`, "users/bin"},
+		{`** where value == "thousand"`, thousand},
+		{`nums where key == 1000`, thousand},
+		{`nums where key < 0x00000000000003e9 and key > 999`, thousand},
+		{`nums where key != 1000`, ""},
+		{`a\/b`, `a\/b/k`},
+		{`users/active where key >= "u1" and key < "u3"`, active("u1", "u2")},
+		{`users/active where key > "u3"`, active("u4", "x")},
+		{`users/active where key <= "u1"`, active("u0", "u1")},
+		{`users/active where value ~ "{*}"`, active("u0", "u1", "u2", "u3", "u4")},
+		{`users/active where key != "x" limit 2 offset 1`, active("u1", "u2")},
+		{`users/active offset 5`, active("x")},
+		{`users/active limit 0`, active("u0", "u1", "u2", "u3", "u4", "x")},
+		// "other" isn't json, so x never matches a field
+		{`users/active where .id > 2`, active("u3", "u4")},
+		{`users/active as json where .id <= 1`, active("u0", "u1")},
+		{`users/active as json where .id != 1 limit 2`, active("u0", "u2")},
+		{`users/active as msgpack where .id == 1`, ""},
+		{`docs where .tags.1 == "b" and .ok == true and .n == null`, "docs/d"},
+		{`docs where .name ^= "a" and .name ~ "a?n" and .tags.0 != "c"`, "docs/d"},
+		{`docs where .name > "bob"`, ""},
+		// Fields that aren't there never match
+		{`docs where .tags.2 != "c"`, ""},
+		{`docs where .missing == null`, ""},
+	} {
+		q, err := parseQuery(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		var got []string
+		err = bdb.View(func(tx *bolt.Tx) error {
+			return q.run(tx, nil, func(r QueryResult) bool {
+				got = append(got, formatQueryPath(r.path))
+				return true
+			})
+		})
+		if err != nil || strings.Join(got, " ") != c.want {
+			t.Errorf("%s: got %q (%v), want %q", c.expr, strings.Join(got, " "), err, c.want)
+		}
+	}
+
+	// Stopping early from the callback
+	q, _ := parseQuery(`users/active`)
+	n := 0
+	bdb.View(func(tx *bolt.Tx) error {
+		return q.run(tx, nil, func(QueryResult) bool {
+			n++
+			return n < 2
+		})
+	})
+	if n != 2 {
+		t.Errorf("got %d results after stopping at 2", n)
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, c := range []struct {
+		expr, want string
+	}{
+		{``, "A query starts with a bucket path"},
+		{`== "a"`, "A query starts with a bucket path"},
+		{`users/[a`, "Bad path glob"},
+		{`users where`, "Unexpected end of query"},
+		{`users where key ==`, "Unexpected end of query"},
+		{`users where key == "a`, "Unterminated string"},
+		{`users where name == "a"`, "Expected key, value or .field"},
+		{`users where key = "a"`, "Expected an operator after key"},
+		{`users where key == abc`, "Bad value"},
+		{`users where key == 0xzz`, "Bad hex"},
+		{`users where key == true`, "Can't compare key with true"},
+		{`users as yaml`, "Can't query inside yaml values"},
+		{`users limit ten`, `Bad limit "ten"`},
+		{`users offset -1`, `Bad offset "-1"`},
+		{`users sort key`, `Unexpected "sort"`},
+	} {
+		if _, err := parseQuery(c.expr); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: got %v, want %q", c.expr, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/nsf/termbox-go"
+)
+
+// The most results :query keeps when the query has no limit
+const maxQueryResults = 10000
+
+/*
+runQueryCommand runs a query against the database and
+shows what it finds in place of the tree
+*/
+func runQueryCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.rest) == 0 {
+		return BrowserScreenIndex, errors.New("Usage: query <expression>, see :help query")
+	}
+	expr := cmd.rest[0]
+	q, err := parseQuery(expr)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	// Results jump into the tree, so it needs to be up to date
+	screen.refreshDatabase()
+	var results []QueryResult
+	truncated := false
+	err = viewDatabase(func(tx *bolt.Tx) error {
+		return q.run(tx, screen.getDecoderName, func(r QueryResult) bool {
+			if len(results) >= maxQueryResults {
+				truncated = true
+				return false
+			}
+			// The value is only good for the transaction
+			r.value = append([]byte{}, r.value...)
+			results = append(results, r)
+			return true
+		})
+	})
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	if len(results) == 0 {
+		screen.setMessage("No results for " + expr)
+		return BrowserScreenIndex, nil
+	}
+	screen.results = results
+	screen.resultsQuery = expr
+	screen.scan = nil
+	screen.resultCursor = 0
+	screen.showResults()
+	msg := fmt.Sprintf("%d results", len(results))
+	if truncated {
+		msg = fmt.Sprintf("First %d results (add a limit)", len(results))
+	}
+	screen.setMessageWithTimeout(msg+" - enter goes to one, esc goes back", -1)
+	return BrowserScreenIndex, nil
+}
+
+// runResultsCommand goes back to the results of the last query
+func runResultsCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(screen.results) == 0 {
+		return BrowserScreenIndex, errors.New("No query results")
+	}
+	screen.showResults()
+	return BrowserScreenIndex, nil
+}
+
+func (screen *BrowserScreen) showResults() {
+	screen.resultsReturnPath = screen.currentPath
+	screen.mode = modeResults
+	screen.selectResult(screen.resultCursor)
+}
+
+// selectResult moves to result 'idx', the right pane shows it like any other pair
+func (screen *BrowserScreen) selectResult(idx int) {
+	if screen.scan != nil && !screen.scan.done && idx >= len(screen.results)-screen.leftPane.h {
+		screen.scanMore(idx + screen.leftPane.h + 1)
+	}
+	if idx >= len(screen.results) {
+		idx = len(screen.results) - 1
+	}
+	if idx < 0 {
+		idx = 0
+	}
+	screen.resultCursor = idx
+	screen.currentPath = screen.results[idx].path
+}
+
+func (screen *BrowserScreen) handleResultsKeyEvent(event termbox.Event) int {
+	for _, action := range screen.keymap.handleKey(keyFromEvent(event)) {
+		switch action {
+		case actionDown:
+			screen.selectResult(screen.resultCursor + 1)
+		case actionUp:
+			screen.selectResult(screen.resultCursor - 1)
+		case actionPageDown:
+			screen.selectResult(screen.resultCursor + screen.leftPane.h/2)
+		case actionPageUp:
+			screen.selectResult(screen.resultCursor - screen.leftPane.h/2)
+		case actionTop:
+			screen.selectResult(0)
+		case actionBottom:
+			screen.selectResult(len(screen.results) - 1)
+		case actionPaneDown:
+			screen.moveRightPaneDown()
+		case actionPaneUp:
+			screen.moveRightPaneUp()
+		case actionOpen:
+			// Into the tree at this result
+			screen.mode = modeBrowse
+			if err := screen.jumpToPath(screen.currentPath); err != nil {
+				screen.setMessage(err.Error())
+			} else {
+				screen.setMessage("Back to the results with :results")
+			}
+		case actionClose, actionQuit:
+			screen.mode = modeBrowse
+			screen.currentPath = screen.resultsReturnPath
+			screen.setMessage("Back to the results with :results")
+		case actionHelp:
+			return AboutScreenIndex
+		}
+	}
+	return BrowserScreenIndex
+}
+
+func (screen *BrowserScreen) drawResults(style Style) {
+	pane := screen.leftPane
+	if pane.empty() {
+		return
+	}
+	// Keep the cursor in the top two thirds, like the tree
+	top := 0
+	if maxCursor := pane.h * 2 / 3; screen.resultCursor > maxCursor {
+		top = screen.resultCursor - maxCursor
+	}
+	for i := top; i < len(screen.results) && i-top < pane.h; i++ {
+		r := screen.results[i]
+		fg, bg := style.pairFg, style.pairBg
+		if i == screen.resultCursor {
+			fg, bg = style.cursorFg, style.cursorBg
+		}
+		text := formatQueryPath(r.path) + ": " + stringify(r.value)
+		if r.value == nil {
+			// A bucket, from :scan
+			text = formatQueryPath(r.path) + "/"
+		}
+		text = truncateText(text, pane.w)
+		if pad := pane.w - textWidth(text); pad > 0 {
+			text += strings.Repeat(" ", pad)
+		}
+		drawClipped(text, pane.x, pane.y+i-top, pane, fg, bg)
+	}
+}
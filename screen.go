@@ -5,6 +5,7 @@ import "github.com/nsf/termbox-go"
 // Screen is a basic structure for all of the applications screens
 type Screen interface {
 	handleKeyEvent(event termbox.Event) int
+	handleMouseEvent(event termbox.Event) int
 	performLayout()
 	drawScreen(style Style)
 }
@@ -18,11 +19,28 @@ const (
 	ExitScreenIndex
 )
 
-func defaultScreensForData(db *BoltDB) []Screen {
+func defaultScreensForData(db *BoltDB, style Style, keymap *Keymap) []Screen {
 	var viewPort ViewPort
 
-	browserScreen := BrowserScreen{db: db, viewPort: viewPort}
-	aboutScreen := AboutScreen(0)
+	browserScreen := BrowserScreen{db: db, viewPort: viewPort, style: style, keymap: keymap}
+	browserScreen.history = loadCommandHistory()
+	browserScreen.layout, _ = parseLayoutMode(AppConfig.Layout.Mode)
+	browserScreen.splitRatio = AppConfig.Layout.Split
+	browserScreen.wrapDetails = true
+	if err := browserScreen.loadSortOptions(); err != nil {
+		browserScreen.setMessage("Couldn't load sort orders: " + err.Error())
+	}
+	var err error
+	if browserScreen.bookmarks, err = loadBookmarks(); err != nil {
+		browserScreen.setMessage("Couldn't load bookmarks: " + err.Error())
+	}
+	if db != nil {
+		browserScreen.useDisplayOptions()
+		if err := browserScreen.restoreSession(); err != nil {
+			browserScreen.setMessage("Couldn't restore session: " + err.Error())
+		}
+	}
+	aboutScreen := AboutScreen{keymap: keymap}
 	screens := [...]Screen{
 		&browserScreen,
 		&aboutScreen,
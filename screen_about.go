@@ -1,7 +1,8 @@
 package main
 
 import (
-	"fmt"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/br0xen/termbox-util"
 	"github.com/nsf/termbox-go"
@@ -16,26 +17,60 @@ type Command struct {
 }
 
 /*
-AboutScreen is just a basic 'int' type that we can extend to make this screen
+AboutScreen shows the help, generated from the active keymap
 */
-type AboutScreen int
+type AboutScreen struct {
+	keymap *Keymap
+}
 
-func drawCommandsAtPoint(commands []Command, x int, y int, style Style) {
+// drawCommandsAtPoint returns the width it used
+func drawCommandsAtPoint(commands []Command, x int, y int, style Style) int {
+	keyW, descW := 0, 0
+	for _, cmd := range commands {
+		if n := utf8.RuneCountInString(cmd.key); n > keyW {
+			keyW = n
+		}
+		if n := utf8.RuneCountInString(cmd.description); n > descW {
+			descW = n
+		}
+	}
 	xPos, yPos := x, y
-	for index, cmd := range commands {
-		termboxUtil.DrawStringAtPoint(fmt.Sprintf("%6s", cmd.key), xPos, yPos, style.defaultFg, style.defaultBg)
-		termboxUtil.DrawStringAtPoint(cmd.description, xPos+8, yPos, style.defaultFg, style.defaultBg)
+	for _, cmd := range commands {
+		keyStr := strings.Repeat(" ", keyW-utf8.RuneCountInString(cmd.key)) + cmd.key
+		termboxUtil.DrawStringAtPoint(keyStr, xPos, yPos, style.defaultFg, style.defaultBg)
+		termboxUtil.DrawStringAtPoint(cmd.description, xPos+keyW+2, yPos, style.defaultFg, style.defaultBg)
 		yPos++
-		if index > 2 && index%2 == 1 {
-			yPos++
+	}
+	return keyW + 2 + descW
+}
+
+// commandsForGroup lists the bound actions in 'group' along with their keys
+func (screen *AboutScreen) commandsForGroup(group int) []Command {
+	var commands []Command
+	for _, a := range actions {
+		if a.group != group {
+			continue
+		}
+		keys := screen.keymap.keysForAction(a.action)
+		if len(keys) == 0 {
+			continue
 		}
+		commands = append(commands, Command{strings.Join(keys, ","), a.description})
 	}
+	return commands
 }
 
 func (screen *AboutScreen) handleKeyEvent(event termbox.Event) int {
 	return BrowserScreenIndex
 }
 
+func (screen *AboutScreen) handleMouseEvent(event termbox.Event) int {
+	if event.Key == termbox.MouseLeft {
+		return BrowserScreenIndex
+	}
+	return AboutScreenIndex
+}
+
 func (screen *AboutScreen) performLayout() {}
 
 func (screen *AboutScreen) drawScreen(style Style) {
@@ -62,22 +97,28 @@ func (screen *AboutScreen) drawScreen(style Style) {
 			"|____||____||_____|_|  |____||_| |_||____||__| |__||____||___||_|  |_|",
 		}
 	}
+	commands1 := screen.commandsForGroup(0)
+	commands2 := screen.commandsForGroup(1)
+	commandRows := len(commands1)
+	if len(commands2) > commandRows {
+		commandRows = len(commands2)
+	}
+
 	firstLine := template[0]
 	startX := (width - len(firstLine)) / 2
-	//startX := (width - len(firstLine)) / 2
-	startY := ((height - 2*len(template)) / 2) - 2
+	if startX < 0 {
+		startX = 0
+	}
+	// Logo, a blank line, the commands and the exit text
+	startY := (height - (len(template) + commandRows + 3)) / 2
 	xPos := startX
 	yPos := startY
-	if height <= 20 {
+	if height <= 20 || startY < 0 {
 		title := "BoltBrowser"
 		startY = 0
 		yPos = 0
 		termboxUtil.DrawStringAtPoint(title, (width-len(title))/2, startY, style.titleFg, style.titleBg)
 	} else {
-		if height < 25 {
-			startY = 0
-			yPos = 0
-		}
 		for _, line := range template {
 			xPos = startX
 			for _, runeValue := range line {
@@ -94,34 +135,11 @@ func (screen *AboutScreen) drawScreen(style Style) {
 		}
 	}
 
-	commands1 := [...]Command{
-		{"h,←", "close parent"},
-		{"j,↓", "down"},
-		{"k,↑", "up"},
-		{"l,→", "open item"},
-
-		{"g", "goto top"},
-		{"G", "goto bottom"},
-		{"ctrl+f", "jump down"},
-		{"ctrl+b", "jump up"},
-	}
-
-	commands2 := [...]Command{
-		{"p,P", "create pair/at parent"},
-		{"b,B", "create bucket/at parent"},
-		{"e", "edit value of pair"},
-		{"r", "rename pair/bucket"},
-		{"D", "delete item"},
-		{"x,X", "export as string/json to file"},
-
-		{"?", "this screen"},
-		{"q", "quit program"},
-	}
-	xPos = startX // + 20
+	xPos = startX
 	yPos++
 
-	drawCommandsAtPoint(commands1[:], xPos, yPos+1, style)
-	drawCommandsAtPoint(commands2[:], xPos+20, yPos+1, style)
+	usedW := drawCommandsAtPoint(commands1, xPos, yPos, style)
+	drawCommandsAtPoint(commands2, xPos+usedW+4, yPos, style)
 	exitTxt := "Press any key to return to browser"
 	termboxUtil.DrawStringAtPoint(exitTxt, (width-len(exitTxt))/2, height-1, style.titleFg, style.titleBg)
 }
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/br0xen/termbox-util"
 	"github.com/nsf/termbox-go"
@@ -23,6 +25,8 @@ BrowserScreen holds all that's going on :D
 */
 type BrowserScreen struct {
 	db             *BoltDB
+	style          Style
+	keymap         *Keymap
 	viewPort       ViewPort
 	queuedCommand  string
 	currentPath    []string
@@ -36,6 +40,54 @@ type BrowserScreen struct {
 
 	rightPaneHeight int
 	rightPaneCursor int
+
+	// The ':' command line, queuedCommand is what's been typed so far
+	commandCursor int
+	completions   []string
+	history       *CommandHistory
+
+	// Decoder names and sort orders by bucket path, sub-buckets inherit them
+	decoders map[string]string
+	sorts    map[string]SortOptions
+	// Filters only apply to the bucket they're set on, see filter.go
+	filters map[string]string
+
+	bookmarks Bookmarks
+
+	// The last :query, shown in place of the tree in modeResults
+	results           []QueryResult
+	resultsQuery      string
+	resultCursor      int
+	resultsReturnPath []string
+	// Set when the results are from :scan, which loads them a page at a time
+	scan *KeyScan
+
+	// The export waiting for the OK to overwrite a file, see exportTo
+	pendingExport func() error
+
+	// The diff shown in modePreview (from :jq or :run), see showPreview
+	preview       []DiffLine
+	previewOffset int
+	previewCommit func() (string, error)
+	previewCancel func()
+
+	// Set up by performLayout, see layout.go
+	layout          LayoutMode
+	zoom            ZoomMode
+	splitRatio      float64
+	body            Rect
+	leftPane        Rect
+	rightPane       Rect
+	divider         Rect
+	draggingDivider bool
+
+	// What was drawn last, for the mouse and for scrolling
+	treeRows        []TreeRow
+	wrapTree        bool
+	rightPanePath   []string
+	rightPaneColumn int
+	rightPaneWidth  int
+	wrapDetails     bool
 }
 
 /*
@@ -44,20 +96,30 @@ BrowserMode is just for designating the mode that we're in
 type BrowserMode int
 
 const (
-	modeBrowse        = 16  // 0000 0001 0000
-	modeChange        = 32  // 0000 0010 0000
-	modeChangeKey     = 33  // 0000 0010 0001
-	modeChangeVal     = 34  // 0000 0010 0010
-	modeInsert        = 64  // 0000 0100 0000
-	modeInsertBucket  = 65  // 0000 0100 0001
-	modeInsertPair    = 68  // 0000 0100 0100
-	modeInsertPairKey = 69  // 0000 0100 0101
-	modeInsertPairVal = 70  // 0000 0100 0110
-	modeDelete        = 256 // 0001 0000 0000
-	modeModToParent   = 8   // 0000 0000 1000
-	modeExport        = 512 // 0010 0000 0000
-	modeExportValue   = 513 // 0010 0000 0001
-	modeExportJSON    = 514 // 0010 0000 0010
+	modeBrowse        = 16   // 0000 0001 0000
+	modeChange        = 32   // 0000 0010 0000
+	modeChangeKey     = 33   // 0000 0010 0001
+	modeChangeVal     = 34   // 0000 0010 0010
+	modeChangeSeq     = 36   // 0000 0010 0100
+	modeInsert        = 64   // 0000 0100 0000
+	modeInsertBucket  = 65   // 0000 0100 0001
+	modeInsertPair    = 68   // 0000 0100 0100
+	modeInsertPairKey = 69   // 0000 0100 0101
+	modeInsertPairVal = 70   // 0000 0100 0110
+	modeDelete        = 256  // 0001 0000 0000
+	modeModToParent   = 8    // 0000 0000 1000
+	modeExport        = 512  // 0010 0000 0000
+	modeExportValue   = 513  // 0010 0000 0001
+	modeExportJSON    = 514  // 0010 0000 0010
+	modeExportCSV     = 516  // 0010 0000 0100
+	modeImport        = 520  // 0010 0000 1000
+	modeExportConfirm = 528  // 0010 0001 0000
+	modeCommand       = 1024 // 0100 0000 0000
+	modeMark          = 2048 // 1000 0000 0000
+	modeMarkSet       = 2049 // 1000 0000 0001
+	modeMarkJump      = 2050 // 1000 0000 0010
+	modeResults       = 4096 // 1 0000 0000 0000
+	modePreview       = 8192 // 10 0000 0000 0000
 )
 
 /*
@@ -84,68 +146,117 @@ func (screen *BrowserScreen) handleKeyEvent(event termbox.Event) int {
 		return screen.handleDeleteKeyEvent(event)
 	} else if screen.mode&modeExport == modeExport {
 		return screen.handleExportKeyEvent(event)
+	} else if screen.mode == modeCommand {
+		return screen.handleCommandKeyEvent(event)
+	} else if screen.mode&modeMark == modeMark {
+		return screen.handleMarkKeyEvent(event)
+	} else if screen.mode == modeResults {
+		return screen.handleResultsKeyEvent(event)
+	} else if screen.mode == modePreview {
+		return screen.handlePreviewKeyEvent(event)
 	}
 	return BrowserScreenIndex
 }
 
 func (screen *BrowserScreen) handleBrowseKeyEvent(event termbox.Event) int {
-	if event.Ch == '?' {
+	for _, action := range screen.keymap.handleKey(keyFromEvent(event)) {
+		if ret := screen.performAction(action); ret != BrowserScreenIndex {
+			return ret
+		}
+	}
+	return BrowserScreenIndex
+}
+
+func (screen *BrowserScreen) performAction(action Action) int {
+	switch action {
+	case actionHelp:
 		// About
 		return AboutScreenIndex
 
-	} else if event.Ch == 'q' || event.Key == termbox.KeyEsc || event.Key == termbox.KeyCtrlC {
-		// Quit
+	case actionQuit:
 		return ExitScreenIndex
 
-	} else if event.Ch == 'g' {
+	case actionTop:
 		// Jump to Beginning
 		screen.currentPath = screen.db.getNextVisiblePath(nil)
 
-	} else if event.Ch == 'G' {
+	case actionBottom:
 		// Jump to End
 		screen.currentPath = screen.db.getPrevVisiblePath(nil)
 
-	} else if event.Key == termbox.KeyCtrlR {
+	case actionRefresh:
 		screen.refreshDatabase()
 
-	} else if event.Key == termbox.KeyCtrlF {
+	case actionPageDown:
 		// Jump forward half a screen
-		_, h := termbox.Size()
-		half := h / 2
+		half := screen.leftPane.h / 2
 		screen.jumpCursorDown(half)
 
-	} else if event.Key == termbox.KeyCtrlB {
-		_, h := termbox.Size()
-		half := h / 2
+	case actionPageUp:
+		half := screen.leftPane.h / 2
 		screen.jumpCursorUp(half)
 
-	} else if event.Ch == 'j' || event.Key == termbox.KeyArrowDown {
+	case actionDown:
 		screen.moveCursorDown()
 
-	} else if event.Ch == 'k' || event.Key == termbox.KeyArrowUp {
+	case actionUp:
 		screen.moveCursorUp()
 
-	} else if event.Ch == 'J' {
+	case actionPaneDown:
 		screen.moveRightPaneDown()
 
-	} else if event.Ch == 'K' {
+	case actionPaneUp:
 		screen.moveRightPaneUp()
 
-	} else if event.Ch == 'p' {
+	case actionPaneLeft:
+		screen.moveRightPaneLeft()
+
+	case actionPaneRight:
+		screen.moveRightPaneRight()
+
+	case actionSetBookmark:
+		screen.mode = modeMarkSet
+		screen.setMessageWithTimeout("Bookmark letter?", -1)
+
+	case actionJumpBookmark:
+		screen.mode = modeMarkJump
+		screen.setMessageWithTimeout("Jump to bookmark?", -1)
+
+	case actionGoto:
+		screen.startGoto()
+	case actionSeek:
+		screen.startSeek()
+
+	case actionCycleSort:
+		if err := screen.cycleSort(); err != nil {
+			screen.setMessage(err.Error())
+		}
+
+	case actionWrapTree:
+		screen.wrapTree = !screen.wrapTree
+		screen.setMessage(fmt.Sprintf("Wrap tree: %t", screen.wrapTree))
+
+	case actionWrapDetails:
+		screen.wrapDetails = !screen.wrapDetails
+		screen.setMessage(fmt.Sprintf("Wrap values: %t", screen.wrapDetails))
+
+	case actionInsertPair:
 		// p creates a new pair at the current level
 		screen.startInsertItem(typePair)
-	} else if event.Ch == 'P' {
+
+	case actionInsertPairAtParent:
 		// P creates a new pair at the parent level
 		screen.startInsertItemAtParent(typePair)
 
-	} else if event.Ch == 'b' {
+	case actionInsertBucket:
 		// b creates a new bucket at the current level
 		screen.startInsertItem(typeBucket)
-	} else if event.Ch == 'B' {
+
+	case actionInsertBucketParent:
 		// B creates a new bucket at the parent level
 		screen.startInsertItemAtParent(typeBucket)
 
-	} else if event.Ch == 'e' {
+	case actionEdit:
 		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
 		if b != nil {
 			screen.setMessage("Cannot edit a bucket, did you mean to (r)ename?")
@@ -153,18 +264,24 @@ func (screen *BrowserScreen) handleBrowseKeyEvent(event termbox.Event) int {
 			screen.startEditItem()
 		}
 
-	} else if event.Ch == 'r' {
+	case actionRename:
 		screen.startRenameItem()
 
-	} else if event.Key == termbox.KeyEnter {
-		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
-		if b != nil {
-			screen.db.toggleOpenBucket(screen.currentPath)
-		} else if p != nil {
-			screen.startEditItem()
+	case actionSetSequence:
+		screen.startEditSequence()
+
+	case actionNextSequence:
+		b, _, _ := screen.db.getGenericFromPath(screen.currentPath)
+		if b == nil {
+			screen.setMessage("Only buckets have sequences")
+		} else if seq, err := nextBucketSequence(screen.currentPath); err != nil {
+			screen.setMessage(err.Error())
+		} else {
+			b.sequence = seq
+			screen.setMessage(fmt.Sprintf("Sequence of '%s' is now %d", b.name, seq))
 		}
 
-	} else if event.Ch == 'l' || event.Key == termbox.KeyArrowRight {
+	case actionOpen:
 		b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
 		// Select the current item
 		if b != nil {
@@ -175,7 +292,7 @@ func (screen *BrowserScreen) handleBrowseKeyEvent(event termbox.Event) int {
 			screen.setMessage("Not sure what to do here...")
 		}
 
-	} else if event.Ch == 'h' || event.Key == termbox.KeyArrowLeft {
+	case actionClose:
 		// If we are _on_ a bucket that's open, close it
 		b, _, e := screen.db.getGenericFromPath(screen.currentPath)
 		if e == nil && b != nil && b.expanded {
@@ -193,14 +310,64 @@ func (screen *BrowserScreen) handleBrowseKeyEvent(event termbox.Event) int {
 			}
 		}
 
-	} else if event.Ch == 'D' {
+	case actionDelete:
 		screen.startDeleteItem()
-	} else if event.Ch == 'x' {
+
+	case actionExportValue:
 		// Export Value
 		screen.startExportValue()
-	} else if event.Ch == 'X' {
+
+	case actionExportJSON:
 		// Export Key/Value (or Bucket) as JSON
 		screen.startExportJSON()
+
+	case actionCopyValue:
+		if _, p, err := screen.db.getGenericFromPath(screen.currentPath); err == nil && p != nil {
+			if err = screen.copyExport(valueExport(screen.currentPath)); err != nil {
+				screen.setMessage(err.Error())
+			}
+		}
+
+	case actionCopyJSON:
+		if len(screen.currentPath) > 0 {
+			if err := screen.copyExport(jsonExport(screen.currentPath)); err != nil {
+				screen.setMessage(err.Error())
+			}
+		}
+
+	case actionExportCSV:
+		screen.startCSV(modeExportCSV)
+
+	case actionImport:
+		screen.startCSV(modeImport)
+
+	case actionCommand:
+		screen.startCommand()
+
+	case actionZoomTree:
+		screen.toggleZoom(zoomLeft)
+
+	case actionZoomDetails:
+		screen.toggleZoom(zoomRight)
+
+	case actionSplitGrow:
+		screen.moveSplit(splitStep)
+
+	case actionSplitShrink:
+		screen.moveSplit(-splitStep)
+
+	case actionCycleLayout:
+		screen.zoom = zoomNone
+		screen.cycleLayout()
+		screen.setMessage("Layout: " + string(screen.layout))
+
+	case actionSnapshot:
+		// Snapshot the whole DB before doing something risky
+		if fName, err := snapshotDatabase(); err != nil {
+			screen.setMessage("Error creating snapshot: " + err.Error())
+		} else {
+			screen.setMessage("Snapshot saved to file: " + fName)
+		}
 	}
 	return BrowserScreenIndex
 }
@@ -224,6 +391,17 @@ func (screen *BrowserScreen) handleInputKeyEvent(event termbox.Event) int {
 						screen.setMessage("Bucket Renamed!")
 						screen.refreshDatabase()
 					}
+				} else if screen.mode == modeChangeSeq {
+					seq, err := strconv.ParseUint(strings.TrimSpace(screen.inputModal.GetValue()), 10, 64)
+					if err != nil {
+						screen.setMessage("Sequences are whole numbers from 0 to 18446744073709551615")
+					} else if err = setBucketSequence(screen.currentPath, seq); err != nil {
+						screen.setMessage(err.Error())
+					} else {
+						b.sequence = seq
+						screen.setMessage(fmt.Sprintf("Sequence set to %d", seq))
+						screen.refreshDatabase()
+					}
 				}
 			} else if p != nil {
 				if screen.mode == modeChangeKey {
@@ -361,6 +539,9 @@ func (screen *BrowserScreen) handleInsertKeyEvent(event termbox.Event) int {
 }
 
 func (screen *BrowserScreen) handleExportKeyEvent(event termbox.Event) int {
+	if screen.mode == modeExportConfirm {
+		return screen.handleExportConfirmKeyEvent(event)
+	}
 	if event.Key == termbox.KeyEsc {
 		screen.mode = modeBrowse
 		screen.inputModal.Clear()
@@ -369,95 +550,154 @@ func (screen *BrowserScreen) handleExportKeyEvent(event termbox.Event) int {
 		if screen.inputModal.IsDone() {
 			b, p, _ := screen.db.getGenericFromPath(screen.currentPath)
 			fileName := screen.inputModal.GetValue()
-			if screen.mode&modeExportValue == modeExportValue {
+			mode := screen.mode
+			// exportTo might want to ask before overwriting, which is another mode
+			screen.mode = modeBrowse
+			screen.inputModal.Clear()
+			var err error
+			if mode&modeExportValue == modeExportValue {
 				// Exporting the value
 				if p != nil {
-					if err := exportValue(screen.currentPath, fileName); err != nil {
-						//screen.setMessage("Error Exporting to file " + fileName + ".")
-						screen.setMessage(err.Error())
-					} else {
-						screen.setMessage("Value exported to file: " + fileName)
-					}
+					err = screen.exportTo(fileName, valueExport(screen.currentPath))
 				}
-			} else if screen.mode&modeExportJSON == modeExportJSON {
+			} else if mode&modeExportJSON == modeExportJSON && structuredFormat(fileName) != "" {
+				err = screen.exportTo(fileName, structuredExport(screen.currentPath, structuredFormat(fileName)))
+			} else if mode&modeExportJSON == modeExportJSON {
 				if b != nil || p != nil {
-					if exportJSON(screen.currentPath, fileName) != nil {
-						screen.setMessage("Error Exporting to file " + fileName + ".")
-					} else {
-						screen.setMessage("Value exported to file: " + fileName)
-					}
+					err = screen.exportTo(fileName, jsonExport(screen.currentPath))
+				}
+			} else if mode == modeExportCSV || mode == modeImport {
+				// The file name can be followed by options, e.g. "out.csv key=uint64 recursive"
+				var words []string
+				for _, t := range tokenizeCommand(fileName) {
+					words = append(words, t.text)
+				}
+				if len(words) > 0 && mode == modeImport && structuredFormat(words[0]) != "" {
+					err = screen.runStructuredImport(structuredFormat(words[0]), unescapeCommandWord(words[0]))
+				} else {
+					err = screen.runCSVCommand(mode == modeImport, "", words)
 				}
 			}
-			screen.mode = modeBrowse
-			screen.inputModal.Clear()
+			if err != nil {
+				screen.setMessage(err.Error())
+			}
 		}
 	}
 	return BrowserScreenIndex
 }
 
-func (screen *BrowserScreen) jumpCursorUp(distance int) bool {
-	// Jump up 'distance' lines
-	visPaths, err := screen.db.buildVisiblePathSlice()
-	if err == nil {
-		findPath := screen.currentPath
-		for idx, pth := range visPaths {
-			startJump := true
-			for i := range pth {
-				if len(screen.currentPath) > i && pth[i] != screen.currentPath[i] {
-					startJump = false
-				}
-			}
-			if startJump {
-				distance--
-				if distance == 0 {
-					screen.currentPath = visPaths[len(visPaths)-1-idx]
-					break
-				}
-			}
+func (screen *BrowserScreen) handleCommandKeyEvent(event termbox.Event) int {
+	runes := []rune(screen.queuedCommand)
+	cursor := screen.commandCursor
+	screen.completions = nil
+	switch {
+	case event.Key == termbox.KeyEsc || event.Key == termbox.KeyCtrlC:
+		screen.stopCommand()
+
+	case event.Key == termbox.KeyEnter:
+		line := strings.TrimSpace(screen.queuedCommand)
+		screen.stopCommand()
+		screen.history.add(line)
+		return screen.runCommandLine(line)
+
+	case event.Key == termbox.KeyTab:
+		screen.queuedCommand, screen.commandCursor, screen.completions = screen.completeCommandLine(screen.queuedCommand, cursor)
+		return BrowserScreenIndex
+
+	case event.Key == termbox.KeyBackspace || event.Key == termbox.KeyBackspace2:
+		if len(runes) == 0 {
+			// Backspace on an empty line leaves the prompt, like vim
+			screen.stopCommand()
+		} else if cursor > 0 {
+			runes = append(runes[:cursor-1], runes[cursor:]...)
+			cursor--
 		}
-		isCurPath := true
-		for i := range screen.currentPath {
-			if screen.currentPath[i] != findPath[i] {
-				isCurPath = false
-				break
-			}
+
+	case event.Key == termbox.KeyDelete:
+		if cursor < len(runes) {
+			runes = append(runes[:cursor], runes[cursor+1:]...)
 		}
-		if isCurPath {
-			screen.currentPath = screen.db.getNextVisiblePath(nil)
+
+	case event.Key == termbox.KeyArrowLeft || event.Key == termbox.KeyCtrlB:
+		if cursor > 0 {
+			cursor--
 		}
+
+	case event.Key == termbox.KeyArrowRight || event.Key == termbox.KeyCtrlF:
+		if cursor < len(runes) {
+			cursor++
+		}
+
+	case event.Key == termbox.KeyHome || event.Key == termbox.KeyCtrlA:
+		cursor = 0
+
+	case event.Key == termbox.KeyEnd || event.Key == termbox.KeyCtrlE:
+		cursor = len(runes)
+
+	case event.Key == termbox.KeyCtrlU:
+		runes, cursor = runes[cursor:], 0
+
+	case event.Key == termbox.KeyCtrlW:
+		// Delete the word before the cursor
+		start := cursor
+		for start > 0 && runes[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && runes[start-1] != ' ' {
+			start--
+		}
+		runes, cursor = append(runes[:start], runes[cursor:]...), start
+
+	case event.Key == termbox.KeyArrowUp || event.Key == termbox.KeyCtrlP:
+		if prev, ok := screen.history.prev(screen.queuedCommand); ok {
+			runes = []rune(prev)
+			cursor = len(runes)
+		}
+
+	case event.Key == termbox.KeyArrowDown || event.Key == termbox.KeyCtrlN:
+		if next, ok := screen.history.next(); ok {
+			runes = []rune(next)
+			cursor = len(runes)
+		}
+
+	case event.Ch != 0 || event.Key == termbox.KeySpace:
+		ch := event.Ch
+		if event.Key == termbox.KeySpace {
+			ch = ' '
+		}
+		runes = append(runes[:cursor], append([]rune{ch}, runes[cursor:]...)...)
+		cursor++
+	}
+	if screen.mode == modeCommand {
+		screen.queuedCommand = string(runes)
+		screen.commandCursor = cursor
+	}
+	return BrowserScreenIndex
+}
+
+func (screen *BrowserScreen) jumpCursorUp(distance int) bool {
+	// Jump up 'distance' lines
+	idx := screen.db.findVisibleRow(screen.currentPath) - distance
+	if idx < 0 {
+		idx = 0
+	}
+	if path := screen.db.visibleRowPath(idx); path != nil {
+		screen.currentPath = path
 	}
 	return true
 }
 func (screen *BrowserScreen) jumpCursorDown(distance int) bool {
-	visPaths, err := screen.db.buildVisiblePathSlice()
-	if err == nil {
-		findPath := screen.currentPath
-		for idx, pth := range visPaths {
-			startJump := true
-
-			for i := range pth {
-				if len(screen.currentPath) > i && pth[i] != screen.currentPath[i] {
-					startJump = false
-				}
-			}
-			if startJump {
-				distance--
-				if distance == 0 {
-					screen.currentPath = visPaths[idx]
-					break
-				}
-			}
-		}
-		isCurPath := true
-		for i := range screen.currentPath {
-			if screen.currentPath[i] != findPath[i] {
-				isCurPath = false
-				break
-			}
-		}
-		if isCurPath {
-			screen.currentPath = screen.db.getNextVisiblePath(nil)
-		}
+	idx := screen.db.findVisibleRow(screen.currentPath)
+	if idx < 0 {
+		idx = 0
+	} else {
+		idx += distance
+	}
+	if last := len(screen.db.visibleRows()) - 1; idx > last {
+		idx = last
+	}
+	if path := screen.db.visibleRowPath(idx); path != nil {
+		screen.currentPath = path
 	}
 	return true
 }
@@ -479,13 +719,112 @@ func (screen *BrowserScreen) moveCursorDown() bool {
 	return false
 }
 func (screen *BrowserScreen) moveRightPaneUp() bool {
+	if screen.rightPaneCursor > 0 {
+		screen.rightPaneCursor--
+		return true
+	}
 	return false
 }
 func (screen *BrowserScreen) moveRightPaneDown() bool {
+	if screen.rightPaneCursor < screen.rightPaneHeight-screen.rightPane.h {
+		screen.rightPaneCursor++
+		return true
+	}
+	return false
+}
+
+// How far the right pane scrolls sideways per key press
+const rightPaneColumnStep = 8
+
+func (screen *BrowserScreen) moveRightPaneLeft() bool {
+	if screen.rightPaneColumn > 0 {
+		screen.rightPaneColumn -= rightPaneColumnStep
+		if screen.rightPaneColumn < 0 {
+			screen.rightPaneColumn = 0
+		}
+		return true
+	}
+	return false
+}
+
+func (screen *BrowserScreen) moveRightPaneRight() bool {
+	if screen.wrapDetails {
+		screen.setMessage("Values are wrapped, turn wrapping off to scroll sideways")
+		return false
+	}
+	if screen.rightPaneColumn < screen.rightPaneWidth-(screen.rightPane.w-1) {
+		// drawRightPane stops it going past the end
+		screen.rightPaneColumn += rightPaneColumnStep
+		return true
+	}
 	return false
 }
 
-func (screen *BrowserScreen) performLayout() {}
+// How many rows a mouse wheel click scrolls
+const mouseWheelRows = 3
+
+func (screen *BrowserScreen) handleMouseEvent(event termbox.Event) int {
+	if screen.mode != 0 && screen.mode != modeBrowse {
+		// Modals and the command line are keyboard only
+		return BrowserScreenIndex
+	}
+	inRightPane := screen.rightPane.contains(event.MouseX, event.MouseY)
+	switch event.Key {
+	case termbox.MouseLeft:
+		if event.Mod&termbox.ModMotion != 0 {
+			if screen.draggingDivider {
+				screen.dragDivider(event.MouseX, event.MouseY)
+			}
+			return BrowserScreenIndex
+		}
+		if screen.divider.contains(event.MouseX, event.MouseY) {
+			screen.draggingDivider = true
+		} else if screen.leftPane.contains(event.MouseX, event.MouseY) {
+			screen.clickTreeRow(event.MouseX, event.MouseY)
+		}
+
+	case termbox.MouseRelease:
+		screen.draggingDivider = false
+
+	case termbox.MouseWheelUp:
+		for i := 0; i < mouseWheelRows; i++ {
+			if inRightPane {
+				screen.moveRightPaneUp()
+			} else {
+				screen.moveCursorUp()
+			}
+		}
+
+	case termbox.MouseWheelDown:
+		for i := 0; i < mouseWheelRows; i++ {
+			if inRightPane {
+				screen.moveRightPaneDown()
+			} else {
+				screen.moveCursorDown()
+			}
+		}
+	}
+	return BrowserScreenIndex
+}
+
+/*
+clickTreeRow selects whatever is drawn at row y of the tree,
+clicking the +/- marker of a bucket opens or closes it
+*/
+func (screen *BrowserScreen) clickTreeRow(x, y int) {
+	row := y - screen.leftPane.y
+	if y < screen.leftPane.y || row < 0 || row >= len(screen.treeRows) {
+		return
+	}
+	path := screen.treeRows[row].path
+	screen.currentPath = path
+	if b, _ := screen.db.getBucketFromPath(path); b != nil {
+		markerX := screen.leftPane.x + treeIndent(len(path))
+		if x >= markerX && x <= markerX+1 {
+			screen.db.toggleOpenBucket(path)
+		}
+	}
+}
 
 func (screen *BrowserScreen) drawScreen(style Style) {
 	if screen.db == nil {
@@ -501,15 +840,22 @@ func (screen *BrowserScreen) drawScreen(style Style) {
 	if screen.message == "" {
 		screen.setMessageWithTimeout("Press '?' for help", -1)
 	}
-	screen.drawLeftPane(style)
-	screen.drawRightPane(style)
+	if len(screen.currentPath) == 0 {
+		screen.currentPath = screen.db.getNextVisiblePath(nil)
+	}
+	if screen.mode == modePreview {
+		screen.drawPreview(style)
+	} else {
+		screen.drawLeftPane(style)
+		screen.drawRightPane(style)
+	}
 	screen.drawHeader(style)
 	screen.drawFooter(style)
 
 	if screen.inputModal != nil {
 		screen.inputModal.Draw()
 	}
-	if screen.mode == modeDelete {
+	if screen.mode == modeDelete || screen.mode == modeExportConfirm {
 		screen.confirmModal.Draw()
 	}
 }
@@ -524,185 +870,241 @@ func (screen *BrowserScreen) drawFooter(style Style) {
 	if screen.messageTimeout > 0 && time.Since(screen.messageTime) > screen.messageTimeout {
 		screen.clearMessage()
 	}
-	_, height := termbox.Size()
-	termboxUtil.DrawStringAtPoint(screen.message, 0, height-1, style.defaultFg, style.defaultBg)
+	width, height := termbox.Size()
+	termboxUtil.FillWithChar(' ', 0, height-1, width, height-1, style.footerFg, style.footerBg)
+	if screen.mode == modeCommand {
+		screen.drawCommandLine(style)
+		return
+	}
+	termboxUtil.DrawStringAtPoint(screen.message, 0, height-1, style.footerFg, style.footerBg)
 }
-func (screen *BrowserScreen) drawLeftPane(style Style) {
-	w, h := termbox.Size()
-	if w > 80 {
-		w = w / 2
+func (screen *BrowserScreen) drawCommandLine(style Style) {
+	width, height := termbox.Size()
+	if len(screen.completions) > 0 {
+		// Show what tab could complete to just above the prompt
+		termboxUtil.FillWithChar(' ', 0, height-2, width, height-2, style.footerFg, style.footerBg)
+		termboxUtil.DrawStringAtPoint(strings.Join(screen.completions, "  "), 0, height-2, style.footerFg, style.footerBg)
+	}
+	runes := []rune(screen.queuedCommand)
+	// Scroll the line if the cursor would be off screen
+	offset := 0
+	if screen.commandCursor+2 > width {
+		offset = screen.commandCursor + 2 - width
 	}
-	screen.viewPort.numberOfRows = h - 2
+	termbox.SetCell(0, height-1, ':', style.footerFg, style.footerBg)
+	for i := offset; i <= len(runes); i++ {
+		ch, fg, bg := ' ', style.footerFg, style.footerBg
+		if i < len(runes) {
+			ch = runes[i]
+		}
+		if i == screen.commandCursor {
+			fg, bg = style.cursorFg, style.cursorBg
+		}
+		termbox.SetCell(i-offset+1, height-1, ch, fg, bg)
+	}
+}
 
+func (screen *BrowserScreen) drawLeftPane(style Style) {
+	w, _ := termbox.Size()
 	termboxUtil.FillWithChar('=', 0, 1, w, 1, style.defaultFg, style.defaultBg)
-	y := 2
-	screen.viewPort.firstRow = y
-	if len(screen.currentPath) == 0 {
-		screen.currentPath = screen.db.getNextVisiblePath(nil)
+	screen.treeRows = screen.treeRows[:0]
+	if screen.mode == modeResults {
+		screen.drawResults(style)
+		return
+	}
+	if screen.leftPane.empty() {
+		return
 	}
 
-	// So we know how much of the tree _wants_ to be visible
-	// we only have screen.viewPort.numberOfRows of space though
-	curPathSpot := 0
-	visPaths, err := screen.db.buildVisiblePathSlice()
-	if err == nil {
-		for idx, pth := range visPaths {
-			isCurPath := true
-			for i := range pth {
-				if len(screen.currentPath) > i && pth[i] != screen.currentPath[i] {
-					isCurPath = false
-					break
-				}
-			}
-			if isCurPath {
-				curPathSpot = idx
-			}
+	// Only the rows around the cursor are drawn, start far
+	// enough above it to keep it in the top two thirds
+	visRows := screen.db.visibleRows()
+	top := screen.db.findVisibleRow(screen.currentPath)
+	if top < 0 {
+		top = 0
+	}
+	maxCursor := screen.leftPane.h * 2 / 3
+	for above := 0; top > 0; top-- {
+		above += screen.treeRowHeight(visRows[top-1])
+		if above > maxCursor {
+			break
 		}
 	}
 
-	treeOffset := 0
-	maxCursor := screen.viewPort.numberOfRows * 2 / 3
-	if curPathSpot > maxCursor {
-		treeOffset = curPathSpot - maxCursor
+	for i := top; i < len(visRows) && len(screen.treeRows) < screen.leftPane.h; i++ {
+		screen.addVisibleRow(visRows[i], style)
 	}
-
-	for i := range screen.db.buckets {
-		// The drawBucket function returns how many lines it took up
-		bktH := screen.drawBucket(&screen.db.buckets[i], style, (y - treeOffset))
-		y += bktH
+	for i, row := range screen.treeRows {
+		if i >= screen.leftPane.h {
+			break
+		}
+		drawClipped(row.text, screen.leftPane.x, screen.leftPane.y+i, screen.leftPane, row.fg, row.bg)
 	}
 }
+
 func (screen *BrowserScreen) drawRightPane(style Style) {
-	w, h := termbox.Size()
-	if w > 80 {
-		// Screen is wide enough, split it
-		termboxUtil.FillWithChar('=', 0, 1, w, 1, style.defaultFg, style.defaultBg)
-		termboxUtil.FillWithChar('|', (w / 2), screen.viewPort.firstRow-1, (w / 2), h, style.defaultFg, style.defaultBg)
+	if !screen.divider.empty() {
+		divCh := '|'
+		if screen.divider.h == 1 {
+			divCh = '='
+		}
+		termboxUtil.FillWithChar(divCh, screen.divider.x, screen.divider.y, screen.divider.x+screen.divider.w-1, screen.divider.y+screen.divider.h-1, style.defaultFg, style.defaultBg)
+	}
+	if !screen.rightPane.empty() {
+		pane := screen.rightPane
 		// Clear the right pane
-		termboxUtil.FillWithChar(' ', (w/2)+1, screen.viewPort.firstRow, w, h, style.defaultFg, style.defaultBg)
+		termboxUtil.FillWithChar(' ', pane.x, pane.y, pane.x+pane.w-1, pane.y+pane.h-1, style.defaultFg, style.defaultBg)
 
+		if !comparePaths(screen.rightPanePath, screen.currentPath) {
+			// Start at the top for each new item
+			screen.rightPanePath = append([]string{}, screen.currentPath...)
+			screen.rightPaneCursor = 0
+			screen.rightPaneColumn = 0
+		}
+		maxW := pane.w - 1
+		var lines []string
+		var lineFgs [][]termbox.Attribute
+		addColoured := func(text string, fgs []termbox.Attribute, indent int) {
+			width := 0
+			if screen.wrapDetails {
+				width = maxW
+			}
+			l, f := wrapColoured(text, fgs, width, indent)
+			lines, lineFgs = append(lines, l...), append(lineFgs, f...)
+		}
+		addText := func(text string, indent int) {
+			addColoured(text, nil, indent)
+		}
 		b, p, err := screen.db.getGenericFromPath(screen.currentPath)
-		startX := (w / 2) + 2
-		startY := 2
 		if err == nil {
 			if b != nil {
-				pathString := fmt.Sprintf("Path: %s", strings.Join(b.GetPath(), " → "))
-				startY += screen.drawMultilineText(pathString, 6, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
-				bucketString := fmt.Sprintf("Buckets: %d", len(b.buckets))
-				startY += screen.drawMultilineText(bucketString, 9, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
-				pairsString := fmt.Sprintf("Pairs: %d", len(b.pairs))
-				startY += screen.drawMultilineText(pairsString, 7, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
+				addText(fmt.Sprintf("Path: %s", strings.Join(b.GetPath(), " → ")), 6)
+				addText(fmt.Sprintf("Buckets: %d", len(b.buckets)), 9)
+				addText(fmt.Sprintf("Pairs: %d", len(b.pairs)), 7)
+				addText(fmt.Sprintf("Sequence: %d", b.sequence), 10)
+				if filter := screen.getFilter(b.GetPath()); filter != "" {
+					addText(fmt.Sprintf("Filter: %s", filter), 8)
+				}
 			} else if p != nil {
-				pathString := fmt.Sprintf("Path: %s", strings.Join(p.GetPath(), " → "))
-				startY += screen.drawMultilineText(pathString, 6, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
-				keyString := fmt.Sprintf("Key: %s", stringify([]byte(p.key)))
-				startY += screen.drawMultilineText(keyString, 5, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
-				valString := fmt.Sprintf("Value: %s", stringify([]byte(p.val)))
-				startY += screen.drawMultilineText(valString, 7, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
+				addText(fmt.Sprintf("Path: %s", strings.Join(p.GetPath(), " → ")), 6)
+				addText(fmt.Sprintf("Key: %s", stringify([]byte(p.key))), 5)
+				decName := screen.getDecoderName(p.parent.GetPath())
+				val, decErr := decodeValue(decName, []byte(p.val))
+				valLabel := "Value: "
+				if decName != defaultDecoderName {
+					valLabel = fmt.Sprintf("Value (%s): ", decName)
+				}
+				if decErr != nil {
+					addText(decErr.Error(), 0)
+				}
+				if strings.Contains(val, "\n") {
+					// Multi-line values start on their own line
+					valLabel = strings.TrimSpace(valLabel) + "\n"
+				}
+				if decErr == nil && isJSONValue(decName, val) {
+					labelFgs := make([]termbox.Attribute, utf8.RuneCountInString(valLabel))
+					addColoured(valLabel+val, append(labelFgs, jsonColours(val, style)...), len(valLabel))
+				} else {
+					addText(valLabel+val, len(valLabel))
+				}
 			}
 		} else {
-			pathString := fmt.Sprintf("Path: %s", strings.Join(screen.currentPath, " → "))
-			startY += screen.drawMultilineText(pathString, 6, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
-			startY += screen.drawMultilineText(err.Error(), 6, startX, startY, (w/2)-1, style.defaultFg, style.defaultBg)
+			addText(fmt.Sprintf("Path: %s", strings.Join(screen.currentPath, " → ")), 6)
+			addText(err.Error(), 6)
+		}
+
+		// Keep the scroll position inside what there is to see
+		screen.rightPaneHeight = len(lines)
+		if screen.rightPaneCursor > len(lines)-pane.h {
+			screen.rightPaneCursor = len(lines) - pane.h
+		}
+		if screen.rightPaneCursor < 0 {
+			screen.rightPaneCursor = 0
+		}
+		screen.rightPaneWidth = 0
+		for _, line := range lines {
+			if lw := textWidth(line); lw > screen.rightPaneWidth {
+				screen.rightPaneWidth = lw
+			}
+		}
+		if screen.wrapDetails || screen.rightPaneColumn > screen.rightPaneWidth-maxW {
+			screen.rightPaneColumn = screen.rightPaneWidth - maxW
+		}
+		if screen.rightPaneColumn < 0 {
+			screen.rightPaneColumn = 0
+		}
+
+		textArea := Rect{x: pane.x + 1, y: pane.y, w: maxW, h: pane.h}
+		for i := 0; i < pane.h && screen.rightPaneCursor+i < len(lines); i++ {
+			line, fgs := sliceColoured(lines[screen.rightPaneCursor+i], lineFgs[screen.rightPaneCursor+i], screen.rightPaneColumn)
+			drawClippedColoured(line, fgs, textArea.x, textArea.y+i, textArea, style.defaultFg, style.defaultBg)
 		}
 	}
 }
 
-/* drawBucket
- * @bkt *BoltBucket - The bucket to draw
- * @style Style - The style to use
- * @w int - The Width of the lines
- * @y int - The Y position to start drawing
- * return - The number of lines used
- */
-func (screen *BrowserScreen) drawBucket(bkt *BoltBucket, style Style, y int) int {
-	w, _ := termbox.Size()
-	if w > 80 {
-		w = w / 2
-	}
-	usedLines := 0
-	bucketFg := style.defaultFg
-	bucketBg := style.defaultBg
-	if comparePaths(screen.currentPath, bkt.GetPath()) {
-		bucketFg = style.cursorFg
-		bucketBg = style.cursorBg
-	}
+/*
+TreeRow is one line of the tree. An item takes up more than
+one row when wrapTree is on and it doesn't fit in the pane
+*/
+type TreeRow struct {
+	path   []string
+	text   string
+	fg, bg termbox.Attribute
+}
 
-	prefixSpaces := strings.Repeat(" ", len(bkt.GetPath())*2)
-	bktString := prefixSpaces
-	prefixSpaces = prefixSpaces + "  "
+// treeIndent is where the tree starts an item with a path 'pathLen' long (a bucket's +/- marker)
+func treeIndent(pathLen int) int {
+	return pathLen * 2
+}
 
-	padAmt := (len(bkt.GetPath())*2 + 2)
-	if bkt.expanded {
-		bktString = bktString + "- " + bkt.name
-		if len(bktString)+padAmt > w {
-			bktString = bktString[:w-padAmt-3] + "..."
+// treeRowText is what the tree shows for 'row', and how far to indent it when it wraps
+func treeRowText(row VisibleRow) (string, int) {
+	prefixSpaces := strings.Repeat(" ", treeIndent(row.depth()+1))
+	if row.bucket != nil {
+		marker := "+ "
+		if row.bucket.expanded {
+			marker = "- "
 		}
-		usedLines = screen.drawMultilineText(bktString, (len(bkt.GetPath())*2 + 2), 0, y, (w - 1), bucketFg, bucketBg)
+		return prefixSpaces + marker + row.bucket.name, len(prefixSpaces) + 2
+	}
+	return fmt.Sprintf("%s%s: %s", prefixSpaces, stringify([]byte(row.pair.key)), stringify([]byte(row.pair.val))), len(prefixSpaces) + 2
+}
 
-		for i := range bkt.buckets {
-			usedLines += screen.drawBucket(&bkt.buckets[i], style, y+usedLines)
-		}
-		for i := range bkt.pairs {
-			usedLines += screen.drawPair(&bkt.pairs[i], style, y+usedLines)
-		}
-	} else {
-		bktString = bktString + "+ " + bkt.name
-		if len(bktString)+padAmt > w {
-			bktString = bktString[:w-padAmt-3] + "..."
-		}
-		usedLines = screen.drawMultilineText(bktString, (len(bkt.GetPath())*2 + 2), 0, y, (w - 1), bucketFg, bucketBg)
+// treeRowHeight is how many lines 'row' takes up in the tree
+func (screen *BrowserScreen) treeRowHeight(row VisibleRow) int {
+	if !screen.wrapTree {
+		return 1
 	}
-	return usedLines
+	text, indent := treeRowText(row)
+	return len(wrapText(text, screen.leftPane.w, indent))
 }
 
-func (screen *BrowserScreen) drawPair(bp *BoltPair, style Style, y int) int {
-	w, _ := termbox.Size()
-	if w > 80 {
-		w = w / 2
-	}
-	usedLines := 0
-	bucketFg := style.defaultFg
-	bucketBg := style.defaultBg
-	if comparePaths(screen.currentPath, bp.GetPath()) {
-		bucketFg = style.cursorFg
-		bucketBg = style.cursorBg
-	}
-
-	prefixSpaces := strings.Repeat(" ", len(bp.GetPath())*2)
-	pairString := prefixSpaces
-	pairString = fmt.Sprintf("%s%s: %s", pairString, stringify([]byte(bp.key)), stringify([]byte(bp.val)))
-	if len(pairString) > w {
-	}
-	prefixSpaces = prefixSpaces + "  "
-	if len(pairString) > w {
-		// The long pair strings are causing a problem, for now, truncate
-		pairString = pairString[:w-3] + "..."
-	}
-	/* TODO: Re-enable this when I figure out the display issue
-		// Long pair string, wrap it
-		// We're going to try to wrap it at the :, if we can
-		if len(bp.GetPath())*2+len(bp.key)+1 > w {
-			// We can't... So just wrap it
-			usedLines = screen.drawMultilineText(pairString, (len(bp.GetPath()) * 2), 0, y, (w - 1), style.defaultFg, style.defaultBg)
-		} else {
-			// That's convenient, wrap at the :
-			pairString := strings.Repeat(" ", len(bp.GetPath())*2)
-			pairString = fmt.Sprintf("%s%s:", pairString, bp.key)
-			termboxUtil.DrawStringAtPoint(pairString, 0, y, bucketFg, bucketBg)
-			usedLines++
-			pairString = strings.Repeat(" ", len(bp.GetPath())*2+2) + bp.val
-			usedLines += screen.drawMultilineText(pairString, (len(bp.GetPath())*2)+2, 0, y+1, (w - 1), bucketFg, bucketBg)
+/*
+addVisibleRow adds the lines for one item to screen.treeRows, truncating or
+wrapping it to the width of the tree and padding it so the cursor fills the line
+*/
+func (screen *BrowserScreen) addVisibleRow(row VisibleRow, style Style) {
+	fg, bg := style.pairFg, style.pairBg
+	if row.bucket != nil {
+		fg, bg = style.bucketFg, style.bucketBg
+	}
+	if row.matches(screen.currentPath) {
+		fg, bg = style.cursorFg, style.cursorBg
+	}
+
+	w := screen.leftPane.w
+	text, indent := treeRowText(row)
+	lines := []string{truncateText(text, w)}
+	if screen.wrapTree {
+		lines = wrapText(text, w, indent)
+	}
+	path := row.path()
+	for _, line := range lines {
+		if pad := w - textWidth(line); pad > 0 {
+			line += strings.Repeat(" ", pad)
 		}
-	} else {
-	*/
-	if w-len(pairString) > 0 {
-		pairString = fmt.Sprintf("%s%s", pairString, strings.Repeat(" ", (w-len(pairString))))
+		screen.treeRows = append(screen.treeRows, TreeRow{path: path, text: line, fg: fg, bg: bg})
 	}
-	termboxUtil.DrawStringAtPoint(pairString, 0, y, bucketFg, bucketBg)
-	usedLines = 1
-	// }
-	return usedLines
 }
 
 func (screen *BrowserScreen) startDeleteItem() bool {
@@ -711,7 +1113,7 @@ func (screen *BrowserScreen) startDeleteItem() bool {
 		w, h := termbox.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateConfirmModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateConfirmModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		if b != nil {
 			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Delete Bucket '%s'?", b.name), inpW-1, termboxUtil.AlignCenter))
 		} else if p != nil {
@@ -732,7 +1134,7 @@ func (screen *BrowserScreen) startEditItem() bool {
 		w, h := termbox.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		if p != nil {
 			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Input new value for '%s'", p.key), inpW, termboxUtil.AlignCenter))
 			mod.SetValue(p.val)
@@ -751,7 +1153,7 @@ func (screen *BrowserScreen) startRenameItem() bool {
 		w, h := termbox.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		if b != nil {
 			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Rename Bucket '%s' to:", b.name), inpW, termboxUtil.AlignCenter))
 			mod.SetValue(b.name)
@@ -767,6 +1169,59 @@ func (screen *BrowserScreen) startRenameItem() bool {
 	return false
 }
 
+func (screen *BrowserScreen) startEditSequence() bool {
+	b, _, e := screen.db.getGenericFromPath(screen.currentPath)
+	if e == nil && b != nil {
+		w, h := termbox.Size()
+		inpW, inpH := (w / 2), 6
+		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+		mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Set sequence of '%s' to:", b.name), inpW, termboxUtil.AlignCenter))
+		mod.SetValue(strconv.FormatUint(b.sequence, 10))
+		mod.Show()
+		screen.inputModal = mod
+		screen.mode = modeChangeSeq
+		return true
+	}
+	screen.setMessage("Only buckets have sequences")
+	return false
+}
+
+func (screen *BrowserScreen) startCommand() {
+	screen.mode = modeCommand
+	screen.queuedCommand = ""
+	screen.commandCursor = 0
+	screen.completions = nil
+	screen.history.reset()
+}
+
+func (screen *BrowserScreen) stopCommand() {
+	screen.mode = modeBrowse
+	screen.queuedCommand = ""
+	screen.commandCursor = 0
+	screen.completions = nil
+}
+
+/*
+getDecoderName returns the decoder set for the bucket at 'path',
+or the closest parent bucket that has one
+*/
+func (screen *BrowserScreen) getDecoderName(path []string) string {
+	for i := len(path); i >= 0; i-- {
+		if name, ok := screen.decoders[statePathKey(path[:i])]; ok {
+			return name
+		}
+	}
+	return defaultDecoderName
+}
+
+func (screen *BrowserScreen) setDecoderName(path []string, name string) {
+	if screen.decoders == nil {
+		screen.decoders = make(map[string]string)
+	}
+	screen.decoders[statePathKey(path)] = name
+}
+
 func (screen *BrowserScreen) startInsertItemAtParent(tp BoltType) bool {
 	w, h := termbox.Size()
 	inpW, inpH := w-1, 7
@@ -774,7 +1229,7 @@ func (screen *BrowserScreen) startInsertItemAtParent(tp BoltType) bool {
 		inpW, inpH = (w / 2), 7
 	}
 	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 	screen.inputModal = mod
 	if len(screen.currentPath) <= 0 {
 		// in the root directory
@@ -825,7 +1280,7 @@ func (screen *BrowserScreen) startInsertItem(tp BoltType) bool {
 		inpW, inpH = (w / 2), 7
 	}
 	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 	//mod.SetInputWrap(true)
 	screen.inputModal = mod
 	var insPath string
@@ -866,7 +1321,7 @@ func (screen *BrowserScreen) startExportValue() bool {
 		w, h := termbox.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export value of '%s' to:", p.key), inpW, termboxUtil.AlignCenter))
 		mod.SetValue("")
 		mod.Show()
@@ -878,20 +1333,58 @@ func (screen *BrowserScreen) startExportValue() bool {
 	return false
 }
 
+/*
+startCSV asks for a file to export the current bucket to as CSV,
+or to import into it (CSV, YAML or TOML), depending on 'mode'
+*/
+func (screen *BrowserScreen) startCSV(mode BrowserMode) bool {
+	path := screen.currentBucketPath()
+	if mode == modeImport && AppArgs.ReadOnly {
+		screen.setMessage("DB is in Read-Only Mode")
+		return false
+	}
+	if len(path) == 0 && mode == modeExportCSV {
+		screen.setMessage("Nothing to export")
+		return false
+	}
+	name := "/"
+	if len(path) > 0 {
+		name = path[len(path)-1]
+	}
+	w, h := termbox.Size()
+	inpW, inpH := (w / 2), 6
+	inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
+	mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
+	if mode == modeExportCSV {
+		mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export '%s' as CSV to:", name), inpW, termboxUtil.AlignCenter))
+		mod.SetText(termboxUtil.AlignText("file [key=enc] [value=enc] [recursive]", inpW, termboxUtil.AlignCenter))
+	} else {
+		mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Import into '%s' from:", name), inpW, termboxUtil.AlignCenter))
+		mod.SetText(termboxUtil.AlignText("file.yaml, file.toml or file.csv [key=enc] [value=enc]", inpW, termboxUtil.AlignCenter))
+	}
+	mod.SetValue("")
+	mod.Show()
+	screen.inputModal = mod
+	screen.mode = mode
+	return true
+}
+
 func (screen *BrowserScreen) startExportJSON() bool {
 	b, p, e := screen.db.getGenericFromPath(screen.currentPath)
 	if e == nil {
 		w, h := termbox.Size()
 		inpW, inpH := (w / 2), 6
 		inpX, inpY := ((w / 2) - (inpW / 2)), ((h / 2) - inpH)
-		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, termbox.ColorWhite, termbox.ColorBlack)
+		mod := termboxUtil.CreateInputModal("", inpX, inpY, inpW, inpH, screen.style.modalFg, screen.style.modalBg)
 		if b != nil {
-			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export JSON of '%s' to:", b.name), inpW, termboxUtil.AlignCenter))
+			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export '%s' to:", b.name), inpW, termboxUtil.AlignCenter))
 			mod.SetValue("")
 		} else if p != nil {
-			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export JSON of '%s' to:", p.key), inpW, termboxUtil.AlignCenter))
+			mod.SetTitle(termboxUtil.AlignText(fmt.Sprintf("Export '%s' to:", p.key), inpW, termboxUtil.AlignCenter))
 			mod.SetValue("")
 		}
+		// The file name picks the format
+		mod.SetText(termboxUtil.AlignText("file.json, file.yaml or file.toml", inpW, termboxUtil.AlignCenter))
 		mod.Show()
 		screen.inputModal = mod
 		screen.mode = modeExportJSON
@@ -909,20 +1402,11 @@ func (screen *BrowserScreen) startExportJSON() bool {
 // fg, bg - Colors
 // Returns the number of lines used
 func (screen *BrowserScreen) drawMultilineText(msg string, indentPadding, startX, startY, maxWidth int, fg, bg termbox.Attribute) int {
-	var numLines int
-	spacePadding := strings.Repeat(" ", indentPadding)
-	// First we need to split 'msg' into the lines it should have (split on '\n')
-	msgs := strings.Split(msg, "\n")
-	for _, msg = range msgs {
-		for len(msg) > maxWidth {
-			termboxUtil.DrawStringAtPoint(msg[:maxWidth-1], startX, (startY + numLines), fg, bg)
-			msg = spacePadding + msg[maxWidth-1:]
-			numLines++
-		}
+	lines := wrapText(msg, maxWidth, indentPadding)
+	for i, line := range lines {
+		termboxUtil.DrawStringAtPoint(line, startX, (startY + i), fg, bg)
 	}
-	termboxUtil.DrawStringAtPoint(msg, startX, (startY + numLines), fg, bg)
-	numLines++
-	return numLines
+	return len(lines)
 }
 
 func (screen *BrowserScreen) setMessage(msg string) {
@@ -948,9 +1432,16 @@ func (screen *BrowserScreen) clearMessage() {
 func (screen *BrowserScreen) refreshDatabase() {
 	shadowDB := screen.db
 	screen.db = screen.db.refreshDatabase()
+	screen.useDisplayOptions()
 	screen.db.syncOpenBuckets(shadowDB)
 }
 
+// useDisplayOptions has the tree use our sort orders and filters
+func (screen *BrowserScreen) useDisplayOptions() {
+	screen.db.visible.order = screen.bucketOrder
+	screen.db.visible.filter = screen.bucketFilter
+}
+
 func comparePaths(p1, p2 []string) bool {
 	return strings.Join(p1, " → ") == strings.Join(p2, " → ")
 }
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+/*
+Scripts are Starlark (a small dialect of Python) run inside one
+transaction. They get:
+
+	root, current          the root and the selected bucket (or the bucket path given to `run`)
+	bucket(*names)         the bucket at a path from the root, or None
+	create_bucket(*names)  the bucket at a path, made if it isn't there
+	dry_run                True when nothing is going to be written
+	json                   encode, decode and indent
+	encode_uint64(n)       n as 8 big-endian bytes, decode_uint64(b) goes back
+
+and a bucket has name, path, get(k), put(k, v), delete(k), bucket(name),
+create_bucket(name), delete_bucket(name), buckets(), keys(prefix),
+items(prefix), cursor(), sequence(), next_sequence() and set_sequence(n).
+A cursor has first(), last(), next(), prev() and seek(k), which return a
+(key, value) tuple, with None for the value of a bucket, or None at the end.
+Keys and values can be given as str or bytes and come back as bytes
+*/
+var scriptFileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+	Recursion:       true,
+}
+
+// Longer values are cut short in the list of changes
+const scriptValueLimit = 60
+
+/*
+:run stops scripts after this long, the browser can't do anything while
+they run and they hold the write lock. `bolt run` has no limit (^C works)
+*/
+const scriptTimeout = 10 * time.Second
+
+/*
+ScriptRun is one script running in a transaction, changes is
+everything it did to the database as '-' and '+' lines
+*/
+type ScriptRun struct {
+	tx      *bolt.Tx
+	changes []DiffLine
+	writes  int
+}
+
+/*
+runScript runs 'src' in 'tx' with 'current' as the selected bucket. What
+it prints goes to 'out'. Whether anything is written is up to the caller,
+committing or rolling back 'tx'. It's stopped after 'timeout', if it isn't 0
+*/
+func runScript(tx *bolt.Tx, fileName string, src []byte, current []string, dryRun bool, timeout time.Duration, out io.Writer) (*ScriptRun, error) {
+	run := &ScriptRun{tx: tx}
+	root := &scriptBucket{run: run, b: tx.Cursor().Bucket()}
+	cur := root
+	if len(current) > 0 {
+		b := getBoltBucket(tx, current)
+		if b == nil {
+			return nil, fmt.Errorf("No bucket %s", formatQueryPath(current))
+		}
+		cur = &scriptBucket{run: run, b: b, path: current}
+	}
+	predeclared := starlark.StringDict{
+		"root":          root,
+		"current":       cur,
+		"dry_run":       starlark.Bool(dryRun),
+		"json":          json.Module,
+		"bucket":        starlark.NewBuiltin("bucket", root.walk(false)),
+		"create_bucket": starlark.NewBuiltin("create_bucket", root.walk(true)),
+		"encode_uint64": starlark.NewBuiltin("encode_uint64", scriptEncodeUint64),
+		"decode_uint64": starlark.NewBuiltin("decode_uint64", scriptDecodeUint64),
+	}
+	thread := &starlark.Thread{
+		Name: fileName,
+		Print: func(_ *starlark.Thread, msg string) {
+			fmt.Fprintln(out, msg)
+		},
+	}
+	var timedOut int32
+	if timeout > 0 {
+		t := time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			thread.Cancel("timed out")
+		})
+		defer t.Stop()
+	}
+	_, err := starlark.ExecFileOptions(scriptFileOptions, thread, fileName, src, predeclared)
+	if err != nil && atomic.LoadInt32(&timedOut) == 1 {
+		// Where it was when it was stopped isn't worth much
+		return run, fmt.Errorf("%s: stopped after %s, use `%s run` for longer scripts", fileName, timeout, ProgramName)
+	}
+	if e, ok := err.(*starlark.EvalError); ok {
+		// Just the line in the script, the whole backtrace doesn't fit on a line
+		for i := range e.CallStack {
+			if pos := e.CallStack.At(i).Pos; pos.Filename() != "<builtin>" {
+				return run, fmt.Errorf("%s: %s", pos, e.Msg)
+			}
+		}
+	}
+	return run, err
+}
+
+func (run *ScriptRun) change(kind byte, path []string, what string) {
+	run.changes = append(run.changes, DiffLine{kind, formatQueryPath(path) + what})
+}
+
+// scriptValue is a value for the list of changes
+func scriptValue(v []byte) string {
+	s := stringify(v)
+	if utf8.RuneCountInString(s) > scriptValueLimit {
+		s = string([]rune(s)[:scriptValueLimit]) + "..."
+	}
+	return " = " + s
+}
+
+// scriptWriteError explains why a write failed, bolt's read-only error doesn't say much
+func scriptWriteError(err error) error {
+	if err == bolt.ErrTxNotWritable {
+		return errors.New("DB is in Read-Only Mode")
+	}
+	return err
+}
+
+func scriptBytes(v starlark.Value) ([]byte, error) {
+	switch t := v.(type) {
+	case starlark.String:
+		return []byte(t), nil
+	case starlark.Bytes:
+		return []byte(t), nil
+	}
+	return nil, fmt.Errorf("want str or bytes, got %s", v.Type())
+}
+
+// scriptPair is a (key, value) tuple, value is None for a bucket
+func scriptPair(k, v []byte) starlark.Value {
+	if k == nil {
+		return starlark.None
+	}
+	if v == nil {
+		return starlark.Tuple{starlark.Bytes(k), starlark.None}
+	}
+	return starlark.Tuple{starlark.Bytes(k), starlark.Bytes(v)}
+}
+
+func scriptEncodeUint64(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n starlark.Int
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &n); err != nil {
+		return nil, err
+	}
+	u, ok := n.Uint64()
+	if !ok {
+		return nil, fmt.Errorf("%s: %s doesn't fit in a uint64", fn.Name(), n)
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, u)
+	return starlark.Bytes(b), nil
+}
+
+func scriptDecodeUint64(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+	b, err := scriptBytes(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), err)
+	}
+	if len(b) != 8 {
+		return nil, fmt.Errorf("%s: %s is %d bytes, not a uint64", fn.Name(), stringify(b), len(b))
+	}
+	return starlark.MakeUint64(binary.BigEndian.Uint64(b)), nil
+}
+
+/*
+scriptBucket is a bucket as a Starlark value, the root
+bucket has an empty path (and can only hold buckets)
+*/
+type scriptBucket struct {
+	run  *ScriptRun
+	b    *bolt.Bucket
+	path []string
+}
+
+type scriptMethod func(sb *scriptBucket, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+var scriptBucketMethods = map[string]scriptMethod{
+	"get":           (*scriptBucket).get,
+	"put":           (*scriptBucket).put,
+	"delete":        (*scriptBucket).delete,
+	"bucket":        (*scriptBucket).bucket,
+	"create_bucket": (*scriptBucket).createBucket,
+	"delete_bucket": (*scriptBucket).deleteBucket,
+	"buckets":       (*scriptBucket).buckets,
+	"keys":          (*scriptBucket).keys,
+	"items":         (*scriptBucket).items,
+	"cursor":        (*scriptBucket).cursor,
+	"sequence":      (*scriptBucket).sequence,
+	"next_sequence": (*scriptBucket).nextSequence,
+	"set_sequence":  (*scriptBucket).setSequence,
+}
+
+func (sb *scriptBucket) String() string {
+	return fmt.Sprintf("<bucket %q>", formatQueryPath(sb.path))
+}
+func (sb *scriptBucket) Type() string          { return "bucket" }
+func (sb *scriptBucket) Freeze()               {}
+func (sb *scriptBucket) Truth() starlark.Bool  { return starlark.True }
+func (sb *scriptBucket) Hash() (uint32, error) { return 0, errors.New("unhashable: bucket") }
+
+func (sb *scriptBucket) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "name":
+		if len(sb.path) == 0 {
+			return starlark.String(""), nil
+		}
+		return starlark.String(sb.path[len(sb.path)-1]), nil
+	case "path":
+		var l []starlark.Value
+		for _, p := range sb.path {
+			l = append(l, starlark.String(p))
+		}
+		return starlark.NewList(l), nil
+	}
+	m, ok := scriptBucketMethods[name]
+	if !ok {
+		return nil, nil
+	}
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		return m(sb, fn, args, kwargs)
+	}).BindReceiver(sb), nil
+}
+
+func (sb *scriptBucket) AttrNames() []string {
+	names := []string{"name", "path"}
+	for name := range scriptBucketMethods {
+		names = append(names, name)
+	}
+	return names
+}
+
+// oneKey unpacks the single key argument most methods take
+func (sb *scriptBucket) oneKey(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) ([]byte, error) {
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+	k, err := scriptBytes(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), err)
+	}
+	return k, nil
+}
+
+func (sb *scriptBucket) child(name []byte) starlark.Value {
+	b := sb.b.Bucket(name)
+	if b == nil {
+		return starlark.None
+	}
+	return &scriptBucket{run: sb.run, b: b, path: appendPath(sb.path, string(name))}
+}
+
+func (sb *scriptBucket) get(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	k, err := sb.oneKey(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if v := sb.b.Get(k); v != nil {
+		return starlark.Bytes(v), nil
+	}
+	return starlark.None, nil
+}
+
+func (sb *scriptBucket) put(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var kv, vv starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &kv, &vv); err != nil {
+		return nil, err
+	}
+	k, err := scriptBytes(kv)
+	if err == nil {
+		var v []byte
+		if v, err = scriptBytes(vv); err == nil {
+			err = sb.putPair(k, v)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), err)
+	}
+	return starlark.None, nil
+}
+
+func (sb *scriptBucket) putPair(k, v []byte) error {
+	if len(sb.path) == 0 {
+		return errors.New("pairs have to go in a bucket")
+	}
+	old := sb.b.Get(k)
+	if err := sb.b.Put(k, v); err != nil {
+		return scriptWriteError(err)
+	}
+	path := appendPath(sb.path, string(k))
+	if old != nil {
+		sb.run.change('-', path, scriptValue(old))
+	}
+	sb.run.change('+', path, scriptValue(v))
+	sb.run.writes++
+	return nil
+}
+
+func (sb *scriptBucket) delete(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	k, err := sb.oneKey(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	old := sb.b.Get(k)
+	if old == nil {
+		// Not there, or a bucket (which delete_bucket is for)
+		return starlark.False, nil
+	}
+	if err = sb.b.Delete(k); err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), scriptWriteError(err))
+	}
+	sb.run.change('-', appendPath(sb.path, string(k)), scriptValue(old))
+	sb.run.writes++
+	return starlark.True, nil
+}
+
+func (sb *scriptBucket) bucket(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	k, err := sb.oneKey(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return sb.child(k), nil
+}
+
+func (sb *scriptBucket) createBucket(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	k, err := sb.oneKey(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if b := sb.child(k); b != starlark.None {
+		return b, nil
+	}
+	if _, err = sb.b.CreateBucket(k); err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), scriptWriteError(err))
+	}
+	sb.run.change('+', appendPath(sb.path, string(k)), "/")
+	sb.run.writes++
+	return sb.child(k), nil
+}
+
+func (sb *scriptBucket) deleteBucket(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	k, err := sb.oneKey(fn, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if sb.b.Bucket(k) == nil {
+		return starlark.False, nil
+	}
+	if err = sb.b.DeleteBucket(k); err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), scriptWriteError(err))
+	}
+	sb.run.change('-', appendPath(sb.path, string(k)), "/")
+	sb.run.writes++
+	return starlark.True, nil
+}
+
+func (sb *scriptBucket) buckets(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	var names []starlark.Value
+	err := sb.b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			names = append(names, starlark.Bytes(k))
+		}
+		return nil
+	})
+	return starlark.NewList(names), err
+}
+
+// eachPair calls fn for the pairs (not buckets) whose keys start with the optional prefix argument
+func (sb *scriptBucket) eachPair(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, each func(k, v []byte)) error {
+	var pv starlark.Value = starlark.String("")
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "prefix?", &pv); err != nil {
+		return err
+	}
+	prefix, err := scriptBytes(pv)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fn.Name(), err)
+	}
+	c := sb.b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, v = c.Next() {
+		if v != nil {
+			each(k, v)
+		}
+	}
+	return nil
+}
+
+func (sb *scriptBucket) keys(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var l []starlark.Value
+	err := sb.eachPair(fn, args, kwargs, func(k, v []byte) {
+		l = append(l, starlark.Bytes(k))
+	})
+	return starlark.NewList(l), err
+}
+
+func (sb *scriptBucket) items(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var l []starlark.Value
+	err := sb.eachPair(fn, args, kwargs, func(k, v []byte) {
+		l = append(l, scriptPair(k, v))
+	})
+	return starlark.NewList(l), err
+}
+
+func (sb *scriptBucket) cursor(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return &scriptCursor{c: sb.b.Cursor()}, nil
+}
+
+func (sb *scriptBucket) sequence(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return starlark.MakeUint64(sb.b.Sequence()), nil
+}
+
+func (sb *scriptBucket) nextSequence(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	old := sb.b.Sequence()
+	seq, err := sb.b.NextSequence()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), scriptWriteError(err))
+	}
+	sb.sequenceChanged(old, seq)
+	return starlark.MakeUint64(seq), nil
+}
+
+func (sb *scriptBucket) setSequence(fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n starlark.Int
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &n); err != nil {
+		return nil, err
+	}
+	seq, ok := n.Uint64()
+	if !ok {
+		return nil, fmt.Errorf("%s: %s isn't a sequence", fn.Name(), n)
+	}
+	old := sb.b.Sequence()
+	if err := sb.b.SetSequence(seq); err != nil {
+		return nil, fmt.Errorf("%s: %s", fn.Name(), scriptWriteError(err))
+	}
+	sb.sequenceChanged(old, seq)
+	return starlark.None, nil
+}
+
+func (sb *scriptBucket) sequenceChanged(old, seq uint64) {
+	sb.run.change('-', sb.path, fmt.Sprintf(" sequence %d", old))
+	sb.run.change('+', sb.path, fmt.Sprintf(" sequence %d", seq))
+	sb.run.writes++
+}
+
+// walk is bucket() and create_bucket(), they follow names from the root
+func (sb *scriptBucket) walk(create bool) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if len(kwargs) > 0 || len(args) == 0 {
+			return nil, fmt.Errorf("%s: takes the bucket names in the path", fn.Name())
+		}
+		var cur starlark.Value = sb
+		for _, a := range args {
+			var err error
+			if create {
+				cur, err = cur.(*scriptBucket).createBucket(fn, starlark.Tuple{a}, nil)
+			} else {
+				cur, err = cur.(*scriptBucket).bucket(fn, starlark.Tuple{a}, nil)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if cur == starlark.None {
+				return cur, nil
+			}
+		}
+		return cur, nil
+	}
+}
+
+// scriptCursor is a bolt cursor as a Starlark value
+type scriptCursor struct {
+	c *bolt.Cursor
+}
+
+func (sc *scriptCursor) String() string        { return "<cursor>" }
+func (sc *scriptCursor) Type() string          { return "cursor" }
+func (sc *scriptCursor) Freeze()               {}
+func (sc *scriptCursor) Truth() starlark.Bool  { return starlark.True }
+func (sc *scriptCursor) Hash() (uint32, error) { return 0, errors.New("unhashable: cursor") }
+
+func (sc *scriptCursor) Attr(name string) (starlark.Value, error) {
+	var move func() ([]byte, []byte)
+	switch name {
+	case "first":
+		move = sc.c.First
+	case "last":
+		move = sc.c.Last
+	case "next":
+		move = sc.c.Next
+	case "prev":
+		move = sc.c.Prev
+	case "seek":
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			var v starlark.Value
+			if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &v); err != nil {
+				return nil, err
+			}
+			k, err := scriptBytes(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", fn.Name(), err)
+			}
+			return scriptPair(sc.c.Seek(k)), nil
+		}), nil
+	default:
+		return nil, nil
+	}
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+			return nil, err
+		}
+		return scriptPair(move()), nil
+	}), nil
+}
+
+func (sc *scriptCursor) AttrNames() []string {
+	return []string{"first", "last", "next", "prev", "seek"}
+}
+
+// runRun is the 'run' subcommand
+func runRun(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New("Usage: " + ProgramName + " run [-dry-run] <filename> <script.star> [bucket path]")
+	}
+	src, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+	var current []string
+	if len(args) == 3 {
+		if current, err = parseBucketPath(args[2]); err != nil {
+			return err
+		}
+	}
+	dbFile, extracted, err := extractDatabase(args[0])
+	if err != nil {
+		return err
+	}
+	if extracted && !AppArgs.DryRun {
+		return fmt.Errorf("changes to %s would be thrown away, use -dry-run", args[0])
+	}
+	var bdb *bolt.DB
+	if AppArgs.ReadOnly {
+		// Scripts can still read, writing is an error
+		bdb, err = openForBackup(dbFile)
+	} else {
+		bdb, err = bolt.Open(dbFile, 0600, &bolt.Options{Timeout: AppArgs.DBOpenTimeout})
+	}
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	tx, err := bdb.Begin(!AppArgs.ReadOnly)
+	if err != nil {
+		return err
+	}
+	run, err := runScript(tx, args[1], src, current, AppArgs.DryRun, 0, os.Stdout)
+	if err != nil || AppArgs.DryRun || AppArgs.ReadOnly {
+		tx.Rollback()
+	} else {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+	if AppArgs.DryRun {
+		fmt.Fprintf(os.Stderr, "Dry run, nothing was written. It would make %d changes:\n", run.writes)
+		for _, l := range run.changes {
+			fmt.Fprintf(os.Stderr, "%c %s\n", l.kind, l.text)
+		}
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Made %d changes\n", run.writes)
+	return nil
+}
+
+/*
+runRunCommand is :run, it runs a script on the current bucket and shows
+what it printed and would change. The transaction stays open until the
+preview is closed, 'y' commits it and anything else rolls it back
+*/
+func runRunCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.args) != 1 {
+		return BrowserScreenIndex, errors.New("Usage: run <script.star>")
+	}
+	fileName := expandHome(cmd.args[0])
+	src, err := os.ReadFile(fileName)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	var out strings.Builder
+	var run *ScriptRun
+	current := screen.currentBucketPath()
+	if AppArgs.ReadOnly {
+		// The script can still look around, writes are errors
+		err = viewDatabase(func(tx *bolt.Tx) error {
+			var err error
+			run, err = runScript(tx, fileName, src, current, false, scriptTimeout, &out)
+			return err
+		})
+		if err != nil {
+			return BrowserScreenIndex, err
+		}
+		screen.showScriptResult(out.String(), run, nil, nil)
+		return BrowserScreenIndex, nil
+	}
+	tx, err := db.Begin(true)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	if run, err = runScript(tx, fileName, src, current, false, scriptTimeout, &out); err != nil {
+		tx.Rollback()
+		return BrowserScreenIndex, err
+	}
+	if run.writes == 0 {
+		tx.Rollback()
+		screen.showScriptResult(out.String(), run, nil, nil)
+		return BrowserScreenIndex, nil
+	}
+	screen.showScriptResult(out.String(), run, func() (string, error) {
+		if err := tx.Commit(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Made %d changes", run.writes), nil
+	}, func() {
+		tx.Rollback()
+	})
+	return BrowserScreenIndex, nil
+}
+
+func (screen *BrowserScreen) showScriptResult(output string, run *ScriptRun, commit func() (string, error), cancel func()) {
+	var lines []DiffLine
+	if output != "" {
+		lines = append(lines, DiffLine{'#', "Output"})
+		for _, l := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+			lines = append(lines, DiffLine{' ', l})
+		}
+	}
+	if run.writes == 0 {
+		if len(lines) == 0 {
+			screen.setMessage("The script made no changes")
+		} else {
+			screen.showPreview(lines, "The script made no changes - n closes", nil, nil)
+		}
+		return
+	}
+	lines = append(lines, DiffLine{'#', "Changes"})
+	lines = append(lines, run.changes...)
+	screen.showPreview(lines, fmt.Sprintf("The script makes %d changes - y writes them, n cancels", run.writes), commit, cancel)
+}
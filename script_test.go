@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestRunScript(t *testing.T) {
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "script.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	if err = bdb.Update(fillServeTestDB); err != nil {
+		t.Fatal(err)
+	}
+
+	errDryRun := errors.New("dry run")
+	// A migration: ids into a new bucket keyed by number, the old pairs go
+	src := `
+new = create_bucket("users", "by_id")
+for k, v in current.items("u"):
+    n = json.decode(str(v))["id"]
+    new.put(encode_uint64(n), k)
+    current.delete(k)
+print("moved", len(new.keys()), "of", len(current.keys()) + len(new.keys()))
+c = current.cursor()
+print(c.first(), c.next(), c.next())
+root.bucket("users").set_sequence(root.bucket("users").next_sequence() + 1)
+print(decode_uint64(new.cursor().last()[0]), bucket("nope", "x"), dry_run)
+`
+	run := func(dryRun bool) (*ScriptRun, string, error) {
+		var out bytes.Buffer
+		var run *ScriptRun
+		err := bdb.Update(func(tx *bolt.Tx) error {
+			var err error
+			run, err = runScript(tx, "migrate.star", []byte(src), []string{"users", "active"}, dryRun, 0, &out)
+			if err == nil && dryRun {
+				return errDryRun
+			}
+			return err
+		})
+		if err == errDryRun {
+			err = nil
+		}
+		return run, out.String(), err
+	}
+
+	r, out, err := run(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOut := "moved 5 of 6\n(b\"x\", b\"other\") None None\n4 None True\n"
+	if out != wantOut {
+		t.Errorf("got output %q, want %q", out, wantOut)
+	}
+	// 1 bucket, 5 puts, 5 deletes and 2 sequence changes
+	if r.writes != 13 {
+		t.Errorf("got %d writes, want 13", r.writes)
+	}
+	var changes []string
+	for _, l := range r.changes {
+		changes = append(changes, string(l.kind)+" "+l.text)
+	}
+	for _, want := range []string{
+		"+ users/by_id/",
+		"+ users/by_id/3 = u3",
+		`- users/active/u3 = {"id":3}`,
+		"- users sequence 7",
+		"+ users sequence 8",
+		"+ users sequence 9",
+	} {
+		if !strings.Contains(strings.Join(changes, "\n")+"\n", want+"\n") {
+			t.Errorf("no %q in the changes:\n%s", want, strings.Join(changes, "\n"))
+		}
+	}
+	// The dry run didn't write anything
+	bdb.View(func(tx *bolt.Tx) error {
+		if getBoltBucket(tx, []string{"users", "by_id"}) != nil || tx.Bucket([]byte("users")).Sequence() != 7 {
+			t.Error("the dry run was written")
+		}
+		return nil
+	})
+
+	if _, _, err = run(false); err != nil {
+		t.Fatal(err)
+	}
+	bdb.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("users")).Bucket([]byte("by_id")).Stats().KeyN; n != 5 {
+			t.Errorf("by_id has %d pairs, want 5", n)
+		}
+		return nil
+	})
+
+	// Errors say where they are, writes fail in a read-only transaction
+	for _, c := range []struct {
+		src, want string
+	}{
+		{"root.put('k', 'v')", "pairs have to go in a bucket"},
+		{"x = 1\ncurrent.get(1)", "bad.star:2:12: get: want str or bytes, got int"},
+		{"encode_uint64(-1)", "-1 doesn't fit in a uint64"},
+		{"current.put('k', 'v')", "DB is in Read-Only Mode"},
+	} {
+		err := bdb.View(func(tx *bolt.Tx) error {
+			_, err := runScript(tx, "bad.star", []byte(c.src), []string{"nums"}, false, 0, &bytes.Buffer{})
+			return err
+		})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", c.src, err, c.want)
+		}
+	}
+	// Scripts that don't stop are stopped
+	err = bdb.View(func(tx *bolt.Tx) error {
+		_, err := runScript(tx, "loop.star", []byte("while True:\n    pass"), nil, false, 50*time.Millisecond, &bytes.Buffer{})
+		return err
+	})
+	if err == nil || !strings.Contains(err.Error(), "stopped after 50ms") {
+		t.Errorf("got %v from a script that doesn't stop", err)
+	}
+	bdb.View(func(tx *bolt.Tx) error {
+		if _, err := runScript(tx, "x.star", nil, []string{"nope"}, false, 0, &bytes.Buffer{}); err == nil {
+			t.Error("no error for a bucket that isn't there")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// How many keys :scan loads at a time, more are loaded as the cursor gets near the end
+const scanPageSize = 200
+
+/*
+KeyScan is where a :scan has got to, the results
+view asks it for more as the cursor moves down
+*/
+type KeyScan struct {
+	bucket []string
+	prefix []byte
+	// The last key loaded, the next page starts after it
+	last []byte
+	done bool
+}
+
+/*
+parseSeekValue turns what was typed at the seek prompt into a key.
+"text" is always a string, 0x0a0b is hex and 42 is a big-endian uint64,
+anything else is taken as it is
+*/
+func parseSeekValue(text string) ([]byte, error) {
+	tokens, err := tokenizeQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		lit, err := parseQueryLiteral(tokens[0])
+		if err == nil && (lit.kind == "string" || lit.kind == "hex" || lit.raw != nil) {
+			return lit.raw, nil
+		}
+		if err != nil && !tokens[0].quoted && strings.HasPrefix(tokens[0].text, "0x") {
+			return nil, err
+		}
+	}
+	return []byte(text), nil
+}
+
+// seekBucket calls 'fn' with a cursor on the bucket at 'path', or on the root
+func seekBucket(tx *bolt.Tx, path []string, fn func(c *bolt.Cursor) error) error {
+	if len(path) == 0 {
+		return fn(tx.Cursor())
+	}
+	b := getBoltBucket(tx, path)
+	if b == nil {
+		return fmt.Errorf("No such bucket %s", formatQueryPath(path))
+	}
+	return fn(b.Cursor())
+}
+
+// startSeek opens the command line ready for a seek
+func (screen *BrowserScreen) startSeek() {
+	screen.startCommand()
+	screen.queuedCommand = "seek "
+	screen.commandCursor = len(screen.queuedCommand)
+}
+
+/*
+runSeekCommand moves the cursor to the first key in the
+current bucket that is the same as or after the one given
+*/
+func runSeekCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	if len(cmd.rest) == 0 {
+		return BrowserScreenIndex, errors.New("Usage: seek <key>, e.g. seek 2024-03, seek 0x0a or seek 42")
+	}
+	want, err := parseSeekValue(cmd.rest[0])
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	bktPath := screen.currentBucketPath()
+	// The key needs to be in the tree to go to it
+	screen.refreshDatabase()
+	var found []byte
+	err = viewDatabase(func(tx *bolt.Tx) error {
+		return seekBucket(tx, bktPath, func(c *bolt.Cursor) error {
+			if k, _ := c.Seek(want); k != nil {
+				found = append([]byte{}, k...)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	if found == nil {
+		return BrowserScreenIndex, fmt.Errorf("Nothing at or after %s", stringify(want))
+	}
+	path := appendPath(bktPath, string(found))
+	if err := screen.jumpToPath(path); err != nil {
+		return BrowserScreenIndex, err
+	}
+	if screen.db.findVisibleRow(path) < 0 {
+		screen.setMessage(fmt.Sprintf("%s is hidden by the filter", formatQueryPath(path)))
+	} else if !bytes.Equal(found, want) {
+		screen.setMessage(fmt.Sprintf("No %s, went to the next key", stringify(want)))
+	}
+	return BrowserScreenIndex, nil
+}
+
+/*
+runScanCommand lists the keys in the current bucket that start with a prefix,
+in place of the tree like :query. They're loaded a page at a time so
+scanning a big time-series bucket doesn't read all of it
+*/
+func runScanCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	var prefix []byte
+	if len(cmd.rest) > 0 {
+		var err error
+		if prefix, err = parseSeekValue(cmd.rest[0]); err != nil {
+			return BrowserScreenIndex, err
+		}
+	}
+	scan := &KeyScan{bucket: screen.currentBucketPath(), prefix: prefix}
+	screen.refreshDatabase()
+	results, err := scan.next(scanPageSize)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	if len(results) == 0 {
+		screen.setMessage(fmt.Sprintf("Nothing in %s starts with %s", formatQueryPath(scan.bucket), stringify(prefix)))
+		return BrowserScreenIndex, nil
+	}
+	screen.results = results
+	screen.resultsQuery = "scan " + stringify(prefix)
+	screen.resultCursor = 0
+	screen.scan = scan
+	screen.showResults()
+	screen.setMessageWithTimeout(screen.scanMessage()+" - enter goes to one, esc goes back", -1)
+	return BrowserScreenIndex, nil
+}
+
+// next reads up to 'count' more keys with the prefix
+func (scan *KeyScan) next(count int) ([]QueryResult, error) {
+	var results []QueryResult
+	if scan.done {
+		return nil, nil
+	}
+	err := viewDatabase(func(tx *bolt.Tx) error {
+		return seekBucket(tx, scan.bucket, func(c *bolt.Cursor) error {
+			var k, v []byte
+			if scan.last == nil {
+				k, v = c.Seek(scan.prefix)
+			} else if k, v = c.Seek(scan.last); bytes.Equal(k, scan.last) {
+				k, v = c.Next()
+			}
+			for ; k != nil && bytes.HasPrefix(k, scan.prefix); k, v = c.Next() {
+				if len(results) == count {
+					scan.last = []byte(results[len(results)-1].path[len(scan.bucket)])
+					return nil
+				}
+				r := QueryResult{path: appendPath(scan.bucket, string(k))}
+				// Buckets are left with a nil value
+				if v != nil {
+					r.value = append([]byte{}, v...)
+				}
+				results = append(results, r)
+			}
+			scan.done = true
+			return nil
+		})
+	})
+	return results, err
+}
+
+// scanMore loads pages until there are at least 'want' results or the scan is done
+func (screen *BrowserScreen) scanMore(want int) {
+	for len(screen.results) < want && !screen.scan.done {
+		more, err := screen.scan.next(scanPageSize)
+		if err != nil {
+			screen.setMessage(err.Error())
+			return
+		}
+		screen.results = append(screen.results, more...)
+	}
+	screen.setMessageWithTimeout(screen.scanMessage(), -1)
+}
+
+func (screen *BrowserScreen) scanMessage() string {
+	if screen.scan.done {
+		return fmt.Sprintf("%d keys start with %s", len(screen.results), stringify(screen.scan.prefix))
+	}
+	return fmt.Sprintf("First %d keys starting with %s, more load as you go down", len(screen.results), stringify(screen.scan.prefix))
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// Only this machine by default, there's no authentication
+	DefaultServeAddr = "localhost:8080"
+	serveLimit       = 100
+	serveMaxLimit    = 10000
+	// The biggest value a PUT takes
+	serveMaxBody = 64 << 20
+)
+
+/*
+APIServer is the REST API run by `bolt serve`. Bucket paths are URL path
+segments under /buckets, and a "keys" segment ends the path:
+
+	GET    /buckets                 top level buckets
+	GET    /buckets/a/b             a bucket's sequence, buckets and pair count
+	PUT    /buckets/a/b             make the bucket (and the ones leading to it)
+	DELETE /buckets/a/b             delete the bucket
+	GET    /buckets/a/b/keys        pairs, with ?prefix=&after=&limit=
+	GET    /buckets/a/b/keys/k      a value, as JSON (?decode= adds a decoder's view) or raw bytes
+	PUT    /buckets/a/b/keys/k      set a value to the request body
+	DELETE /buckets/a/b/keys/k      delete a pair
+
+A bucket or key called "keys", or with a "/" in it, has to be escaped
+(%6Beys, %2F). ?key= and ?value= take the same encodings as CSV files
+(utf8, hex, base64, uint64) for keys and values in the URL and in JSON
+*/
+type APIServer struct {
+	db       *bolt.DB
+	readOnly bool
+}
+
+// apiError is an error with the HTTP status to send for it
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func apiErrorf(status int, format string, args ...interface{}) error {
+	return &apiError{status, fmt.Sprintf(format, args...)}
+}
+
+type apiRequest struct {
+	path     []string
+	hasKeys  bool
+	key      []byte
+	keyEnc   string
+	valueEnc string
+}
+
+type apiPair struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Set when a single value is read with ?decode=
+	Decoded     *string `json:"decoded,omitempty"`
+	DecodeError string  `json:"decode_error,omitempty"`
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := parseAPIRequest(r)
+	if err == nil {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			err = s.get(w, r, req)
+		case http.MethodPut, http.MethodDelete:
+			if s.readOnly {
+				err = apiErrorf(http.StatusForbidden, "DB is in Read-Only Mode")
+			} else if r.Method == http.MethodPut {
+				err = s.put(w, r, req)
+			} else {
+				err = s.delete(w, req)
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			err = apiErrorf(http.StatusMethodNotAllowed, "%s isn't supported", r.Method)
+		}
+	}
+	if err != nil {
+		writeAPIError(w, err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*apiError); ok {
+		status = e.status
+	}
+	writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// parseAPIEncodings reads ?key= and ?value=, which are utf8 when they're not given
+func parseAPIEncodings(q url.Values) (string, string, error) {
+	encs := []string{q.Get("key"), q.Get("value")}
+	for i := range encs {
+		if encs[i] == "" {
+			encs[i] = "utf8"
+		} else if !stringInSlice(encs[i], byteEncodings) {
+			return "", "", apiErrorf(http.StatusBadRequest, "Unknown encoding %q (try %s)", encs[i], strings.Join(byteEncodings, ", "))
+		}
+	}
+	return encs[0], encs[1], nil
+}
+
+func parseAPIRequest(r *http.Request) (*apiRequest, error) {
+	var err error
+	req := &apiRequest{}
+	if req.keyEnc, req.valueEnc, err = parseAPIEncodings(r.URL.Query()); err != nil {
+		return nil, err
+	}
+	segs := strings.Split(strings.TrimSuffix(r.URL.EscapedPath(), "/"), "/")
+	if len(segs) < 2 || segs[0] != "" || segs[1] != "buckets" {
+		return nil, apiErrorf(http.StatusNotFound, "Not found, everything is under /buckets")
+	}
+	for i, seg := range segs[2:] {
+		if seg == "keys" {
+			// Only a literal "keys" ends the path, %6Beys is a bucket
+			rest := segs[i+3:]
+			if len(rest) > 1 || len(req.path) == 0 {
+				return nil, apiErrorf(http.StatusNotFound, "Not found")
+			}
+			req.hasKeys = true
+			if len(rest) == 1 {
+				k, err := url.PathUnescape(rest[0])
+				if err == nil {
+					req.key, err = decodeBytes(req.keyEnc, k)
+				}
+				if err != nil {
+					return nil, apiErrorf(http.StatusBadRequest, "Bad key: %s", err)
+				}
+			}
+			break
+		}
+		name, err := url.PathUnescape(seg)
+		if err != nil || name == "" {
+			return nil, apiErrorf(http.StatusBadRequest, "Bad bucket name %q", seg)
+		}
+		req.path = append(req.path, name)
+	}
+	return req, nil
+}
+
+func (s *APIServer) get(w http.ResponseWriter, r *http.Request, req *apiRequest) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		if len(req.path) == 0 {
+			var names []string
+			err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				n, err := apiEncode(req.keyEnc, name)
+				names = append(names, n)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			return writeAPIJSON(w, http.StatusOK, map[string]interface{}{"buckets": nonNil(names)})
+		}
+		b := getBoltBucket(tx, req.path)
+		if b == nil {
+			return apiErrorf(http.StatusNotFound, "No bucket %s", formatQueryPath(req.path))
+		}
+		switch {
+		case !req.hasKeys:
+			return writeBucketInfo(w, b, req)
+		case req.key == nil:
+			return writePairs(w, r, b, req)
+		}
+		v := b.Get(req.key)
+		if v == nil {
+			if b.Bucket(req.key) != nil {
+				return apiErrorf(http.StatusNotFound, "%s is a bucket", stringify(req.key))
+			}
+			return apiErrorf(http.StatusNotFound, "No key %s", stringify(req.key))
+		}
+		if wantsRaw(r) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Length", strconv.Itoa(len(v)))
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write(v)
+			return err
+		}
+		p, err := apiEncodePair(req, req.key, v)
+		if err != nil {
+			return err
+		}
+		if name := r.URL.Query().Get("decode"); name != "" {
+			if getDecoder(name) == nil {
+				return apiErrorf(http.StatusBadRequest, "Unknown decoder %q (try %s)", name, strings.Join(decoderNames(), ", "))
+			}
+			decoded, err := decodeValue(name, v)
+			p.Decoded = &decoded
+			if err != nil {
+				p.DecodeError = err.Error()
+			}
+		}
+		return writeAPIJSON(w, http.StatusOK, p)
+	})
+}
+
+func writeBucketInfo(w http.ResponseWriter, b *bolt.Bucket, req *apiRequest) error {
+	var names []string
+	pairs := 0
+	err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			pairs++
+			return nil
+		}
+		n, err := apiEncode(req.keyEnc, k)
+		names = append(names, n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+		"path":     req.path,
+		"sequence": b.Sequence(),
+		"buckets":  nonNil(names),
+		"pairs":    pairs,
+	})
+}
+
+/*
+writePairs lists up to ?limit= pairs in the bucket, starting after ?after=
+and only those starting with ?prefix=. When there are more, "next" is the
+?after= for the next page. Sub-buckets are left out
+*/
+func writePairs(w http.ResponseWriter, r *http.Request, b *bolt.Bucket, req *apiRequest) error {
+	q := r.URL.Query()
+	var prefix, after []byte
+	var err error
+	if q.Get("prefix") != "" {
+		if prefix, err = decodeBytes(req.keyEnc, q.Get("prefix")); err != nil {
+			return apiErrorf(http.StatusBadRequest, "Bad prefix: %s", err)
+		}
+	}
+	if q.Get("after") != "" {
+		if after, err = decodeBytes(req.keyEnc, q.Get("after")); err != nil {
+			return apiErrorf(http.StatusBadRequest, "Bad after: %s", err)
+		}
+	}
+	limit := serveLimit
+	if l := q.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > serveMaxLimit {
+			return apiErrorf(http.StatusBadRequest, "limit has to be from 1 to %d", serveMaxLimit)
+		}
+	}
+	c := b.Cursor()
+	k, v := c.Seek(prefix)
+	if after != nil && bytes.Compare(after, prefix) >= 0 {
+		if k, v = c.Seek(after); bytes.Equal(k, after) {
+			k, v = c.Next()
+		}
+	}
+	pairs := []apiPair{}
+	var next interface{}
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if v == nil {
+			continue
+		}
+		if len(pairs) == limit {
+			next = pairs[len(pairs)-1].Key
+			break
+		}
+		p, err := apiEncodePair(req, k, v)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, p)
+	}
+	return writeAPIJSON(w, http.StatusOK, map[string]interface{}{"pairs": pairs, "next": next})
+}
+
+func (s *APIServer) put(w http.ResponseWriter, r *http.Request, req *apiRequest) error {
+	if len(req.path) == 0 {
+		return apiErrorf(http.StatusMethodNotAllowed, "Buckets go at /buckets/<name>")
+	}
+	if req.hasKeys && req.key == nil {
+		return apiErrorf(http.StatusMethodNotAllowed, "Pairs go at /buckets/.../keys/<key>")
+	}
+	var v []byte
+	if req.key != nil {
+		var err error
+		if v, err = io.ReadAll(http.MaxBytesReader(w, r.Body, serveMaxBody)); err != nil {
+			if _, ok := err.(*http.MaxBytesError); ok {
+				return apiErrorf(http.StatusRequestEntityTooLarge, "Values can be up to %d bytes", serveMaxBody)
+			}
+			return apiErrorf(http.StatusBadRequest, "Reading the body: %s", err)
+		}
+	}
+	created := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if req.key == nil {
+			created = getBoltBucket(tx, req.path) == nil
+			b, err := tx.CreateBucketIfNotExists([]byte(req.path[0]))
+			for i := 1; i < len(req.path) && err == nil; i++ {
+				b, err = b.CreateBucketIfNotExists([]byte(req.path[i]))
+			}
+			if err == bolt.ErrIncompatibleValue {
+				return apiErrorf(http.StatusConflict, "%s is in the way of a bucket", formatQueryPath(req.path))
+			}
+			return err
+		}
+		b := getBoltBucket(tx, req.path)
+		if b == nil {
+			return apiErrorf(http.StatusNotFound, "No bucket %s", formatQueryPath(req.path))
+		}
+		if b.Bucket(req.key) != nil {
+			return apiErrorf(http.StatusConflict, "%s is a bucket", stringify(req.key))
+		}
+		created = b.Get(req.key) == nil
+		return b.Put(req.key, v)
+	})
+	if err != nil {
+		return err
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return nil
+}
+
+func (s *APIServer) delete(w http.ResponseWriter, req *apiRequest) error {
+	if len(req.path) == 0 || (req.hasKeys && req.key == nil) {
+		return apiErrorf(http.StatusMethodNotAllowed, "Only a bucket or a key can be deleted")
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if req.key == nil {
+			name := []byte(req.path[len(req.path)-1])
+			var err error
+			if len(req.path) == 1 {
+				err = tx.DeleteBucket(name)
+			} else if parent := getBoltBucket(tx, req.path[:len(req.path)-1]); parent != nil {
+				err = parent.DeleteBucket(name)
+			} else {
+				err = bolt.ErrBucketNotFound
+			}
+			if err == bolt.ErrBucketNotFound || err == bolt.ErrIncompatibleValue {
+				return apiErrorf(http.StatusNotFound, "No bucket %s", formatQueryPath(req.path))
+			}
+			return err
+		}
+		b := getBoltBucket(tx, req.path)
+		if b == nil {
+			return apiErrorf(http.StatusNotFound, "No bucket %s", formatQueryPath(req.path))
+		}
+		if b.Bucket(req.key) != nil {
+			return apiErrorf(http.StatusConflict, "%s is a bucket, delete it at its bucket path", stringify(req.key))
+		}
+		if b.Get(req.key) == nil {
+			return apiErrorf(http.StatusNotFound, "No key %s", stringify(req.key))
+		}
+		return b.Delete(req.key)
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// wantsRaw is true for ?raw or when the client would rather have bytes than JSON
+func wantsRaw(r *http.Request) bool {
+	if _, ok := r.URL.Query()["raw"]; ok {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/octet-stream") && !strings.Contains(accept, "application/json")
+}
+
+// apiEncode is encodeBytes, except it won't let JSON mangle bytes that aren't UTF-8
+func apiEncode(enc string, b []byte) (string, error) {
+	if enc == "utf8" && !utf8.Valid(b) {
+		return "", apiErrorf(http.StatusNotAcceptable, "%s isn't UTF-8, ask for hex or base64", stringify(b))
+	}
+	s, err := encodeBytes(enc, b)
+	if err != nil {
+		return "", apiErrorf(http.StatusNotAcceptable, "%s", err)
+	}
+	return s, nil
+}
+
+func apiEncodePair(req *apiRequest, k, v []byte) (apiPair, error) {
+	var p apiPair
+	var err error
+	if p.Key, err = apiEncode(req.keyEnc, k); err != nil {
+		return p, err
+	}
+	p.Value, err = apiEncode(req.valueEnc, v)
+	return p, err
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// nonNil keeps an empty list from being written as null
+func nonNil(l []string) []string {
+	if l == nil {
+		return []string{}
+	}
+	return l
+}
+
+// runServe is the 'serve' subcommand
+func runServe(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: " + ProgramName + " serve <filename> [-addr=" + DefaultServeAddr + "] [-ro]")
+	}
+	dbFile, extracted, err := extractDatabase(args[0])
+	if err != nil {
+		return err
+	}
+	// Writes to an extracted copy would be thrown away
+	AppArgs.ReadOnly = AppArgs.ReadOnly || extracted
+	var bdb *bolt.DB
+	if AppArgs.ReadOnly {
+		bdb, err = openForBackup(dbFile)
+	} else {
+		bdb, err = openForWrite(dbFile)
+	}
+	if err == bolt.ErrTimeout {
+		return fmt.Errorf("File %s is locked. Make sure it's not used by another app and try again", args[0])
+	} else if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	mode := ""
+	if AppArgs.ReadOnly {
+		mode = " (read-only)"
+	}
+	fmt.Fprintf(os.Stderr, "Serving %s on %s%s\n", args[0], AppArgs.Addr, mode)
+	return http.ListenAndServe(AppArgs.Addr, newServeHandler(bdb, filepath.Base(args[0]), AppArgs.ReadOnly))
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func fillServeTestDB(tx *bolt.Tx) error {
+	users, err := tx.CreateBucket([]byte("users"))
+	if err != nil {
+		return err
+	}
+	if err = users.SetSequence(7); err != nil {
+		return err
+	}
+	active, err := users.CreateBucket([]byte("active"))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 5; i++ {
+		if err = active.Put([]byte(fmt.Sprintf("u%d", i)), []byte(fmt.Sprintf(`{"id":%d}`, i))); err != nil {
+			return err
+		}
+	}
+	if err = active.Put([]byte("x"), []byte("other")); err != nil {
+		return err
+	}
+	if err = users.Put([]byte("bin"), []byte{0, 0xff}); err != nil {
+		return err
+	}
+	// Names that have to be escaped in a URL
+	odd, err := tx.CreateBucket([]byte("a/b"))
+	if err != nil {
+		return err
+	}
+	if _, err = odd.CreateBucket([]byte("keys")); err != nil {
+		return err
+	}
+	nums, err := tx.CreateBucket([]byte("nums"))
+	if err != nil {
+		return err
+	}
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, 1000)
+	return nums.Put(k, []byte("thousand"))
+}
+
+func newServeTest(t *testing.T, readOnly bool) *httptest.Server {
+	t.Helper()
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "serve.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bdb.Update(fillServeTestDB); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(&APIServer{db: bdb, readOnly: readOnly})
+	t.Cleanup(func() {
+		srv.Close()
+		bdb.Close()
+	})
+	return srv
+}
+
+func serveRequest(t *testing.T, srv *httptest.Server, method, path, body string, header ...string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func getServeJSON(t *testing.T, srv *httptest.Server, path string) map[string]interface{} {
+	t.Helper()
+	status, body := serveRequest(t, srv, "GET", path, "")
+	if status != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, status, body)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &m); err != nil {
+		t.Fatalf("GET %s: %s in %q", path, err, body)
+	}
+	return m
+}
+
+func TestServeBuckets(t *testing.T) {
+	srv := newServeTest(t, true)
+	m := getServeJSON(t, srv, "/buckets")
+	if want := []interface{}{"a/b", "nums", "users"}; !reflect.DeepEqual(m["buckets"], want) {
+		t.Errorf("got buckets %v, want %v", m["buckets"], want)
+	}
+	m = getServeJSON(t, srv, "/buckets/users/")
+	if m["sequence"] != 7.0 || m["pairs"] != 1.0 || !reflect.DeepEqual(m["buckets"], []interface{}{"active"}) {
+		t.Errorf("wrong bucket info %v", m)
+	}
+	// %2F is part of a name and %6Beys is a bucket called keys
+	m = getServeJSON(t, srv, "/buckets/a%2Fb/%6Beys")
+	if !reflect.DeepEqual(m["path"], []interface{}{"a/b", "keys"}) || m["pairs"] != 0.0 {
+		t.Errorf("wrong bucket info %v", m)
+	}
+	for _, path := range []string{"/buckets/nope", "/buckets/users/bin", "/buckets/a/b", "/other", "/buckets/keys"} {
+		if status, body := serveRequest(t, srv, "GET", path, ""); status != http.StatusNotFound {
+			t.Errorf("GET %s: got %d %s, want 404", path, status, body)
+		}
+	}
+}
+
+func TestServeGetValue(t *testing.T) {
+	srv := newServeTest(t, true)
+	m := getServeJSON(t, srv, "/buckets/users/active/keys/u1")
+	if m["key"] != "u1" || m["value"] != `{"id":1}` {
+		t.Errorf("got %v", m)
+	}
+	status, body := serveRequest(t, srv, "GET", "/buckets/users/keys/bin?raw", "")
+	if status != http.StatusOK || body != "\x00\xff" {
+		t.Errorf("raw: got %d %q", status, body)
+	}
+	status, body = serveRequest(t, srv, "GET", "/buckets/users/keys/bin", "", "Accept", "application/octet-stream")
+	if status != http.StatusOK || body != "\x00\xff" {
+		t.Errorf("octet-stream: got %d %q", status, body)
+	}
+	// JSON would mangle it, so it has to be asked for in another encoding
+	if status, _ = serveRequest(t, srv, "GET", "/buckets/users/keys/bin", ""); status != http.StatusNotAcceptable {
+		t.Errorf("binary value as utf8: got %d", status)
+	}
+	if m = getServeJSON(t, srv, "/buckets/users/keys/bin?value=hex"); m["value"] != "00ff" {
+		t.Errorf("hex: got %v", m)
+	}
+	if m = getServeJSON(t, srv, "/buckets/nums/keys/1000?key=uint64"); m["key"] != "1000" || m["value"] != "thousand" {
+		t.Errorf("uint64 key: got %v", m)
+	}
+	for path, want := range map[string]int{
+		"/buckets/users/keys/nope":        http.StatusNotFound,
+		"/buckets/users/keys/active":      http.StatusNotFound,
+		"/buckets/nums/keys/x?key=uint64": http.StatusBadRequest,
+		"/buckets/users/keys/bin?key=rot": http.StatusBadRequest,
+	} {
+		if status, body := serveRequest(t, srv, "GET", path, ""); status != want {
+			t.Errorf("GET %s: got %d %s, want %d", path, status, body, want)
+		}
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	srv := newServeTest(t, true)
+	keys := func(m map[string]interface{}) []string {
+		var ks []string
+		for _, p := range m["pairs"].([]interface{}) {
+			ks = append(ks, p.(map[string]interface{})["key"].(string))
+		}
+		return ks
+	}
+	m := getServeJSON(t, srv, "/buckets/users/active/keys")
+	if got := keys(m); len(got) != 6 || m["next"] != nil {
+		t.Errorf("got %v next %v", got, m["next"])
+	}
+	// Paging through with prefix and limit
+	var all []string
+	after := ""
+	for page := 0; page < 5; page++ {
+		m = getServeJSON(t, srv, "/buckets/users/active/keys?prefix=u&limit=2&after="+after)
+		all = append(all, keys(m)...)
+		if m["next"] == nil {
+			break
+		}
+		after = m["next"].(string)
+	}
+	if want := []string{"u0", "u1", "u2", "u3", "u4"}; !reflect.DeepEqual(all, want) {
+		t.Errorf("paged %v, want %v", all, want)
+	}
+	// after before the prefix starts at the prefix, sub-buckets are left out
+	if got := keys(getServeJSON(t, srv, "/buckets/users/active/keys?prefix=x&after=a")); !reflect.DeepEqual(got, []string{"x"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := getServeJSON(t, srv, "/buckets/users/keys?value=base64")["pairs"]; !reflect.DeepEqual(got, []interface{}{map[string]interface{}{"key": "bin", "value": "AP8="}}) {
+		t.Errorf("got %v", got)
+	}
+	if got := keys(getServeJSON(t, srv, "/buckets/nums/keys?key=uint64")); !reflect.DeepEqual(got, []string{"1000"}) {
+		t.Errorf("uint64 keys: got %v", got)
+	}
+	if status, _ := serveRequest(t, srv, "GET", "/buckets/users/keys?limit=0", ""); status != http.StatusBadRequest {
+		t.Errorf("limit=0: got %d", status)
+	}
+}
+
+func TestServeWrite(t *testing.T) {
+	srv := newServeTest(t, false)
+	for _, c := range []struct {
+		method, path, body string
+		want               int
+	}{
+		{"PUT", "/buckets/users/active/keys/u9", "nine", http.StatusCreated},
+		{"PUT", "/buckets/users/active/keys/u9", "NINE", http.StatusNoContent},
+		{"PUT", "/buckets/new/inner", "", http.StatusCreated},
+		{"PUT", "/buckets/new/inner", "", http.StatusNoContent},
+		{"PUT", "/buckets/new/inner/keys/k%2F1", "v", http.StatusCreated},
+		{"PUT", "/buckets/missing/keys/k", "v", http.StatusNotFound},
+		{"PUT", "/buckets/users/keys/active", "v", http.StatusConflict},
+		{"PUT", "/buckets/users/bin", "", http.StatusConflict},
+		{"DELETE", "/buckets/users/active/keys/u0", "", http.StatusNoContent},
+		{"DELETE", "/buckets/users/active/keys/u0", "", http.StatusNotFound},
+		{"DELETE", "/buckets/users/keys/active", "", http.StatusConflict},
+		{"DELETE", "/buckets/nums", "", http.StatusNoContent},
+		{"DELETE", "/buckets/users/bin", "", http.StatusNotFound},
+		{"POST", "/buckets/users", "", http.StatusMethodNotAllowed},
+		{"PUT", "/buckets/users/active/keys/big", strings.Repeat("x", serveMaxBody+1), http.StatusRequestEntityTooLarge},
+	} {
+		if status, body := serveRequest(t, srv, c.method, c.path, c.body); status != c.want {
+			t.Errorf("%s %s: got %d %s, want %d", c.method, c.path, status, body, c.want)
+		}
+	}
+	if m := getServeJSON(t, srv, "/buckets/users/active/keys/u9"); m["value"] != "NINE" {
+		t.Errorf("got %v", m)
+	}
+	if m := getServeJSON(t, srv, "/buckets/new/inner/keys/k%2F1"); m["key"] != "k/1" || m["value"] != "v" {
+		t.Errorf("got %v", m)
+	}
+	if m := getServeJSON(t, srv, "/buckets"); !reflect.DeepEqual(m["buckets"], []interface{}{"a/b", "new", "users"}) {
+		t.Errorf("got %v", m["buckets"])
+	}
+}
+
+func TestServeReadOnly(t *testing.T) {
+	srv := newServeTest(t, true)
+	for _, c := range [][2]string{
+		{"PUT", "/buckets/users/keys/new"},
+		{"PUT", "/buckets/new"},
+		{"DELETE", "/buckets/users/active/keys/u0"},
+		{"DELETE", "/buckets/users"},
+	} {
+		if status, body := serveRequest(t, srv, c[0], c[1], "v"); status != http.StatusForbidden {
+			t.Errorf("%s %s: got %d %s, want 403", c[0], c[1], status, body)
+		}
+	}
+	if m := getServeJSON(t, srv, "/buckets/users/active/keys/u0"); m["value"] != `{"id":0}` {
+		t.Errorf("got %v", m)
+	}
+}
+
+func TestServeMissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "typo.db")
+	if err := runServe([]string{missing}); !os.IsNotExist(err) {
+		t.Errorf("got %v serving a file that isn't there", err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("serving made a new database")
+	}
+}
+
+func TestServeWebUI(t *testing.T) {
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "serve.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	if err = bdb.Update(fillServeTestDB); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newServeHandler(bdb, "serve.db", true))
+	defer srv.Close()
+	for _, path := range []string{"/", "/app.js", "/style.css"} {
+		if status, body := serveRequest(t, srv, "GET", path, ""); status != http.StatusOK || body == "" {
+			t.Errorf("GET %s: got %d", path, status)
+		}
+	}
+	m := getServeJSON(t, srv, "/info")
+	if m["file"] != "serve.db" || m["read_only"] != true {
+		t.Errorf("got info %v", m)
+	}
+	// The API still works through the UI's handler, escapes and all
+	if m = getServeJSON(t, srv, "/buckets/a%2Fb"); !reflect.DeepEqual(m["buckets"], []interface{}{"keys"}) {
+		t.Errorf("got %v", m)
+	}
+	if m = getServeJSON(t, srv, "/buckets/users/active/keys/u2?decode=json"); m["decoded"] != "{\n  \"id\": 2\n}" {
+		t.Errorf("decoded: got %v", m)
+	}
+	if m = getServeJSON(t, srv, "/buckets/users/keys/bin?value=hex&decode=json"); m["decoded"] != "00ff" || m["decode_error"] == nil {
+		t.Errorf("bad json: got %v", m)
+	}
+	if status, _ := serveRequest(t, srv, "GET", "/buckets/users/keys/bin?value=hex&decode=rot13", ""); status != http.StatusBadRequest {
+		t.Errorf("unknown decoder: got %d", status)
+	}
+	m = getServeJSON(t, srv, "/query?limit=2&q="+url.QueryEscape(`users/active where .id >= 1`))
+	want := []interface{}{
+		map[string]interface{}{"path": []interface{}{"users", "active"}, "key": "u1", "value": `{"id":1}`},
+		map[string]interface{}{"path": []interface{}{"users", "active"}, "key": "u2", "value": `{"id":2}`},
+	}
+	if !reflect.DeepEqual(m["results"], want) || m["more"] != true {
+		t.Errorf("query: got %v", m)
+	}
+	if status, _ := serveRequest(t, srv, "GET", "/query?q="+url.QueryEscape("users where"), ""); status != http.StatusBadRequest {
+		t.Errorf("bad query: got %d", status)
+	}
+}
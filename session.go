@@ -0,0 +1,124 @@
+package main
+
+import "strings"
+
+const sessionStateFile = "session.json"
+
+/*
+SessionState is what we remember about where we were in a database,
+so the next time it's opened things are as they were left
+*/
+type SessionState struct {
+	Expanded [][]string        `json:"expanded,omitempty"`
+	Cursor   []string          `json:"cursor,omitempty"`
+	Decoders map[string]string `json:"decoders,omitempty"`
+	Filters  map[string]string `json:"filters,omitempty"`
+}
+
+func collectExpanded(b *BoltBucket, ret [][]string) [][]string {
+	if !b.expanded {
+		return ret
+	}
+	ret = append(ret, b.GetPath())
+	for i := range b.buckets {
+		ret = collectExpanded(&b.buckets[i], ret)
+	}
+	return ret
+}
+
+func (screen *BrowserScreen) saveSession() error {
+	if screen.db == nil {
+		return nil
+	}
+	state := SessionState{
+		Cursor:   screen.currentPath,
+		Decoders: screen.decoders,
+		Filters:  screen.filters,
+	}
+	for i := range screen.db.buckets {
+		state.Expanded = collectExpanded(&screen.db.buckets[i], state.Expanded)
+	}
+	return saveDatabaseState(sessionStateFile, state)
+}
+
+/*
+restoreSession puts back what saveSession saved. Anything about
+buckets that have since gone is dropped, and if the cursor's item
+has gone it goes to the nearest bucket above it that's still there
+*/
+func (screen *BrowserScreen) restoreSession() error {
+	var state SessionState
+	if err := loadDatabaseState(sessionStateFile, &state); err != nil {
+		return err
+	}
+	bucketExists := func(key string) bool {
+		if key == "" {
+			return true
+		}
+		_, err := screen.db.getBucketFromPath(splitStatePath(key))
+		return err == nil
+	}
+	for k, v := range state.Decoders {
+		if bucketExists(k) && getDecoder(v) != nil {
+			screen.setDecoderName(splitStatePath(k), v)
+		}
+	}
+	for k, v := range state.Filters {
+		if bucketExists(k) {
+			screen.setFilter(splitStatePath(k), v)
+		}
+	}
+	for _, path := range state.Expanded {
+		if b, err := screen.db.getBucketFromPath(path); err == nil {
+			b.expanded = true
+		}
+	}
+	screen.db.invalidateVisible()
+	for cursor := state.Cursor; len(cursor) > 0; cursor = cursor[:len(cursor)-1] {
+		if screen.jumpToPath(cursor) == nil {
+			// jumpToPath doesn't check filters
+			if screen.db.findVisibleRow(cursor) < 0 {
+				continue
+			}
+			break
+		}
+	}
+	return nil
+}
+
+var statePathEscaper = strings.NewReplacer(`\`, `\\`, `/`, `\/`)
+
+/*
+statePathKey is the key for per-bucket state (sorts, decoders and filters).
+Names are joined with '/', and a '/' or '\' in a name is escaped with '\'
+so "a/b" and "b" in "a" are different buckets
+*/
+func statePathKey(path []string) string {
+	parts := make([]string, len(path))
+	for i, name := range path {
+		parts[i] = statePathEscaper.Replace(name)
+	}
+	return strings.Join(parts, "/")
+}
+
+// splitStatePath turns a statePathKey back into a path
+func splitStatePath(key string) []string {
+	if key == "" {
+		return nil
+	}
+	var path []string
+	var cur strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch {
+		case key[i] == '\\' && i+1 < len(key):
+			i++
+			cur.WriteByte(key[i])
+		case key[i] == '/':
+			path = append(path, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(key[i])
+		}
+	}
+	return append(path, cur.String())
+}
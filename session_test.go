@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestStatePathKey(t *testing.T) {
+	paths := [][]string{nil, {"a"}, {"a", "b"}, {"a/b"}, {`a\`, "b"}, {`a\/b`}, {"", "x"}}
+	seen := map[string][]string{}
+	for _, p := range paths {
+		key := statePathKey(p)
+		if other, ok := seen[key]; ok {
+			t.Errorf("%q and %q are both %q", p, other, key)
+		}
+		seen[key] = p
+		if got := splitStatePath(key); !reflect.DeepEqual(got, p) {
+			t.Errorf("%q: %q splits back into %q", p, key, got)
+		}
+	}
+
+	// Settings for "a/b" stay with it
+	screen := &BrowserScreen{}
+	screen.setDecoderName([]string{"a/b"}, "hex")
+	if name := screen.getDecoderName([]string{"a", "b"}); name != defaultDecoderName {
+		t.Errorf("a -> b got the decoder for a/b, %s", name)
+	}
+	if name := screen.getDecoderName([]string{"a/b", "c"}); name != "hex" {
+		t.Errorf("a/b -> c got %s, want its parent's hex", name)
+	}
+}
+
+func TestDatabaseStateDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer func(name string) { currentFilename = name }(currentFilename)
+	dirs := func() (string, string) {
+		os.Chdir(t.TempDir())
+		first := databaseStateDir()
+		os.Chdir(t.TempDir())
+		return first, databaseStateDir()
+	}
+	// stdin is the same wherever bolt is run from, files aren't
+	currentFilename = "-"
+	if a, b := dirs(); a != b {
+		t.Errorf("stdin got %s and %s", a, b)
+	}
+	currentFilename = "x.db"
+	if a, b := dirs(); a == b {
+		t.Errorf("x.db in two directories both got %s", a)
+	}
+}
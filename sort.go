@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+SortOptions is how the contents of a bucket are ordered in the tree.
+It only changes what is shown, bolt always keeps keys in byte order
+*/
+type SortOptions struct {
+	// One of sortKeys
+	By      string `json:"by"`
+	Reverse bool   `json:"reverse,omitempty"`
+	// Mix buckets in with the pairs instead of listing them first
+	Mixed bool `json:"mixed,omitempty"`
+}
+
+const (
+	sortByte    = "byte"
+	sortNatural = "natural"
+	sortSize    = "size"
+)
+
+var sortKeys = []string{sortByte, sortNatural, sortSize}
+
+// The words :sort understands, besides the sortKeys
+var sortWords = []string{"reverse", "forward", "mixed", "buckets-first", "default"}
+
+const sortStateFile = "sort.json"
+
+func (o SortOptions) isDefault() bool {
+	return (o.By == "" || o.By == sortByte) && !o.Reverse && !o.Mixed
+}
+
+func (o SortOptions) String() string {
+	by := o.By
+	if by == "" {
+		by = sortByte
+	}
+	ret := []string{by}
+	if o.Reverse {
+		ret = append(ret, "reverse")
+	}
+	if o.Mixed {
+		ret = append(ret, "mixed")
+	}
+	return strings.Join(ret, " ")
+}
+
+/*
+parseSortOptions applies the words from a :sort command to 'o'.
+Words that aren't mentioned keep their current setting
+*/
+func parseSortOptions(o SortOptions, words []string) (SortOptions, error) {
+	for _, w := range words {
+		switch w {
+		case sortByte, sortNatural, sortSize:
+			o.By = w
+		case "reverse":
+			o.Reverse = true
+		case "forward":
+			o.Reverse = false
+		case "mixed":
+			o.Mixed = true
+		case "buckets-first":
+			o.Mixed = false
+		case "default":
+			o = SortOptions{}
+		default:
+			return o, fmt.Errorf("Unknown sort option %s (%s)", w, strings.Join(append(append([]string{}, sortKeys...), sortWords...), ", "))
+		}
+	}
+	return o, nil
+}
+
+// sortEntry is a child of a bucket with what it's sorted on worked out up front
+type sortEntry struct {
+	row  VisibleRow
+	key  string
+	size int
+}
+
+/*
+sortedContents returns the rows directly inside 'b' in the order 'o'
+asks for. Buckets count as empty when sorting by size
+*/
+func sortedContents(b *BoltBucket, o SortOptions) []VisibleRow {
+	var entries []sortEntry
+	for i := range b.buckets {
+		entries = append(entries, sortEntry{row: VisibleRow{bucket: &b.buckets[i]}, key: b.buckets[i].name})
+	}
+	for i := range b.pairs {
+		entries = append(entries, sortEntry{row: VisibleRow{pair: &b.pairs[i]}, key: b.pairs[i].key, size: len(b.pairs[i].val)})
+	}
+	if o.By == sortNatural {
+		// Compare what's shown, so big-endian uint64 keys compare as numbers
+		for i := range entries {
+			entries[i].key = stringify([]byte(entries[i].key))
+		}
+	}
+	less := func(a, b *sortEntry) bool {
+		switch o.By {
+		case sortNatural:
+			if c := naturalCompare(a.key, b.key); c != 0 {
+				return c < 0
+			}
+		case sortSize:
+			if a.size != b.size {
+				return a.size < b.size
+			}
+		}
+		return a.key < b.key
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if !o.Mixed && (a.row.bucket != nil) != (b.row.bucket != nil) {
+			// Buckets first, whichever way round the rest goes
+			return a.row.bucket != nil
+		}
+		if o.Reverse {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+	rows := make([]VisibleRow, len(entries))
+	for i := range entries {
+		rows[i] = entries[i].row
+	}
+	return rows
+}
+
+/*
+naturalCompare compares strings so that runs of digits are compared
+as numbers ("item2" before "item10"), returning -1, 0 or 1
+*/
+func naturalCompare(a, b string) int {
+	for len(a) > 0 && len(b) > 0 {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			da, db := digitRun(a), digitRun(b)
+			na, nb := strings.TrimLeft(da, "0"), strings.TrimLeft(db, "0")
+			if len(na) != len(nb) {
+				return compareInts(len(na), len(nb))
+			}
+			if na != nb {
+				return strings.Compare(na, nb)
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return compareInts(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func digitRun(s string) string {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+/*
+getSortOptions returns the sort order for the bucket at 'path',
+sub-buckets use their parent's unless they have their own
+*/
+func (screen *BrowserScreen) getSortOptions(path []string) SortOptions {
+	for i := len(path); i >= 0; i-- {
+		if o, ok := screen.sorts[statePathKey(path[:i])]; ok {
+			return o
+		}
+	}
+	return SortOptions{}
+}
+
+/*
+setSortOptions changes the order for the bucket at 'path'
+and saves it for next time this database is opened
+*/
+func (screen *BrowserScreen) setSortOptions(path []string, o SortOptions) error {
+	if screen.sorts == nil {
+		screen.sorts = make(map[string]SortOptions)
+	}
+	screen.sorts[statePathKey(path)] = o
+	screen.db.invalidateVisible()
+	return saveDatabaseState(sortStateFile, screen.sorts)
+}
+
+func (screen *BrowserScreen) loadSortOptions() error {
+	return loadDatabaseState(sortStateFile, &screen.sorts)
+}
+
+// bucketOrder is what the visible index uses to order a bucket's contents
+func (screen *BrowserScreen) bucketOrder(b *BoltBucket) SortOptions {
+	return screen.getSortOptions(b.GetPath())
+}
+
+// cycleSort moves the current bucket on to the next sort key
+func (screen *BrowserScreen) cycleSort() error {
+	path := screen.currentBucketPath()
+	if len(path) == 0 {
+		return errors.New("Not in a bucket")
+	}
+	o := screen.getSortOptions(path)
+	for i, k := range sortKeys {
+		if k == o.By || (o.By == "" && k == sortByte) {
+			o.By = sortKeys[(i+1)%len(sortKeys)]
+			break
+		}
+	}
+	if err := screen.setSortOptions(path, o); err != nil {
+		return err
+	}
+	screen.setMessage("Sort: " + o.String())
+	return nil
+}
+
+func runSortCommand(screen *BrowserScreen, cmd *parsedCommand) (int, error) {
+	path := screen.currentBucketPath()
+	if len(path) == 0 {
+		return BrowserScreenIndex, errors.New("Not in a bucket")
+	}
+	o := screen.getSortOptions(path)
+	if len(cmd.args) == 0 {
+		screen.setMessage(fmt.Sprintf("Sort for %s: %s", strings.Join(path, "/"), o))
+		return BrowserScreenIndex, nil
+	}
+	o, err := parseSortOptions(o, cmd.args)
+	if err != nil {
+		return BrowserScreenIndex, err
+	}
+	if err = screen.setSortOptions(path, o); err != nil {
+		return BrowserScreenIndex, err
+	}
+	screen.setMessage(fmt.Sprintf("Sort for %s: %s", strings.Join(path, "/"), o))
+	return BrowserScreenIndex, nil
+}
+
+func completeSort(screen *BrowserScreen, argIdx int, prefix string) []string {
+	return filterPrefix(append(append([]string{}, sortKeys...), sortWords...), prefix)
+}
@@ -0,0 +1,170 @@
+//go:build cgo
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	// The sqlite3 driver for database/sql
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+SQLiteExport copies a bolt database into SQLite as it walks it, so
+nothing bigger than a pair is held in memory. Normally every pair goes in
+one "pairs" table with the path of its bucket, 'flat' gives each bucket a
+table of its own named after its path instead. Either way there's a
+"buckets" table with every bucket's path and sequence, even empty ones
+*/
+type SQLiteExport struct {
+	tx    *sql.Tx
+	flat  bool
+	pairs *sql.Stmt
+	// Table names by lower case name, SQLite doesn't care about case
+	tables map[string]bool
+	count  int
+}
+
+const sqliteSchema = `
+CREATE TABLE buckets (path TEXT PRIMARY KEY, sequence INTEGER);
+CREATE TABLE pairs (path TEXT, key BLOB, value BLOB, key_text TEXT, value_text TEXT);
+`
+
+func quoteSQLName(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// sqliteText is the value as text for value_text, or NULL when it isn't text
+func sqliteText(v []byte) interface{} {
+	if utf8.Valid(v) {
+		return string(v)
+	}
+	return nil
+}
+
+func exportSQLite(btx *bolt.Tx, out *sql.DB, flat bool) (int, error) {
+	tx, err := out.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	exp := &SQLiteExport{tx: tx, flat: flat, tables: map[string]bool{"buckets": true}}
+	schema := sqliteSchema
+	if flat {
+		schema = strings.SplitAfter(schema, ";")[0]
+	}
+	if _, err = tx.Exec(schema); err != nil {
+		return 0, err
+	}
+	if !flat {
+		exp.pairs, err = tx.Prepare("INSERT INTO pairs VALUES (?, ?, ?, ?, ?)")
+		if err != nil {
+			return 0, err
+		}
+		defer exp.pairs.Close()
+	}
+	err = btx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return exp.bucket(b, []string{string(name)})
+	})
+	if err != nil {
+		return exp.count, err
+	}
+	if !flat {
+		if _, err = tx.Exec("CREATE INDEX pairs_path ON pairs (path, key)"); err != nil {
+			return exp.count, err
+		}
+	}
+	return exp.count, tx.Commit()
+}
+
+func (exp *SQLiteExport) bucket(b *bolt.Bucket, path []string) error {
+	p := formatQueryPath(path)
+	if b.Sequence() > math.MaxInt64 {
+		// SQLite's integers are signed
+		return fmt.Errorf("%s: sequence %d is too big for SQLite", p, b.Sequence())
+	}
+	if _, err := exp.tx.Exec("INSERT INTO buckets VALUES (?, ?)", p, int64(b.Sequence())); err != nil {
+		return err
+	}
+	ins := exp.pairs
+	if exp.flat {
+		table := exp.tableName(p)
+		_, err := exp.tx.Exec("CREATE TABLE " + quoteSQLName(table) + " (key BLOB PRIMARY KEY, value BLOB, key_text TEXT, value_text TEXT)")
+		if err != nil {
+			return err
+		}
+		if ins, err = exp.tx.Prepare("INSERT INTO " + quoteSQLName(table) + " VALUES (?, ?, ?, ?)"); err != nil {
+			return err
+		}
+		defer ins.Close()
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return exp.bucket(b.Bucket(k), appendPath(path, string(k)))
+		}
+		args := []interface{}{k, v, stringify(k), sqliteText(v)}
+		if !exp.flat {
+			args = append([]interface{}{p}, args...)
+		}
+		exp.count++
+		_, err := ins.Exec(args...)
+		return err
+	})
+}
+
+/*
+tableName is 'p', or 'p#2' and so on if there's already a table with that
+name. SQLite keeps names starting with sqlite_ for itself, those get a '_'
+*/
+func (exp *SQLiteExport) tableName(p string) string {
+	if strings.HasPrefix(strings.ToLower(p), "sqlite_") {
+		p = "_" + p
+	}
+	name := p
+	for i := 2; exp.tables[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s#%d", p, i)
+	}
+	exp.tables[strings.ToLower(name)] = true
+	return name
+}
+
+// runExportSQLite is the 'export-sqlite' subcommand
+func runExportSQLite(args []string) error {
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[2] != "flat") {
+		return errors.New("Usage: " + ProgramName + " export-sqlite <filename> <output.sqlite> [flat]")
+	}
+	dbFile, outFile := args[0], args[1]
+	if _, err := os.Stat(outFile); err == nil {
+		return fmt.Errorf("%s already exists", outFile)
+	}
+	bdb, err := openForBackup(dbFile)
+	if err != nil {
+		return err
+	}
+	defer bdb.Close()
+	out, err := sql.Open("sqlite3", outFile)
+	if err != nil {
+		return err
+	}
+	var count int
+	err = bdb.View(func(tx *bolt.Tx) error {
+		count, err = exportSQLite(tx, out, len(args) == 3)
+		return err
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(outFile)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d pairs to %s\n", count, outFile)
+	return nil
+}
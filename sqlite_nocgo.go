@@ -0,0 +1,10 @@
+//go:build !cgo
+
+package main
+
+import "errors"
+
+// runExportSQLite needs the sqlite3 driver, which is written in C
+func runExportSQLite(args []string) error {
+	return errors.New("this " + ProgramName + " was built without cgo, which SQLite needs (build it with CGO_ENABLED=1)")
+}
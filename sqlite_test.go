@@ -0,0 +1,63 @@
+//go:build cgo
+
+package main
+
+import (
+	"database/sql"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestExportSQLiteFlat(t *testing.T) {
+	dir := t.TempDir()
+	bdb, err := bolt.Open(filepath.Join(dir, "in.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"sqlite_stat1", "users"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if err = b.Put([]byte("k"), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := func() error {
+		out, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "out.sqlite"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+		return bdb.View(func(tx *bolt.Tx) error {
+			_, err := exportSQLite(tx, out, true)
+			if err == nil {
+				var v string
+				err = out.QueryRow(`SELECT value FROM "_sqlite_stat1"`).Scan(&v)
+			}
+			return err
+		})
+	}
+	if err = export(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sequences SQLite can't hold are an error, not a negative number
+	bdb.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("users")).SetSequence(math.MaxInt64 + 1)
+	})
+	if err = export(); err == nil || !strings.Contains(err.Error(), "too big") {
+		t.Errorf("got %v for a sequence over MaxInt64", err)
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"github.com/boltdb/bolt"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Buckets are exported to YAML and TOML as maps. Values holding a JSON object
+or array are written as nested structures instead of quoted strings, so they
+have to be told apart from buckets: in YAML they're tagged !json and in TOML
+an object is wrapped in a table with the single key "$json". A bucket's
+sequence, when it isn't 0, is a "$sequence" key tagged !sequence in YAML
+and a "$sequence" integer in TOML (pairs are always strings there)
+*/
+const (
+	yamlJSONTag     = "!json"
+	tomlJSONKey     = "$json"
+	yamlSequenceTag = "!sequence"
+	sequenceKey     = "$sequence"
+)
+
+// structuredFormat picks yaml or toml from a file name, "" is anything else
+func structuredFormat(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return ""
+}
+
+// jsonStructure returns the decoded value if 'v' is a JSON object or array
+func jsonStructure(v []byte) (interface{}, bool) {
+	t := bytes.TrimSpace(v)
+	if len(t) == 0 || (t[0] != '{' && t[0] != '[') {
+		return nil, false
+	}
+	d, err := parseJSONNumbers(v)
+	return d, err == nil
+}
+
+/*
+exportStructured writes the bucket at 'path' (or just the pair) as
+YAML or TOML. The top level holds what's in the bucket, not the bucket
+itself, so it can be imported back into any bucket
+*/
+func exportStructured(tx *bolt.Tx, path []string, format string, out io.Writer) error {
+	var b *bolt.Bucket
+	var pairKey []byte
+	if len(path) == 0 {
+		return errors.New("Nothing to export")
+	}
+	if b = getBoltBucket(tx, path); b == nil {
+		// Not a bucket, so a pair on its own
+		pairKey = []byte(path[len(path)-1])
+		if b = getBoltBucket(tx, path[:len(path)-1]); b == nil || b.Get(pairKey) == nil {
+			return fmt.Errorf("No such item %s", formatQueryPath(path))
+		}
+	}
+	if format == "yaml" {
+		var node *yaml.Node
+		var err error
+		if pairKey != nil {
+			node = &yaml.Node{Kind: yaml.MappingNode}
+			err = addYAMLPair(node, pairKey, b.Get(pairKey))
+		} else {
+			node, err = bucketToYAML(b)
+		}
+		if err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(out)
+		enc.SetIndent(2)
+		if err = enc.Encode(node); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	var tree map[string]interface{}
+	var err error
+	if pairKey != nil {
+		tree = make(map[string]interface{})
+		err = addTOMLPair(tree, pairKey, b.Get(pairKey))
+	} else {
+		tree, err = bucketToTOML(b)
+	}
+	if err != nil {
+		return err
+	}
+	return toml.NewEncoder(out).Encode(tree)
+}
+
+func bucketToYAML(b *bolt.Bucket) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	if seq := b.Sequence(); seq != 0 {
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: yamlSequenceTag, Value: sequenceKey},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatUint(seq, 10)})
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return addYAMLPair(node, k, v)
+		}
+		child, err := bucketToYAML(b.Bucket(k))
+		if err != nil {
+			return err
+		}
+		node.Content = append(node.Content, yamlString(k), child)
+		return nil
+	})
+	return node, err
+}
+
+func addYAMLPair(node *yaml.Node, k, v []byte) error {
+	val := yamlString(v)
+	if _, ok := jsonStructure(v); ok {
+		var err error
+		if val, err = jsonToYAML(json.NewDecoder(bytes.NewReader(v))); err != nil {
+			return err
+		}
+		val.Tag = yamlJSONTag
+	}
+	node.Content = append(node.Content, yamlString(k), val)
+	return nil
+}
+
+/*
+jsonToYAML reads the next JSON value from 'dec' as a node, token by token so
+that object keys stay in the order they were written and numbers as they
+were written. Only the spacing is lost
+*/
+func jsonToYAML(dec *json.Decoder) (*yaml.Node, error) {
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		if t == '[' {
+			node.Kind, node.Tag = yaml.SequenceNode, "!!seq"
+		}
+		for dec.More() {
+			if node.Kind == yaml.MappingNode {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k.(string)})
+			}
+			child, err := jsonToYAML(dec)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		// The closing ] or }
+		_, err = dec.Token()
+		return node, err
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: t}, nil
+	case json.Number:
+		tag := "!!int"
+		if strings.ContainsAny(string(t), ".eE") {
+			tag = "!!float"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: string(t)}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(t)}, nil
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+}
+
+// yamlString is a string node, bytes that aren't UTF-8 are written as !!binary
+func yamlString(s []byte) *yaml.Node {
+	if !utf8.Valid(s) {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!binary", Value: base64.StdEncoding.EncodeToString(s)}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(s)}
+}
+
+func bucketToTOML(b *bolt.Bucket) (map[string]interface{}, error) {
+	tree := make(map[string]interface{})
+	if seq := b.Sequence(); seq > math.MaxInt64 {
+		return nil, fmt.Errorf("sequence %d is too big for TOML (try YAML)", seq)
+	} else if seq != 0 {
+		tree[sequenceKey] = int64(seq)
+	}
+	err := b.ForEach(func(k, v []byte) error {
+		if _, ok := tree[sequenceKey]; ok && string(k) == sequenceKey {
+			return fmt.Errorf("%s and the bucket's sequence can't both be written to TOML (try YAML)", sequenceKey)
+		}
+		if v != nil {
+			return addTOMLPair(tree, k, v)
+		}
+		child, err := bucketToTOML(b.Bucket(k))
+		tree[string(k)] = child
+		return err
+	})
+	return tree, err
+}
+
+func addTOMLPair(tree map[string]interface{}, k, v []byte) error {
+	if !utf8.Valid(k) || !utf8.Valid(v) {
+		return fmt.Errorf("%s isn't text, TOML can only hold text (try YAML)", stringify(k))
+	}
+	if d, ok := jsonStructure(v); ok && tomlCanHold(d) {
+		if m, isMap := d.(map[string]interface{}); isMap {
+			tree[string(k)] = map[string]interface{}{tomlJSONKey: m}
+		} else {
+			tree[string(k)] = d
+		}
+		return nil
+	}
+	tree[string(k)] = string(v)
+	return nil
+}
+
+// tomlCanHold checks a JSON value has nothing TOML can't write, like null
+func tomlCanHold(d interface{}) bool {
+	switch t := d.(type) {
+	case nil, *big.Int:
+		return false
+	case map[string]interface{}:
+		for _, v := range t {
+			if !tomlCanHold(v) {
+				return false
+			}
+		}
+	case []interface{}:
+		for _, v := range t {
+			if !tomlCanHold(v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*
+importStructured reads a YAML or TOML file into the bucket at 'path',
+creating buckets for maps and overwriting pairs that are already there
+*/
+func importStructured(tx *bolt.Tx, path []string, format string, in io.Reader) (int, error) {
+	imp := &structuredImport{tx: tx}
+	for _, name := range path {
+		imp.path = append(imp.path, []byte(name))
+	}
+	if format == "yaml" {
+		var doc yaml.Node
+		if err := yaml.NewDecoder(in).Decode(&doc); err != nil {
+			if err == io.EOF {
+				return 0, nil
+			}
+			return 0, err
+		}
+		if len(doc.Content) == 0 {
+			return 0, nil
+		}
+		err := imp.yamlMap(doc.Content[0], imp.path)
+		return imp.count, err
+	}
+	var tree map[string]interface{}
+	if _, err := toml.NewDecoder(in).Decode(&tree); err != nil {
+		return 0, err
+	}
+	err := imp.tomlMap(tree, imp.path)
+	return imp.count, err
+}
+
+type structuredImport struct {
+	tx    *bolt.Tx
+	path  [][]byte
+	count int
+}
+
+// bucket makes (if it needs to) the bucket at 'path'
+func (imp *structuredImport) bucket(path [][]byte) (*bolt.Bucket, error) {
+	if len(path) == 0 {
+		return nil, errors.New("pairs have to go in a bucket")
+	}
+	b, err := imp.tx.CreateBucketIfNotExists(path[0])
+	for i := 1; i < len(path) && err == nil; i++ {
+		b, err = b.CreateBucketIfNotExists(path[i])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", formatDumpPath(path), err)
+	}
+	return b, nil
+}
+
+func (imp *structuredImport) put(path [][]byte, k, v []byte) error {
+	b, err := imp.bucket(path)
+	if err != nil {
+		return err
+	}
+	if b.Bucket(k) != nil {
+		return fmt.Errorf("%s is a bucket", formatDumpPath(append(path, k)))
+	}
+	imp.count++
+	return b.Put(k, v)
+}
+
+func (imp *structuredImport) setSequence(path [][]byte, seq uint64) error {
+	b, err := imp.bucket(path)
+	if err != nil {
+		return err
+	}
+	return b.SetSequence(seq)
+}
+
+func (imp *structuredImport) yamlMap(node *yaml.Node, path [][]byte) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a map of keys", node.Line)
+	}
+	// An empty map is still a bucket
+	if len(path) > 0 {
+		if _, err := imp.bucket(path); err != nil {
+			return err
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, err := yamlBytes(node.Content[i])
+		if err != nil {
+			return err
+		}
+		val := node.Content[i+1]
+		if val.Kind == yaml.AliasNode {
+			val = val.Alias
+		}
+		switch {
+		case node.Content[i].Tag == yamlSequenceTag:
+			var seq uint64
+			if seq, err = strconv.ParseUint(val.Value, 10, 64); err != nil {
+				err = fmt.Errorf("%q isn't a sequence", val.Value)
+			} else {
+				err = imp.setSequence(path, seq)
+			}
+		case val.Kind == yaml.MappingNode && val.Tag != yamlJSONTag:
+			err = imp.yamlMap(val, append(path[:len(path):len(path)], k))
+		case val.Kind == yaml.MappingNode || val.Kind == yaml.SequenceNode:
+			// A JSON value
+			var buf bytes.Buffer
+			if err = yamlToJSON(val, &buf); err == nil {
+				err = imp.put(path, k, buf.Bytes())
+			}
+		default:
+			var v []byte
+			if v, err = yamlBytes(val); err == nil {
+				err = imp.put(path, k, v)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s", val.Line, err)
+		}
+	}
+	return nil
+}
+
+/*
+yamlToJSON writes 'node' to 'buf' as compact JSON, keeping the order of
+maps and numbers as they're written in the YAML
+*/
+func yamlToJSON(node *yaml.Node, buf *bytes.Buffer) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.MappingNode, yaml.SequenceNode:
+		start, end := byte('{'), byte('}')
+		step := 2
+		if node.Kind == yaml.SequenceNode {
+			start, end, step = '[', ']', 1
+		}
+		buf.WriteByte(start)
+		for i := 0; i+step-1 < len(node.Content); i += step {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if step == 2 {
+				k := node.Content[i]
+				if k.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: JSON keys have to be strings", k.Line)
+				}
+				writeJSONString(buf, k.Value)
+				buf.WriteByte(':')
+			}
+			if err := yamlToJSON(node.Content[i+step-1], buf); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(end)
+		return nil
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!str":
+			writeJSONString(buf, node.Value)
+			return nil
+		case "!!int", "!!float":
+			var n json.Number
+			if json.Unmarshal([]byte(node.Value), &n) == nil {
+				// Already a JSON number, so keep it the way it's written
+				buf.WriteString(node.Value)
+				return nil
+			}
+		case "!!null":
+			buf.WriteString("null")
+			return nil
+		}
+		// YAML spellings like 0x1f, 1_000 or yes
+		var d interface{}
+		if err := node.Decode(&d); err != nil {
+			return err
+		}
+		out, err := compactJSON(jsonSafe(d))
+		if err != nil {
+			return fmt.Errorf("line %d: %s", node.Line, err)
+		}
+		buf.Write(out)
+		return nil
+	}
+	return fmt.Errorf("line %d: can't be JSON", node.Line)
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	out, _ := compactJSON(s)
+	buf.Write(out)
+}
+
+// compactJSON is json.Marshal without escaping <, > and &
+func compactJSON(d interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(d); err != nil {
+		return nil, err
+	}
+	// Encode ends with a newline
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// yamlBytes is a scalar as it was written, so 8080 and 1.50 are kept as they are
+func yamlBytes(node *yaml.Node) ([]byte, error) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, fmt.Errorf("line %d: expected a value", node.Line)
+	}
+	switch node.ShortTag() {
+	case "!!binary":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(node.Value), ""))
+	case "!!null":
+		return []byte{}, nil
+	}
+	return []byte(node.Value), nil
+}
+
+func (imp *structuredImport) tomlMap(tree map[string]interface{}, path [][]byte) error {
+	if len(path) > 0 {
+		if _, err := imp.bucket(path); err != nil {
+			return err
+		}
+	}
+	for key, val := range tree {
+		k := []byte(key)
+		if seq, ok := val.(int64); ok && key == sequenceKey {
+			if seq < 0 {
+				return fmt.Errorf("%s: %d isn't a sequence", formatDumpPath(path), seq)
+			}
+			if err := imp.setSequence(path, uint64(seq)); err != nil {
+				return err
+			}
+			continue
+		}
+		var v []byte
+		var err error
+		switch t := val.(type) {
+		case map[string]interface{}:
+			if inner, ok := t[tomlJSONKey]; ok && len(t) == 1 {
+				v, err = compactJSON(inner)
+			} else {
+				err = imp.tomlMap(t, append(path[:len(path):len(path)], k))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		case []map[string]interface{}, []interface{}:
+			v, err = compactJSON(t)
+		case string:
+			v = []byte(t)
+		case int64:
+			v = []byte(strconv.FormatInt(t, 10))
+		case float64:
+			v = []byte(strconv.FormatFloat(t, 'g', -1, 64))
+		case time.Time:
+			v = []byte(t.Format(time.RFC3339Nano))
+		default:
+			v = []byte(fmt.Sprint(t))
+		}
+		if err == nil {
+			err = imp.put(path, k, v)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", formatDumpPath(append(path, k)), err)
+		}
+	}
+	return nil
+}
+
+// runStructuredImport reads a YAML or TOML file into the current bucket
+func (screen *BrowserScreen) runStructuredImport(format, fileName string) error {
+	if AppArgs.ReadOnly {
+		return errors.New("DB is in Read-Only Mode")
+	}
+	f, err := os.Open(expandHome(fileName))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var count int
+	err = db.Update(func(tx *bolt.Tx) error {
+		count, err = importStructured(tx, screen.currentBucketPath(), format, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	screen.refreshDatabase()
+	screen.setMessage(fmt.Sprintf("Imported %d pairs from %s", count, fileName))
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// bucketContents flattens a bucket into path/key -> value, buckets are "/<sequence>"
+func bucketContents(b *bolt.Bucket, prefix string, m map[string]string) map[string]string {
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			m[prefix+string(k)] = fmt.Sprintf("/%d", b.Bucket(k).Sequence())
+			bucketContents(b.Bucket(k), prefix+string(k)+"/", m)
+		} else {
+			m[prefix+string(k)] = string(v)
+		}
+		return nil
+	})
+	return m
+}
+
+func TestStructuredRoundTrip(t *testing.T) {
+	bdb, err := bolt.Open(filepath.Join(t.TempDir(), "structured.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bdb.Close()
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		for _, format := range []string{"yaml", "toml"} {
+			src, err := tx.CreateBucket([]byte(format))
+			if err != nil {
+				return err
+			}
+			if err = src.SetSequence(7); err != nil {
+				return err
+			}
+			for k, v := range map[string]string{
+				"text":   "hello",
+				"port":   "8080",
+				"object": `{"host":"example.com","ports":[80,443]}`,
+				"array":  `[1,"two"]`,
+				// Not JSON, so it stays a string
+				"braces": "{not json",
+				// Keys out of order and numbers written oddly
+				"unsorted": `{"b":1,"a":[2.50,1e3],"c":{"z":"<&>","y":true}}`,
+				"indented": "{\n  \"x\": \"<a&b>\",\n  \"n\": 1.50\n}",
+			} {
+				if err = src.Put([]byte(k), []byte(v)); err != nil {
+					return err
+				}
+			}
+			inner, err := src.CreateBucket([]byte("inner"))
+			if err != nil {
+				return err
+			}
+			if err = inner.Put([]byte("k"), []byte("v")); err != nil {
+				return err
+			}
+			if err = inner.SetSequence(math.MaxInt64); err != nil {
+				return err
+			}
+			if _, err = src.CreateBucket([]byte("empty")); err != nil {
+				return err
+			}
+		}
+		// Only YAML can hold bytes that aren't text, or a pair named like the sequence
+		if err := tx.Bucket([]byte("yaml")).Put([]byte(sequenceKey), []byte("12")); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("yaml")).Put([]byte{0xff, 0}, []byte{0, 1, 0xfe})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the spacing of JSON values changes in YAML, TOML sorts their keys and writes numbers its own way
+	changed := map[string]map[string]string{
+		"yaml": {"indented": `{"x":"<a&b>","n":1.50}`},
+		"toml": {
+			"indented": `{"n":1.5,"x":"<a&b>"}`,
+			"unsorted": `{"a":[2.5,1000],"b":1,"c":{"y":true,"z":"<&>"}}`,
+		},
+	}
+	for format, tags := range map[string][]string{
+		"yaml": {yamlJSONTag, "!!binary", yamlSequenceTag},
+		"toml": {tomlJSONKey, sequenceKey},
+	} {
+		var out bytes.Buffer
+		err = bdb.View(func(tx *bolt.Tx) error {
+			return exportStructured(tx, []string{format}, format, &out)
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", format, err)
+		}
+		for _, tag := range tags {
+			if !strings.Contains(out.String(), tag) {
+				t.Errorf("%s: no %s in\n%s", format, tag, out.String())
+			}
+		}
+		err = bdb.Update(func(tx *bolt.Tx) error {
+			count, err := importStructured(tx, []string{"copy", format}, format, bytes.NewReader(out.Bytes()))
+			if err == nil && count != len(bucketContents(tx.Bucket([]byte(format)), "", map[string]string{}))-2 {
+				t.Errorf("%s: imported %d pairs", format, count)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", format, err, out.String())
+		}
+		bdb.View(func(tx *bolt.Tx) error {
+			want := bucketContents(tx.Bucket([]byte(format)), "", map[string]string{})
+			for k, v := range changed[format] {
+				want[k] = v
+			}
+			copied := getBoltBucket(tx, []string{"copy", format})
+			got := bucketContents(copied, "", map[string]string{})
+			if copied.Sequence() != 7 {
+				t.Errorf("%s: the sequence is %d, want 7", format, copied.Sequence())
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: got\n%q\nwant\n%q\nfrom\n%s", format, got, want, out.String())
+			}
+			return nil
+		})
+	}
+
+	// TOML can't hold the binary pair or the one named $sequence
+	for _, c := range []struct{ key, want string }{
+		{sequenceKey, "can't both be written"},
+		{"\xff\x00", "isn't text"},
+	} {
+		err = bdb.View(func(tx *bolt.Tx) error {
+			return exportStructured(tx, []string{"yaml"}, "toml", &bytes.Buffer{})
+		})
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("got %v exporting %q to TOML", err, c.key)
+		}
+		bdb.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("yaml")).Delete([]byte(c.key))
+		})
+	}
+}
@@ -1,6 +1,13 @@
 package main
 
-import "github.com/nsf/termbox-go"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
 
 /*
 Style Defines the colors for the terminal display, basically
@@ -10,8 +17,27 @@ type Style struct {
 	defaultFg termbox.Attribute
 	titleFg   termbox.Attribute
 	titleBg   termbox.Attribute
+	footerFg  termbox.Attribute
+	footerBg  termbox.Attribute
 	cursorFg  termbox.Attribute
 	cursorBg  termbox.Attribute
+	bucketFg  termbox.Attribute
+	bucketBg  termbox.Attribute
+	pairFg    termbox.Attribute
+	pairBg    termbox.Attribute
+	modalFg   termbox.Attribute
+	modalBg   termbox.Attribute
+
+	// Syntax highlighting for decoded values
+	syntaxKeyFg     termbox.Attribute
+	syntaxStringFg  termbox.Attribute
+	syntaxNumberFg  termbox.Attribute
+	syntaxLiteralFg termbox.Attribute
+	syntaxPunctFg   termbox.Attribute
+
+	// Markers for diff previews
+	diffAddFg    termbox.Attribute
+	diffRemoveFg termbox.Attribute
 }
 
 func defaultStyle() Style {
@@ -20,8 +46,245 @@ func defaultStyle() Style {
 	style.defaultFg = termbox.ColorDefault
 	style.titleFg = termbox.ColorWhite
 	style.titleBg = termbox.ColorBlack
+	style.footerFg = termbox.ColorDefault
+	style.footerBg = termbox.ColorDefault
 	style.cursorFg = termbox.ColorWhite
 	style.cursorBg = termbox.ColorBlack
+	style.bucketFg = termbox.ColorDefault
+	style.bucketBg = termbox.ColorDefault
+	style.pairFg = termbox.ColorDefault
+	style.pairBg = termbox.ColorDefault
+	style.modalFg = termbox.ColorWhite
+	style.modalBg = termbox.ColorBlack
+
+	style.syntaxKeyFg = termbox.ColorBlue
+	style.syntaxStringFg = termbox.ColorGreen
+	style.syntaxNumberFg = termbox.ColorCyan
+	style.syntaxLiteralFg = termbox.ColorMagenta
+	style.syntaxPunctFg = termbox.ColorDefault
+
+	style.diffAddFg = termbox.ColorGreen
+	style.diffRemoveFg = termbox.ColorRed
 
 	return style
 }
+
+func darkStyle() Style {
+	style := defaultStyle()
+	style.defaultBg = color256(234)
+	style.defaultFg = color256(252)
+	style.titleFg = color256(234)
+	style.titleBg = color256(110)
+	style.footerFg = color256(246)
+	style.footerBg = color256(236)
+	style.cursorFg = color256(234)
+	style.cursorBg = color256(179)
+	style.bucketFg = color256(110) | termbox.AttrBold
+	style.bucketBg = color256(234)
+	style.pairFg = color256(252)
+	style.pairBg = color256(234)
+	style.modalFg = color256(252)
+	style.modalBg = color256(238)
+	style.syntaxKeyFg = color256(110)
+	style.syntaxStringFg = color256(150)
+	style.syntaxNumberFg = color256(216)
+	style.syntaxLiteralFg = color256(176)
+	style.syntaxPunctFg = color256(246)
+	style.diffAddFg = color256(114)
+	style.diffRemoveFg = color256(203)
+	return style
+}
+
+func lightStyle() Style {
+	style := defaultStyle()
+	style.defaultBg = color256(255)
+	style.defaultFg = color256(236)
+	style.titleFg = color256(255)
+	style.titleBg = color256(25)
+	style.footerFg = color256(240)
+	style.footerBg = color256(253)
+	style.cursorFg = color256(255)
+	style.cursorBg = color256(31)
+	style.bucketFg = color256(25) | termbox.AttrBold
+	style.bucketBg = color256(255)
+	style.pairFg = color256(236)
+	style.pairBg = color256(255)
+	style.modalFg = color256(236)
+	style.modalBg = color256(252)
+	style.syntaxKeyFg = color256(25)
+	style.syntaxStringFg = color256(28)
+	style.syntaxNumberFg = color256(130)
+	style.syntaxLiteralFg = color256(91)
+	style.syntaxPunctFg = color256(242)
+	style.diffAddFg = color256(28)
+	style.diffRemoveFg = color256(160)
+	return style
+}
+
+// builtinThemes are the themes that can be picked with -theme without a config file
+var builtinThemes = map[string]func() Style{
+	"default": defaultStyle,
+	"dark":    darkStyle,
+	"light":   lightStyle,
+}
+
+// color256 converts a 0-255 palette index to a termbox attribute (Output256)
+func color256(idx int) termbox.Attribute {
+	return termbox.Attribute(idx + 1)
+}
+
+var colorNames = map[string]termbox.Attribute{
+	"default": termbox.ColorDefault,
+	"black":   termbox.ColorBlack,
+	"red":     termbox.ColorRed,
+	"green":   termbox.ColorGreen,
+	"yellow":  termbox.ColorYellow,
+	"blue":    termbox.ColorBlue,
+	"magenta": termbox.ColorMagenta,
+	"cyan":    termbox.ColorCyan,
+	"white":   termbox.ColorWhite,
+}
+
+var attributeNames = map[string]termbox.Attribute{
+	"bold":      termbox.AttrBold,
+	"underline": termbox.AttrUnderline,
+	"reverse":   termbox.AttrReverse,
+}
+
+/*
+parseColor turns a config value into a termbox attribute.
+It accepts a colour name or a 256-colour index, either of which
+can be followed by attributes, e.g. "white+bold" or "208+underline"
+*/
+func parseColor(v interface{}) (termbox.Attribute, error) {
+	switch c := v.(type) {
+	case int64:
+		if c < 0 || c > 255 {
+			return 0, fmt.Errorf("Colour index %d out of range (0-255)", c)
+		}
+		return color256(int(c)), nil
+	case string:
+		var attr termbox.Attribute
+		parts := strings.Split(strings.ToLower(c), "+")
+		if idx, err := strconv.Atoi(parts[0]); err == nil {
+			if idx < 0 || idx > 255 {
+				return 0, fmt.Errorf("Colour index %d out of range (0-255)", idx)
+			}
+			attr = color256(idx)
+		} else if named, ok := colorNames[parts[0]]; ok {
+			attr = named
+		} else {
+			return 0, fmt.Errorf("Unknown colour %q", parts[0])
+		}
+		for _, a := range parts[1:] {
+			named, ok := attributeNames[a]
+			if !ok {
+				return 0, fmt.Errorf("Unknown attribute %q", a)
+			}
+			attr |= named
+		}
+		return attr, nil
+	}
+	return 0, fmt.Errorf("Invalid colour %v", v)
+}
+
+// styleElements maps the element names used in the config file to Style fields
+func (style *Style) styleElements() map[string][2]*termbox.Attribute {
+	return map[string][2]*termbox.Attribute{
+		"default":        {&style.defaultFg, &style.defaultBg},
+		"header":         {&style.titleFg, &style.titleBg},
+		"footer":         {&style.footerFg, &style.footerBg},
+		"cursor":         {&style.cursorFg, &style.cursorBg},
+		"bucket":         {&style.bucketFg, &style.bucketBg},
+		"pair":           {&style.pairFg, &style.pairBg},
+		"modal":          {&style.modalFg, &style.modalBg},
+		"syntax_key":     {&style.syntaxKeyFg, nil},
+		"syntax_string":  {&style.syntaxStringFg, nil},
+		"syntax_number":  {&style.syntaxNumberFg, nil},
+		"syntax_literal": {&style.syntaxLiteralFg, nil},
+		"syntax_punct":   {&style.syntaxPunctFg, nil},
+		"diff_add":       {&style.diffAddFg, nil},
+		"diff_remove":    {&style.diffRemoveFg, nil},
+	}
+}
+
+/*
+buildStyle resolves the theme named 'name', first looking in the
+themes from the config file and then in the built-in themes
+*/
+func buildStyle(name string, themes map[string]ThemeConfig) (Style, error) {
+	return resolveTheme(name, themes, map[string]bool{})
+}
+
+func resolveTheme(name string, themes map[string]ThemeConfig, seen map[string]bool) (Style, error) {
+	if name == "" {
+		name = "default"
+	}
+	theme, ok := themes[name]
+	if !ok || seen[name] {
+		// Not configured (or a config theme based on the built-in of the same name)
+		if builtin, ok := builtinThemes[name]; ok {
+			return builtin(), nil
+		}
+		if seen[name] {
+			return defaultStyle(), fmt.Errorf("Theme %s is based on itself", name)
+		}
+		return defaultStyle(), fmt.Errorf("Unknown theme %q (available: %s)", name, strings.Join(themeNames(themes), ", "))
+	}
+	seen[name] = true
+	base := defaultStyle()
+	if baseName, ok := theme["base"]; ok {
+		baseStr, ok := baseName.(string)
+		if !ok {
+			return base, fmt.Errorf("Theme %s: base must be a theme name", name)
+		}
+		var err error
+		if base, err = resolveTheme(baseStr, themes, seen); err != nil {
+			return base, err
+		}
+	}
+	elements := base.styleElements()
+	for element, v := range theme {
+		if element == "base" {
+			continue
+		}
+		fields, ok := elements[element]
+		if !ok {
+			return base, fmt.Errorf("Theme %s: unknown element %q", name, element)
+		}
+		colors, ok := v.(map[string]interface{})
+		if !ok {
+			// Shorthand for just setting the foreground
+			colors = map[string]interface{}{"fg": v}
+		}
+		for i, key := range []string{"fg", "bg"} {
+			c, ok := colors[key]
+			if !ok {
+				continue
+			}
+			if fields[i] == nil {
+				return base, fmt.Errorf("Theme %s: %s has no %s", name, element, key)
+			}
+			attr, err := parseColor(c)
+			if err != nil {
+				return base, fmt.Errorf("Theme %s: %s.%s: %s", name, element, key, err)
+			}
+			*fields[i] = attr
+		}
+	}
+	return base, nil
+}
+
+func themeNames(themes map[string]ThemeConfig) []string {
+	var names []string
+	for n := range builtinThemes {
+		names = append(names, n)
+	}
+	for n := range themes {
+		if _, ok := builtinThemes[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+/*
+These work in terminal columns rather than bytes or runes,
+so wide characters (CJK, emoji) take up two cells
+*/
+
+func textWidth(s string) int {
+	return runewidth.StringWidth(s)
+}
+
+/*
+truncateText cuts 's' down to 'width' columns, ending it
+with "..." if anything was cut off
+*/
+func truncateText(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if textWidth(s) <= width {
+		return s
+	}
+	if width <= 3 {
+		return runewidth.Truncate(s, width, "")
+	}
+	return runewidth.Truncate(s, width, "...")
+}
+
+/*
+wrapText splits 's' into lines of at most 'width' columns. Lines after
+the first are indented by 'indent' spaces, and '\n' always starts a new line
+*/
+func wrapText(s string, width, indent int) []string {
+	lines, _ := wrapColoured(s, nil, width, indent)
+	return lines
+}
+
+/*
+wrapColoured is wrapText for text with a colour for each rune in 'fgs'
+(which can be nil), it returns the colours of each line along with it.
+The indent is in the default colour, 0
+*/
+func wrapColoured(s string, fgs []termbox.Attribute, width, indent int) ([]string, [][]termbox.Attribute) {
+	if width <= indent {
+		// No room to indent, wrap without it
+		indent = 0
+	}
+	padding := strings.Repeat(" ", indent)
+	var lines []string
+	var lineFgs [][]termbox.Attribute
+	var cur strings.Builder
+	var curFgs []termbox.Attribute
+	curW := 0
+	newLine := func() {
+		lines = append(lines, cur.String())
+		lineFgs = append(lineFgs, curFgs)
+		cur.Reset()
+		curFgs = nil
+		curW = 0
+	}
+	i := 0
+	for _, r := range s {
+		var fg termbox.Attribute
+		if fgs != nil {
+			fg = fgs[i]
+		}
+		i++
+		if r == '\n' {
+			newLine()
+			continue
+		}
+		rw := runewidth.RuneWidth(r)
+		if width > 0 && curW+rw > width && curW > indent {
+			newLine()
+			cur.WriteString(padding)
+			curW = indent
+			if fgs != nil {
+				curFgs = make([]termbox.Attribute, indent)
+			}
+		}
+		cur.WriteRune(r)
+		if fgs != nil {
+			curFgs = append(curFgs, fg)
+		}
+		curW += rw
+	}
+	newLine()
+	return lines, lineFgs
+}
+
+/*
+sliceColumns returns the part of 's' that starts 'from' columns in,
+for horizontal scrolling. A wide character cut in half becomes a space
+*/
+func sliceColumns(s string, from int) string {
+	if from <= 0 {
+		return s
+	}
+	col := 0
+	for i := 0; i < len(s); {
+		if col >= from {
+			return s[i:]
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		col += runewidth.RuneWidth(r)
+		if col > from {
+			return " " + s[i:]
+		}
+	}
+	return ""
+}
+
+// sliceColoured is sliceColumns for a line from wrapColoured
+func sliceColoured(s string, fgs []termbox.Attribute, from int) (string, []termbox.Attribute) {
+	if from <= 0 || fgs == nil {
+		return sliceColumns(s, from), fgs
+	}
+	col := 0
+	for i, ri := 0, 0; i < len(s); ri++ {
+		if col >= from {
+			return s[i:], fgs[ri:]
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		col += runewidth.RuneWidth(r)
+		if col > from {
+			return " " + s[i:], append([]termbox.Attribute{0}, fgs[ri+1:]...)
+		}
+	}
+	return "", nil
+}
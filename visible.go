@@ -0,0 +1,194 @@
+package main
+
+/*
+VisibleRow is one item showing in the tree, either a bucket or a pair
+*/
+type VisibleRow struct {
+	bucket *BoltBucket
+	pair   *BoltPair
+}
+
+func (r VisibleRow) path() []string {
+	if r.bucket != nil {
+		return r.bucket.GetPath()
+	}
+	return r.pair.GetPath()
+}
+
+// depth is how many buckets up the root is, the same as len(r.path())-1
+func (r VisibleRow) depth() int {
+	b := r.bucket
+	if b == nil {
+		b = r.pair.parent
+	}
+	d := 0
+	if r.pair != nil {
+		d++
+	}
+	for ; b.parent != nil; b = b.parent {
+		d++
+	}
+	return d
+}
+
+// matches checks the row is at 'path' without building the row's path
+func (r VisibleRow) matches(path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	b := r.bucket
+	last := len(path) - 1
+	if r.pair != nil {
+		if r.pair.key != path[last] {
+			return false
+		}
+		b = r.pair.parent
+		last--
+	}
+	for i := last; i >= 0; i-- {
+		if b == nil || b.name != path[i] {
+			return false
+		}
+		b = b.parent
+	}
+	return b == nil
+}
+
+/*
+VisibleIndex is the tree flattened into the rows that are showing.
+It is built once and then patched as buckets are opened and closed,
+so moving the cursor and drawing don't have to walk the whole tree
+*/
+type VisibleIndex struct {
+	rows  []VisibleRow
+	valid bool
+	// Where the last lookup found its row, the next one is usually close by
+	hint int
+	// How each bucket's contents are ordered, nil is bolt's order
+	order func(b *BoltBucket) SortOptions
+	// The filter for each bucket's pairs, "" or nil shows them all
+	filter func(b *BoltBucket) string
+}
+
+// appendVisibleRows adds 'b' and, if it's open, everything showing inside it
+func (vi *VisibleIndex) appendVisibleRows(rows []VisibleRow, b *BoltBucket) []VisibleRow {
+	rows = append(rows, VisibleRow{bucket: b})
+	if b.expanded {
+		rows = vi.appendBucketContents(rows, b)
+	}
+	return rows
+}
+
+func (vi *VisibleIndex) appendBucketContents(rows []VisibleRow, b *BoltBucket) []VisibleRow {
+	filter := ""
+	if vi.filter != nil {
+		filter = vi.filter(b)
+	}
+	if vi.order != nil {
+		if o := vi.order(b); !o.isDefault() {
+			for _, r := range sortedContents(b, o) {
+				if r.bucket != nil {
+					rows = vi.appendVisibleRows(rows, r.bucket)
+				} else if filter == "" || matchFilter(filter, r.pair.key) {
+					rows = append(rows, r)
+				}
+			}
+			return rows
+		}
+	}
+	for i := range b.buckets {
+		rows = vi.appendVisibleRows(rows, &b.buckets[i])
+	}
+	for i := range b.pairs {
+		if filter == "" || matchFilter(filter, b.pairs[i].key) {
+			rows = append(rows, VisibleRow{pair: &b.pairs[i]})
+		}
+	}
+	return rows
+}
+
+func (bd *BoltDB) visibleRows() []VisibleRow {
+	if !bd.visible.valid {
+		bd.visible.rows = nil
+		for i := range bd.buckets {
+			bd.visible.rows = bd.visible.appendVisibleRows(bd.visible.rows, &bd.buckets[i])
+		}
+		bd.visible.valid = true
+		bd.visible.hint = 0
+	}
+	return bd.visible.rows
+}
+
+// invalidateVisible makes the next visibleRows rebuild the index
+func (bd *BoltDB) invalidateVisible() {
+	bd.visible.valid = false
+}
+
+/*
+findVisibleRow returns the index of the row for 'path', or -1 if it
+isn't showing. Looking near the last row found first keeps cursor
+movement from scanning the whole tree
+*/
+func (bd *BoltDB) findVisibleRow(path []string) int {
+	rows := bd.visibleRows()
+	hint := bd.visible.hint
+	for _, idx := range []int{hint, hint + 1, hint - 1} {
+		if idx >= 0 && idx < len(rows) && rows[idx].matches(path) {
+			bd.visible.hint = idx
+			return idx
+		}
+	}
+	for idx := range rows {
+		if rows[idx].matches(path) {
+			bd.visible.hint = idx
+			return idx
+		}
+	}
+	return -1
+}
+
+func (bd *BoltDB) visibleRowPath(idx int) []string {
+	rows := bd.visibleRows()
+	if idx < 0 || idx >= len(rows) {
+		return nil
+	}
+	bd.visible.hint = idx
+	return rows[idx].path()
+}
+
+/*
+setExpanded opens or closes 'b', splicing its contents into
+or out of the visible index rather than rebuilding it
+*/
+func (bd *BoltDB) setExpanded(b *BoltBucket, expanded bool) {
+	if b.expanded == expanded {
+		return
+	}
+	if !bd.visible.valid {
+		b.expanded = expanded
+		return
+	}
+	row := bd.findVisibleRow(b.GetPath())
+	if row < 0 || bd.visible.rows[row].bucket != b {
+		// Not showing (a parent is closed), nothing to patch
+		b.expanded = expanded
+		if row >= 0 {
+			bd.invalidateVisible()
+		}
+		return
+	}
+	rows := bd.visible.rows
+	if expanded {
+		b.expanded = true
+		contents := bd.visible.appendBucketContents(nil, b)
+		rows = append(rows, contents...)
+		copy(rows[row+1+len(contents):], rows[row+1:len(rows)-len(contents)])
+		copy(rows[row+1:], contents)
+	} else {
+		count := len(bd.visible.appendBucketContents(nil, b))
+		b.expanded = false
+		rows = append(rows[:row+1], rows[row+1+count:]...)
+	}
+	bd.visible.rows = rows
+	bd.visible.hint = row
+}
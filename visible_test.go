@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+openSyntheticDB makes a database with a "big" bucket holding
+'keys' pairs and a few small buckets around it, and loads it
+*/
+func openSyntheticDB(tb testing.TB, keys int) *BrowserScreen {
+	tb.Helper()
+	var err error
+	db, err = bolt.Open(filepath.Join(tb.TempDir(), "bench.db"), 0600, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	err = db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i < 10; i++ {
+			b, err := tx.CreateBucket([]byte(fmt.Sprintf("small-%02d", i)))
+			if err != nil {
+				return err
+			}
+			if err = b.Put([]byte("key"), []byte("value")); err != nil {
+				return err
+			}
+		}
+		big, err := tx.CreateBucket([]byte("big"))
+		if err != nil {
+			return err
+		}
+		big.FillPercent = 1
+		k := make([]byte, 8)
+		for i := 0; i < keys; i++ {
+			binary.BigEndian.PutUint64(k, uint64(i))
+			if err = big.Put(k, []byte(`{"status":"active"}`)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	memBolt = new(BoltDB)
+	screen := &BrowserScreen{db: memBolt.refreshDatabase(), style: defaultStyle(), wrapDetails: true}
+	screen.leftPane = Rect{x: 0, y: 2, w: 60, h: 40}
+	screen.rightPane = Rect{x: 61, y: 2, w: 60, h: 40}
+	screen.db.openBucket([]string{"big"})
+	screen.currentPath = screen.db.getNextVisiblePath(nil)
+	return screen
+}
+
+func TestVisibleIndexExpandCollapse(t *testing.T) {
+	bd := openSyntheticDB(t, 1000).db
+	// big, its 1000 pairs and the 10 closed small buckets
+	if n := len(bd.visibleRows()); n != 1011 {
+		t.Fatalf("expected 1011 visible rows, got %d", n)
+	}
+	bd.openBucket([]string{"small-03"})
+	if n := len(bd.visibleRows()); n != 1012 {
+		t.Fatalf("expected 1012 visible rows after opening small-03, got %d", n)
+	}
+	want := bd.visibleRows()
+	bd.invalidateVisible()
+	got := bd.visibleRows()
+	for i := range want {
+		if !got[i].matches(want[i].path()) {
+			t.Fatalf("row %d is %v, a rebuild gives %v", i, want[i].path(), got[i].path())
+		}
+	}
+	bd.closeBucket([]string{"big"})
+	if n := len(bd.visibleRows()); n != 12 {
+		t.Fatalf("expected 12 visible rows after closing big, got %d", n)
+	}
+	if p := bd.getNextVisiblePath([]string{"small-03"}); !comparePaths(p, []string{"small-03", "key"}) {
+		t.Fatalf("expected small-03/key after small-03, got %v", p)
+	}
+}
+
+func TestTreeMarkerClick(t *testing.T) {
+	screen := openSyntheticDB(t, 10)
+	screen.db.closeBucket([]string{"big"})
+	screen.db.openBucket([]string{"small-03"})
+	screen.leftPane.x = 3
+	screen.drawLeftPane(defaultStyle())
+	for i, row := range screen.treeRows {
+		want := treeIndent(len(row.path))
+		if got := len(row.text) - len(strings.TrimLeft(row.text, " ")); got != want {
+			t.Errorf("%v is indented %d, want %d", row.path, got, want)
+		}
+		if row.path[0] != "small-03" || len(row.path) != 1 {
+			continue
+		}
+		if !strings.HasPrefix(row.text[want:], "- ") {
+			t.Fatalf("no marker at %d in %q", want, row.text)
+		}
+		screen.clickTreeRow(screen.leftPane.x+want, screen.leftPane.y+i)
+		if b, _ := screen.db.getBucketFromPath(row.path); b.expanded {
+			t.Error("clicking the marker didn't close the bucket")
+		}
+		return
+	}
+	t.Fatal("no row for small-03")
+}
+
+func benchmarkCursorDown(b *testing.B, keys int) {
+	screen := openSyntheticDB(b, keys)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !screen.moveCursorDown() {
+			screen.currentPath = screen.db.getNextVisiblePath(nil)
+		}
+	}
+}
+
+func BenchmarkCursorDown10k(b *testing.B)  { benchmarkCursorDown(b, 10000) }
+func BenchmarkCursorDown100k(b *testing.B) { benchmarkCursorDown(b, 100000) }
+func BenchmarkCursorDown1M(b *testing.B)   { benchmarkCursorDown(b, 1000000) }
+
+func benchmarkDrawTree(b *testing.B, keys int) {
+	screen := openSyntheticDB(b, keys)
+	screen.jumpCursorDown(keys / 2)
+	style := defaultStyle()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		screen.drawLeftPane(style)
+		screen.drawRightPane(style)
+	}
+}
+
+func BenchmarkDrawTree10k(b *testing.B)  { benchmarkDrawTree(b, 10000) }
+func BenchmarkDrawTree100k(b *testing.B) { benchmarkDrawTree(b, 100000) }
+func BenchmarkDrawTree1M(b *testing.B)   { benchmarkDrawTree(b, 1000000) }
+
+func BenchmarkToggleBigBucket(b *testing.B) {
+	screen := openSyntheticDB(b, 100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		screen.db.toggleOpenBucket([]string{"big"})
+	}
+}
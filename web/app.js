@@ -0,0 +1,364 @@
+"use strict";
+
+// The web UI for `bolt serve`. Everything goes through the REST API under
+// /buckets, plus /info and /query. Keys are always sent as base64 so that
+// binary ones work, and shown the way the terminal browser shows them
+
+const state = {
+  info: { file: "", read_only: true, decoders: ["auto"] },
+  // Buckets that are expanded in the tree, by JSON path
+  open: new Set(),
+  selected: null,
+};
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function el(tag, props, ...children) {
+  const e = document.createElement(tag);
+  Object.assign(e, props || {});
+  for (const c of children) {
+    e.append(c);
+  }
+  return e;
+}
+
+async function api(url, opts) {
+  const resp = await fetch(url, opts);
+  const text = await resp.text();
+  const body = text ? JSON.parse(text) : null;
+  if (!resp.ok) {
+    throw new Error(body && body.error ? body.error : resp.statusText);
+  }
+  return body;
+}
+
+function flash(text, isError) {
+  const m = $("message");
+  m.textContent = text;
+  m.className = isError ? "error" : "";
+  m.hidden = false;
+  clearTimeout(flash.timer);
+  flash.timer = setTimeout(() => { m.hidden = true; }, isError ? 6000 : 3000);
+}
+
+// failed wraps an async handler so its errors are shown instead of lost
+function failed(fn) {
+  return (...args) => fn(...args).catch((err) => flash(err.message, true));
+}
+
+function fromBase64(s) {
+  return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+}
+
+function toBase64(bytes) {
+  let s = "";
+  for (const b of bytes) {
+    s += String.fromCharCode(b);
+  }
+  return btoa(s);
+}
+
+// utf8 is the bytes as text, or null if they aren't UTF-8
+function utf8(bytes) {
+  try {
+    return new TextDecoder("utf-8", { fatal: true }).decode(bytes);
+  } catch (e) {
+    return null;
+  }
+}
+
+// stringify does what stringify.go does: printable text as it is, 8 bytes as a uint64, otherwise hex
+function stringify(bytes) {
+  const s = utf8(bytes);
+  if (s !== null && !/[\x00-\x1f\x7f-\x9f]/.test(s)) {
+    return s;
+  }
+  if (bytes.length === 8) {
+    return new DataView(bytes.buffer, bytes.byteOffset).getBigUint64(0).toString();
+  }
+  return Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+// formatPath shows a path the way it's typed in a query
+function formatPath(path) {
+  return path.map((name) => name.replace(/\//g, "\\/")).join("/");
+}
+
+// "keys" ends a bucket path in a URL, so a bucket called that is escaped
+function segment(name) {
+  return name === "keys" ? "%6Beys" : encodeURIComponent(name);
+}
+
+function bucketURL(path) {
+  return "/buckets/" + path.map(segment).join("/");
+}
+
+function pairURL(path, key, params) {
+  return bucketURL(path) + "/keys/" + encodeURIComponent(toBase64(key)) + "?key=base64&value=base64" + (params || "");
+}
+
+function itemID(path, key) {
+  return JSON.stringify([path, key ? toBase64(key) : null]);
+}
+
+function select(path, key) {
+  state.selected = itemID(path, key);
+  for (const e of document.querySelectorAll(".item.selected")) {
+    e.classList.remove("selected");
+  }
+  for (const e of document.querySelectorAll(".item")) {
+    if (e.dataset.id === state.selected) {
+      e.classList.add("selected");
+    }
+  }
+}
+
+function treeItem(text, path, key, onclick) {
+  const item = el("span", { className: "item", textContent: text, onclick: failed(onclick) });
+  item.dataset.id = itemID(path, key);
+  if (item.dataset.id === state.selected) {
+    item.classList.add("selected");
+  }
+  return item;
+}
+
+function bucketNode(path) {
+  const li = el("li", { className: "bucket" });
+  const children = el("ul");
+  li.append(treeItem(path[path.length - 1], path, null, async () => {
+    const id = JSON.stringify(path);
+    if (state.open.has(id) && state.selected === itemID(path, null)) {
+      state.open.delete(id);
+      li.classList.remove("open");
+      children.replaceChildren();
+    } else if (!state.open.has(id)) {
+      state.open.add(id);
+      await loadBucket(li, children, path);
+    }
+    select(path, null);
+    await showBucket(path);
+  }), children);
+  if (state.open.has(JSON.stringify(path))) {
+    loadBucket(li, children, path).catch((err) => flash(err.message, true));
+  }
+  return li;
+}
+
+async function loadBucket(li, children, path) {
+  const info = await api(bucketURL(path));
+  li.classList.add("open");
+  children.replaceChildren(...info.buckets.map((name) => bucketNode(path.concat([name]))));
+  await loadPairs(children, path, null);
+}
+
+async function loadPairs(children, path, after) {
+  let url = bucketURL(path) + "/keys?key=base64&value=base64";
+  if (after !== null) {
+    url += "&after=" + encodeURIComponent(after);
+  }
+  const page = await api(url);
+  for (const p of page.pairs) {
+    const key = fromBase64(p.key);
+    children.append(el("li", {}, treeItem(stringify(key), path, key, async () => {
+      select(path, key);
+      await showPair(path, key);
+    })));
+  }
+  if (page.next !== null) {
+    const more = el("li", { className: "item more", textContent: "more..." });
+    more.onclick = failed(async () => {
+      more.remove();
+      await loadPairs(children, path, page.next);
+    });
+    children.append(more);
+  }
+}
+
+async function renderTree() {
+  const root = await api("/buckets");
+  $("tree").replaceChildren(...root.buckets.map((name) => bucketNode([name])));
+}
+
+function decoderKey(path) {
+  return "bolt-decoder:" + state.info.file + ":" + JSON.stringify(path);
+}
+
+function writeButton(text, onclick) {
+  return el("button", { className: "write", textContent: text, onclick: failed(onclick) });
+}
+
+function showHint(text) {
+  $("pane").replaceChildren(el("p", { className: "hint", textContent: text }));
+}
+
+async function showBucket(path) {
+  const info = await api(bucketURL(path));
+  $("pane").replaceChildren(
+    el("h2", { className: "path", textContent: formatPath(path) }),
+    el("p", { textContent: `${info.pairs} pairs, ${info.buckets.length} buckets, sequence ${info.sequence}` }),
+    el("div", { className: "actions" },
+      writeButton("New pair", async () => showNewPair(path)),
+      writeButton("New bucket", async () => newBucket(path)),
+      writeButton("Delete bucket", async () => {
+        if (!confirm(`Delete the bucket ${formatPath(path)} and everything in it?`)) {
+          return;
+        }
+        await api(bucketURL(path), { method: "DELETE" });
+        flash("Deleted " + formatPath(path));
+        state.open.delete(JSON.stringify(path));
+        showHint("Deleted " + formatPath(path));
+        await renderTree();
+      })));
+}
+
+async function newBucket(path) {
+  const name = prompt("New bucket in " + (path.length ? formatPath(path) : "the root") + ":");
+  if (!name) {
+    return;
+  }
+  const full = path.concat([name]);
+  if (!confirm(`Create the bucket ${formatPath(full)}?`)) {
+    return;
+  }
+  await api(bucketURL(full), { method: "PUT" });
+  flash("Created " + formatPath(full));
+  if (path.length) {
+    state.open.add(JSON.stringify(path));
+  }
+  await renderTree();
+}
+
+function showNewPair(path) {
+  const key = el("input", { placeholder: "key", spellcheck: false });
+  const value = el("textarea", { placeholder: "value", spellcheck: false });
+  $("pane").replaceChildren(
+    el("h2", { className: "path", textContent: "New pair in " + formatPath(path) }),
+    el("p", {}, key),
+    value,
+    el("div", { className: "actions" },
+      writeButton("Save", async () => {
+        const k = new TextEncoder().encode(key.value);
+        if (!k.length) {
+          throw new Error("The key can't be empty");
+        }
+        const existing = await fetch(pairURL(path, k));
+        if (existing.ok && !confirm(`${key.value} is already there, overwrite it?`)) {
+          return;
+        }
+        if (!existing.ok && !confirm(`Add ${key.value} to ${formatPath(path)}?`)) {
+          return;
+        }
+        await api(pairURL(path, k), { method: "PUT", body: value.value });
+        flash("Saved " + key.value);
+        state.open.add(JSON.stringify(path));
+        select(path, k);
+        await renderTree();
+        await showPair(path, k);
+      }),
+      el("button", { textContent: "Cancel", onclick: failed(async () => showBucket(path)) })));
+  key.focus();
+}
+
+async function showPair(path, key) {
+  const decoder = localStorage.getItem(decoderKey(path)) || "auto";
+  const pair = await api(pairURL(path, key, "&decode=" + encodeURIComponent(decoder)));
+  const value = fromBase64(pair.value);
+  const text = utf8(value);
+  const name = formatPath(path) + "/" + stringify(key);
+
+  const picker = el("select", {
+    onchange: failed(async () => {
+      localStorage.setItem(decoderKey(path), picker.value);
+      await showPair(path, key);
+    }),
+  }, ...state.info.decoders.map((d) => el("option", { value: d, textContent: d, selected: d === decoder })));
+
+  const body = el("div", {}, el("pre", { textContent: pair.decoded }));
+  if (pair.decode_error) {
+    body.prepend(el("p", { className: "error", textContent: pair.decode_error }));
+  }
+
+  const edit = writeButton("Edit", async () => {
+    const area = el("textarea", { value: text, spellcheck: false });
+    body.replaceChildren(area, el("div", { className: "actions" },
+      writeButton("Save", async () => {
+        if (area.value === text) {
+          flash("Nothing changed");
+          return;
+        }
+        if (!confirm(`Save the new value of ${name}?`)) {
+          return;
+        }
+        await api(pairURL(path, key), { method: "PUT", body: area.value });
+        flash("Saved " + name);
+        await showPair(path, key);
+      }),
+      el("button", { textContent: "Cancel", onclick: failed(async () => showPair(path, key)) })));
+    area.focus();
+  });
+  if (text === null) {
+    edit.disabled = true;
+    edit.title = "Only text values can be edited here";
+  }
+
+  $("pane").replaceChildren(
+    el("h2", { className: "path", textContent: name }),
+    el("div", { className: "actions" },
+      el("label", { textContent: "Decoder " }, picker),
+      el("span", { className: "dim", textContent: `${value.length} bytes` }),
+      edit,
+      writeButton("Delete", async () => {
+        if (!confirm(`Delete ${name}?`)) {
+          return;
+        }
+        await api(pairURL(path, key), { method: "DELETE" });
+        flash("Deleted " + name);
+        showHint("Deleted " + name);
+        await renderTree();
+      })),
+    body);
+}
+
+async function search(event) {
+  event.preventDefault();
+  const q = $("query").value.trim();
+  if (!q) {
+    closeResults();
+    return;
+  }
+  const res = await api("/query?key=base64&value=base64&limit=500&q=" + encodeURIComponent(q));
+  $("results-title").textContent = res.results.length + (res.more ? "+" : "") + " results";
+  $("results-list").replaceChildren(...res.results.map((r) => {
+    const key = fromBase64(r.key);
+    const preview = stringify(fromBase64(r.value)).slice(0, 80);
+    return el("li", {}, treeItem(formatPath(r.path.concat([stringify(key)])) + "  " + preview, r.path, key, async () => {
+      select(r.path, key);
+      await showPair(r.path, key);
+    }));
+  }));
+  $("results").hidden = false;
+  $("tree-pane").hidden = true;
+}
+
+function closeResults() {
+  $("results").hidden = true;
+  $("tree-pane").hidden = false;
+}
+
+async function start() {
+  state.info = await api("/info");
+  document.title = state.info.file + " - bolt";
+  $("title").textContent = state.info.file;
+  if (state.info.read_only) {
+    document.body.classList.add("read-only");
+    $("mode").textContent = "read-only";
+  }
+  $("search").onsubmit = failed(search);
+  $("results-close").onclick = closeResults;
+  $("new-root").onclick = failed(async () => newBucket([]));
+  await renderTree();
+}
+
+failed(start)();
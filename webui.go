@@ -0,0 +1,102 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+)
+
+// The web UI's files are compiled into the binary
+//
+//go:embed web
+var webFiles embed.FS
+
+/*
+newServeHandler is everything `bolt serve` answers: the REST API under
+/buckets, /info and /query for the web UI, and the UI itself at /
+*/
+func newServeHandler(bdb *bolt.DB, fileName string, readOnly bool) http.Handler {
+	api := &APIServer{db: bdb, readOnly: readOnly}
+	mux := http.NewServeMux()
+	mux.Handle("/buckets", api)
+	mux.Handle("/buckets/", api)
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+			"file":      fileName,
+			"read_only": readOnly,
+			"decoders":  decoderNames(),
+		})
+	})
+	mux.HandleFunc("/query", api.serveQuery)
+	static, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	return mux
+}
+
+type apiQueryResult struct {
+	Path  []string `json:"path"`
+	Key   string   `json:"key"`
+	Value string   `json:"value"`
+}
+
+/*
+serveQuery runs a query (the same as :query) from ?q=, returning up to
+?limit= results with the path of the bucket each one is in. "more" says
+there were more than that
+*/
+func (s *APIServer) serveQuery(w http.ResponseWriter, r *http.Request) {
+	if err := s.query(w, r); err != nil {
+		writeAPIError(w, err)
+	}
+}
+
+func (s *APIServer) query(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		return apiErrorf(http.StatusMethodNotAllowed, "%s isn't supported", r.Method)
+	}
+	keyEnc, valueEnc, err := parseAPIEncodings(r.URL.Query())
+	if err != nil {
+		return err
+	}
+	q, err := parseQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		return apiErrorf(http.StatusBadRequest, "%s", err)
+	}
+	limit := serveLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > serveMaxLimit {
+			return apiErrorf(http.StatusBadRequest, "limit has to be from 1 to %d", serveMaxLimit)
+		}
+	}
+	results := []apiQueryResult{}
+	more := false
+	var encErr error
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return q.run(tx, nil, func(qr QueryResult) bool {
+			if len(results) == limit {
+				more = true
+				return false
+			}
+			res := apiQueryResult{Path: qr.path[:len(qr.path)-1]}
+			if res.Key, encErr = apiEncode(keyEnc, []byte(qr.path[len(qr.path)-1])); encErr == nil {
+				res.Value, encErr = apiEncode(valueEnc, qr.value)
+			}
+			results = append(results, res)
+			return encErr == nil
+		})
+	})
+	if err == nil {
+		err = encErr
+	}
+	if err != nil {
+		return err
+	}
+	return writeAPIJSON(w, http.StatusOK, map[string]interface{}{"results": results, "more": more})
+}